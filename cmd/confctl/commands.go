@@ -0,0 +1,447 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/plan"
+	"gopkg.in/yaml.v3"
+)
+
+// env is the environment commands are executed in
+type env struct {
+	conn   *confd.Conn
+	in     io.Reader
+	out    io.Writer
+	format string
+}
+
+// command is a confctl command
+type command struct {
+	args string // synopsis of the arguments
+	help string
+	run  func(ctx context.Context, e *env, args []string) error
+}
+
+// commands contains all commands by name
+var commands map[string]command
+
+func init() {
+	// initialized in init, since help refers to the commands
+	commands = map[string]command{
+		"get":           {"<path>...", "get a node value", getCmd},
+		"set":           {"<path>... <value>", "set a node value (JSON or string)", setCmd},
+		"reset":         {"<path>...", "reset a node to its default", resetCmd},
+		"object get":    {"<ref>...", "get objects", objectGetCmd},
+		"object set":    {"<file>", "create or update objects (JSON or YAML)", objectSetCmd},
+		"object del":    {"<ref>...", "delete objects", objectDelCmd},
+		"object move":   {"<ref> <new ref>", "change the ref of an object", objectMoveCmd},
+		"object lock":   {"<ref>...", "lock objects", objectLockCmd},
+		"object unlock": {"<ref>...", "unlock objects", objectUnlockCmd},
+		"filter":        {"[flags]", "filter objects (see filter -h)", filterCmd},
+		"exports":       {"", "list the exported functions", exportsCmd},
+		"rights":        {"", "list the rights of the user", rightsCmd},
+		"meta":          {"[objects [class]|nodes|classes|types <class>]", "show meta information", metaCmd},
+		"plan":          {"<file>", "show the plan of a desired state document", planCmd},
+		"apply":         {"<file>", "apply a desired state document", applyCmd},
+		"dry-run":       {"<file>", "validate a desired state document without applying it", dryRunCmd},
+		"shell":         {"", "start an interactive shell", shellCmd},
+	}
+}
+
+// errUsage is returned if the command arguments are wrong
+var errUsage = errors.New("Wrong number of arguments")
+
+// errRejected is returned if confd rejected the changes of a dry run
+var errRejected = errors.New("Changes would be rejected")
+
+func getCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	value, err := e.conn.GetNodeValueContext(ctx, nodePath(args)...)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		value = 0 // GetNodeValue returns nil for 0, confd reports it as failure
+	}
+	return e.print(value)
+}
+
+func setCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	value := parseValue(args[len(args)-1])
+	ok, err := e.conn.SetNodeValueContext(ctx, value, nodePath(args[:len(args)-1])...)
+	return e.check(ctx, ok, err)
+}
+
+func resetCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	ok, err := e.conn.ResetNodeContext(ctx, nodePath(args)...)
+	return e.check(ctx, ok, err)
+}
+
+func objectGetCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	objects := make([]confd.AnyObject, len(args))
+	for i, ref := range args {
+		obj, err := e.conn.GetAnyObjectContext(ctx, ref)
+		if err != nil {
+			return err
+		}
+		objects[i] = *obj
+	}
+	if len(objects) == 1 {
+		return e.print(objects[0])
+	}
+	return e.print(objects)
+}
+
+func objectSetCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	objects, err := e.readObjects(args[0])
+	if err != nil {
+		return err
+	}
+
+	refs := make([]string, len(objects))
+	err = e.conn.WithWriteTransaction(ctx, func(tx *confd.Tx) error {
+		for i, obj := range objects {
+			refs[i], err = tx.SetObjectContext(ctx, obj, false)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return e.print(refs)
+}
+
+func objectDelCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	for _, ref := range args {
+		ok, err := e.conn.DelObjectContext(ctx, ref)
+		if err = e.check(ctx, ok, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func objectMoveCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	return e.conn.MoveObjectContext(ctx, args[0], args[1])
+}
+
+func objectLockCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	for _, ref := range args {
+		if err := e.conn.LockObjectContext(ctx, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func objectUnlockCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	for _, ref := range args {
+		if err := e.conn.UnlockObjectContext(ctx, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func filterCmd(ctx context.Context, e *env, args []string) error {
+	var types, defaults stringList
+	var conditions []condition
+	flags := flag.NewFlagSet("filter", flag.ContinueOnError)
+	class := flags.String("class", "", "class name")
+	flags.Var(&types, "type", "type name (repeatable)")
+	flags.Var(&defaults, "default", "attribute has the default value (repeatable)")
+	for _, op := range []string{"eq", "ne", "gt", "ge", "lt", "le", "match", "nomatch"} {
+		flags.Var(&conditionFlag{op: op, list: &conditions}, op,
+			"attribute condition name=value (repeatable)")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	filter := e.conn.FilterObjects()
+	if *class != "" {
+		filter.ClassName(*class)
+	}
+	for _, typ := range types {
+		filter.TypeName(typ)
+	}
+	for _, c := range conditions {
+		switch c.op {
+		case "eq":
+			filter.Eq(c.name, parseValue(c.value))
+		case "ne":
+			filter.Ne(c.name, parseValue(c.value))
+		case "gt":
+			filter.Gt(c.name, parseValue(c.value))
+		case "ge":
+			filter.Gte(c.name, parseValue(c.value))
+		case "lt":
+			filter.Lt(c.name, parseValue(c.value))
+		case "le":
+			filter.Lte(c.name, parseValue(c.value))
+		case "match":
+			filter.Matches(c.name, c.value)
+		case "nomatch":
+			filter.NotMatches(c.name, c.value)
+		}
+	}
+	for _, name := range defaults {
+		filter.Default(name)
+	}
+
+	objects, err := filter.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	return e.print(objects)
+}
+
+func exportsCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	exports, err := e.conn.ExportsContext(ctx)
+	if err != nil {
+		return err
+	}
+	return e.print(exports)
+}
+
+func rightsCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	rights, err := e.conn.GetRightsContext(ctx)
+	if err != nil {
+		return err
+	}
+	return e.print(rights)
+}
+
+func metaCmd(ctx context.Context, e *env, args []string) error {
+	what := "objects"
+	if len(args) > 0 {
+		what = args[0]
+	}
+	var value interface{}
+	var err error
+	switch {
+	case what == "objects" && len(args) <= 2:
+		var meta confd.ObjectMetaTree
+		meta, err = e.conn.GetMetaObjectsContext(ctx)
+		value = meta
+		if err == nil && len(args) == 2 {
+			value = meta[args[1]]
+		}
+	case what == "nodes" && len(args) == 1:
+		value, err = e.conn.GetMetaContext(ctx)
+	case what == "classes" && len(args) == 1:
+		value, err = e.conn.GetObjectClassesContext(ctx)
+	case what == "types" && len(args) == 2:
+		value, err = e.conn.GetObjectTypesContext(ctx, args[1])
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+	return e.print(value)
+}
+
+func planCmd(ctx context.Context, e *env, args []string) error {
+	p, err := e.plan(ctx, args)
+	if err != nil {
+		return err
+	}
+	return e.print(p)
+}
+
+func applyCmd(ctx context.Context, e *env, args []string) error {
+	p, err := e.plan(ctx, args)
+	if err != nil {
+		return err
+	}
+	if err = e.print(p); err != nil {
+		return err
+	}
+	return p.Apply(ctx, e.conn)
+}
+
+func dryRunCmd(ctx context.Context, e *env, args []string) error {
+	p, err := e.plan(ctx, args)
+	if err != nil {
+		return err
+	}
+	report, err := p.DryRun(ctx, e.conn)
+	if err != nil {
+		return err
+	}
+	if err = e.print(report); err != nil {
+		return err
+	}
+	if !report.Accepted() {
+		return errRejected
+	}
+	return nil
+}
+
+// plan computes the plan of the document file given in args
+func (e *env) plan(ctx context.Context, args []string) (*plan.Plan, error) {
+	if len(args) != 1 {
+		return nil, errUsage
+	}
+	data, err := e.readFile(args[0])
+	if err != nil {
+		return nil, err
+	}
+	doc, err := plan.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return plan.Compute(ctx, e.conn, doc)
+}
+
+// check returns the confd errors if a call wasn't successful
+func (e *env) check(ctx context.Context, ok bool, err error) error {
+	if err != nil || ok {
+		return err
+	}
+	errs, err := e.conn.ErrListContext(ctx)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return confd.ErrReturnCode
+}
+
+// readObjects reads one or a list of objects from a JSON or YAML file
+func (e *env) readObjects(file string) ([]confd.AnyObject, error) {
+	data, err := e.readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err = yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if _, isList := raw.([]interface{}); !isList {
+		raw = []interface{}{raw}
+	}
+	// normalize using json to get the same types as confd objects
+	data, err = json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var objects []confd.AnyObject
+	err = json.Unmarshal(data, &objects)
+	return objects, err
+}
+
+// readFile reads the file or the input if file is "-"
+func (e *env) readFile(file string) ([]byte, error) {
+	if file == "-" {
+		return io.ReadAll(e.in)
+	}
+	return os.ReadFile(file)
+}
+
+// nodePath converts the arguments into a node path
+func nodePath(args []string) []confd.NodeName {
+	path := make([]confd.NodeName, len(args))
+	for i, arg := range args {
+		path[i] = confd.NodeName(arg)
+	}
+	return path
+}
+
+// parseValue parses the value as JSON, values that are no valid JSON are
+// used as string
+func parseValue(str string) interface{} {
+	var value interface{}
+	if json.Unmarshal([]byte(str), &value) != nil {
+		return str
+	}
+	return value
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// condition is an attribute filter condition
+type condition struct {
+	op, name, value string
+}
+
+// conditionFlag is a repeatable name=value flag collecting conditions
+type conditionFlag struct {
+	op   string
+	list *[]condition
+}
+
+func (f *conditionFlag) String() string {
+	return ""
+}
+
+func (f *conditionFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("Expected name=value, got %q", value)
+	}
+	*f.list = append(*f.list, condition{op: f.op, name: name, value: val})
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command confctl gives command line access to the confd:
+//
+//	confctl get ntp status
+//	confctl -o yaml object get REF_DefaultInternalNetwork
+//	confctl filter -class network -type host -match name=^Google
+//	confctl shell
+//
+// The confd is selected using the -url flag or the CONFD_URL environment
+// variable and accepts the same urls as confd.NewConn, by default the local
+// confd is used with system privileges. Results are printed as table, JSON
+// or YAML (-o flag). Run confctl without arguments to list all commands.
+//
+// The shell keeps one session open and allows to call all exported
+// functions, to complete functions, nodes, classes, types and refs (tab)
+// and to use transactions. If the input isn't a terminal, the commands are
+// read line by line, e.g.:
+//
+//	printf 'begin\nset ntp status 1\ncommit\n' | confctl shell
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/threez/sophos-utm9/internal/cmdutil"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "confctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the global flags and executes the command given by args
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("confctl", flag.ContinueOnError)
+	flags.Usage = func() { printUsage(flags.Output()) }
+	url := cmdutil.URLFlag(flags)
+	format := flags.String("o", "table", "output format (table, json or yaml)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !validFormat(*format) {
+		return fmt.Errorf("Unknown output format %q", *format)
+	}
+	cmd, cmdArgs, ok := lookup(flags.Args())
+	if !ok {
+		printUsage(os.Stderr)
+		return flag.ErrHelp
+	}
+
+	conn, err := cmdutil.Connect(*url)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	e := &env{conn: conn, in: stdin, out: stdout, format: *format}
+	return cmd.run(ctx, e, cmdArgs)
+}
+
+// lookup returns the command and its arguments, commands have one or two
+// words (e.g. object get)
+func lookup(args []string) (command, []string, bool) {
+	if len(args) >= 2 {
+		if cmd, ok := commands[args[0]+" "+args[1]]; ok {
+			return cmd, args[2:], true
+		}
+	}
+	if len(args) >= 1 {
+		if cmd, ok := commands[args[0]]; ok {
+			return cmd, args[1:], true
+		}
+	}
+	return command{}, nil, false
+}
+
+// printUsage prints the usage including all commands
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: confctl [-url url] [-o table|json|yaml] <command> [args]\n\n")
+	fmt.Fprintf(w, "commands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		synopsis := strings.TrimSpace(name + " " + cmd.args)
+		fmt.Fprintf(w, "  %-32s %s\n", synopsis, cmd.help)
+	}
+}
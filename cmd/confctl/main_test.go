@@ -0,0 +1,218 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+func serverHelper() *confdtest.Server {
+	srv := confdtest.NewServer()
+	srv.AddObjects(
+		confd.AnyObject{
+			ObjectMeta: confd.ObjectMeta{Ref: "REF_NetHostDns", Class: "network", Type: "host"},
+			Data:       map[string]interface{}{"name": "DNS", "address": "8.8.8.8"},
+		},
+		confd.AnyObject{
+			ObjectMeta: confd.ObjectMeta{Ref: "REF_NetHostLocal", Class: "network", Type: "host"},
+			Data:       map[string]interface{}{"name": "Local", "address": "127.0.0.1"},
+		},
+	)
+	srv.SetNodes(map[string]interface{}{
+		"ntp": map[string]interface{}{"status": 1, "servers": []interface{}{"REF_NetHostDns"}},
+	})
+	return srv
+}
+
+// confctl runs the command against the server and returns the output
+func confctl(t *testing.T, srv *confdtest.Server, stdin string, args ...string) (string, error) {
+	var out bytes.Buffer
+	args = append([]string{"-url", srv.URL + "/system"}, args...)
+	err := run(context.Background(), args, strings.NewReader(stdin), &out)
+	return out.String(), err
+}
+
+func TestNodeCommands(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+
+	out, err := confctl(t, srv, "", "-o", "json", "get", "ntp", "servers")
+	assert.NoError(t, err)
+	assert.Equal(t, "[\n  \"REF_NetHostDns\"\n]\n", out)
+
+	out, err = confctl(t, srv, "", "get", "ntp")
+	assert.NoError(t, err)
+	assert.Equal(t, "servers  [\"REF_NetHostDns\"]\nstatus   1\n", out)
+
+	_, err = confctl(t, srv, "", "set", "ntp", "servers", `["REF_NetHostLocal"]`)
+	assert.NoError(t, err)
+	value, _ := srv.Node("ntp", "servers")
+	assert.Equal(t, []interface{}{"REF_NetHostLocal"}, value)
+
+	_, err = confctl(t, srv, "", "set", "ntp", "comment", "not json")
+	assert.NoError(t, err)
+	value, _ = srv.Node("ntp", "comment")
+	assert.Equal(t, "not json", value)
+
+	_, err = confctl(t, srv, "", "set", "unknown", "status", "1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "NODE_UNKNOWN")
+
+	_, err = confctl(t, srv, "", "reset", "ntp", "servers")
+	assert.NoError(t, err)
+	value, _ = srv.Node("ntp", "servers")
+	assert.Equal(t, []interface{}{"REF_NetHostDns"}, value)
+}
+
+func TestObjectCommands(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+
+	out, err := confctl(t, srv, "", "-o", "yaml", "object", "get", "REF_NetHostDns")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "ref: REF_NetHostDns\n")
+	assert.Contains(t, out, "data:\n  address: 8.8.8.8\n")
+
+	out, err = confctl(t, srv, "", "object", "get", "REF_NetHostDns")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "class         network\n")
+	assert.Contains(t, out, "data.address  8.8.8.8\n")
+
+	out, err = confctl(t, srv, "", "object", "get", "REF_NetHostDns", "REF_NetHostLocal")
+	assert.NoError(t, err)
+	assert.Equal(t, "REF               CLASS    TYPE  NAME\n"+
+		"REF_NetHostDns    network  host  DNS\n"+
+		"REF_NetHostLocal  network  host  Local\n", out)
+
+	out, err = confctl(t, srv, `
+- class: network
+  type: host
+  data: {name: New, address: 10.0.0.1}
+- ref: REF_NetHostLocal
+  data: {name: Local, address: 127.0.0.2}
+`, "object", "set", "-")
+	assert.NoError(t, err)
+	refs := strings.Fields(out)
+	assert.Len(t, refs, 2)
+	obj, ok := srv.Object(refs[0])
+	assert.True(t, ok)
+	assert.Equal(t, "New", obj.Data["name"])
+	obj, _ = srv.Object("REF_NetHostLocal")
+	assert.Equal(t, "127.0.0.2", obj.Data["address"])
+
+	path := filepath.Join(t.TempDir(), "host.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"class": "network", "type": "host",
+		"data": {"name": "New", "address": "10.0.0.2"}}`), 0600))
+	_, err = confctl(t, srv, "", "object", "set", path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "OBJECT_NAME_EXISTS")
+	assert.Len(t, srv.Objects(), 3)
+
+	_, err = confctl(t, srv, "", "object", "move", refs[0], "REF_NetHostNew")
+	assert.NoError(t, err)
+	_, err = confctl(t, srv, "", "object", "lock", "REF_NetHostNew")
+	assert.NoError(t, err)
+	obj, _ = srv.Object("REF_NetHostNew")
+	assert.NotEmpty(t, obj.Lock)
+	_, err = confctl(t, srv, "", "object", "unlock", "REF_NetHostNew")
+	assert.NoError(t, err)
+	_, err = confctl(t, srv, "", "object", "del", "REF_NetHostNew")
+	assert.NoError(t, err)
+	_, ok = srv.Object("REF_NetHostNew")
+	assert.False(t, ok)
+
+	_, err = confctl(t, srv, "", "object", "del", "REF_NetHostDns")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "OBJECT_DELETE_USED")
+}
+
+func TestFilterCommand(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+
+	out, err := confctl(t, srv, "", "filter", "-class", "network", "-type", "host",
+		"-match", "address=^127", "-ne", "name=DNS")
+	assert.NoError(t, err)
+	assert.Equal(t, "REF               CLASS    TYPE  NAME\n"+
+		"REF_NetHostLocal  network  host  Local\n", out)
+
+	_, err = confctl(t, srv, "", "filter", "-eq", "name")
+	assert.Error(t, err)
+}
+
+func TestInfoCommands(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	srv.SetRights("ADMIN")
+
+	out, err := confctl(t, srv, "", "exports")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, "NAME "))
+	assert.Contains(t, out, "get_object ")
+
+	out, err = confctl(t, srv, "", "rights")
+	assert.NoError(t, err)
+	assert.Equal(t, "ADMIN\n", out)
+
+	out, err = confctl(t, srv, "", "meta", "classes")
+	assert.NoError(t, err)
+	assert.Equal(t, "network\n", out)
+
+	_, err = confctl(t, srv, "", "meta", "types")
+	assert.Equal(t, errUsage, err)
+}
+
+func TestPlanCommands(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	doc := `
+objects:
+  - {class: network, type: host, name: DNS, data: {address: 9.9.9.9}}
+`
+	out, err := confctl(t, srv, doc, "plan", "-")
+	assert.NoError(t, err)
+	assert.Equal(t, `~ network/host "DNS" (REF_NetHostDns)
+    address: "8.8.8.8" => "9.9.9.9"
+Plan: 0 to create, 1 to update, 0 to delete.
+`, out)
+
+	out, err = confctl(t, srv, doc, "dry-run", "-")
+	assert.NoError(t, err)
+	assert.Equal(t, `~ REF_NetHostDns network/host "DNS"
+    address: "8.8.8.8" => "9.9.9.9"
+Dry run: 1 changes, 0 errors (0 fatal, 0 not acknowledged), accepted.
+`, out)
+	obj, _ := srv.Object("REF_NetHostDns")
+	assert.Equal(t, "8.8.8.8", obj.Data["address"])
+
+	_, err = confctl(t, srv, doc, "apply", "-")
+	assert.NoError(t, err)
+	obj, _ = srv.Object("REF_NetHostDns")
+	assert.Equal(t, "9.9.9.9", obj.Data["address"])
+}
+
+func TestUsage(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+
+	_, err := confctl(t, srv, "", "unknown")
+	assert.Equal(t, flag.ErrHelp, err)
+	_, err = confctl(t, srv, "", "-o", "xml", "rights")
+	assert.Error(t, err)
+
+	var out bytes.Buffer
+	printUsage(&out)
+	assert.Contains(t, out.String(), "  object get <ref>...")
+}
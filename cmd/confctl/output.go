@@ -0,0 +1,191 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validFormat returns true if the output format is supported
+func validFormat(format string) bool {
+	return format == "table" || format == "json" || format == "yaml"
+}
+
+// print writes the value in the output format of the environment
+func (e *env) print(value interface{}) error {
+	switch e.format {
+	case "json":
+		enc := json.NewEncoder(e.out)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(value)
+	case "yaml":
+		generic, err := normalize(value)
+		if err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(e.out)
+		enc.SetIndent(2)
+		if err = enc.Encode(generic); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		if str, ok := value.(fmt.Stringer); ok {
+			_, err := io.WriteString(e.out, str.String())
+			return err
+		}
+		generic, err := normalize(value)
+		if err != nil {
+			return err
+		}
+		return printTable(e.out, generic)
+	}
+}
+
+// normalize converts the value into generic maps, lists and scalars using
+// json, so that all confd types print the same way
+func normalize(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	err = json.Unmarshal(data, &generic)
+	return generic, err
+}
+
+// printTable prints lists of objects as rows with ref, class, type and name,
+// other lists of maps and maps of maps with a column per key, maps as key
+// value pairs and lists of scalars line by line
+func printTable(w io.Writer, value interface{}) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	switch tv := value.(type) {
+	case []interface{}:
+		if rows, ok := maps(tv); ok && len(rows) > 0 {
+			if isObject(rows[0]) {
+				fmt.Fprintln(tw, "REF\tCLASS\tTYPE\tNAME")
+				for _, obj := range rows {
+					data, _ := obj["data"].(map[string]interface{})
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", cell(obj["ref"]),
+						cell(obj["class"]), cell(obj["type"]), cell(data["name"]))
+				}
+				break
+			}
+			columns := keys(rows...)
+			fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+			for _, row := range rows {
+				fmt.Fprintln(tw, strings.Join(cells(row, columns), "\t"))
+			}
+			break
+		}
+		for _, item := range tv {
+			fmt.Fprintln(tw, cell(item))
+		}
+	case map[string]interface{}:
+		names := keys(tv)
+		if isObject(tv) {
+			data, _ := tv["data"].(map[string]interface{})
+			for _, name := range names {
+				if name != "data" {
+					fmt.Fprintf(tw, "%s\t%s\n", name, cell(tv[name]))
+				}
+			}
+			for _, name := range keys(data) {
+				fmt.Fprintf(tw, "data.%s\t%s\n", name, cell(data[name]))
+			}
+			break
+		}
+		values := make([]interface{}, len(names))
+		for i, name := range names {
+			values[i] = tv[name]
+		}
+		if rows, ok := maps(values); ok && len(rows) > 0 {
+			columns := keys(rows...)
+			fmt.Fprintln(tw, "NAME\t"+strings.ToUpper(strings.Join(columns, "\t")))
+			for i, row := range rows {
+				fmt.Fprintln(tw, names[i]+"\t"+strings.Join(cells(row, columns), "\t"))
+			}
+			break
+		}
+		for _, name := range names {
+			fmt.Fprintf(tw, "%s\t%s\n", name, cell(tv[name]))
+		}
+	case nil:
+	default:
+		fmt.Fprintln(tw, cell(tv))
+	}
+	return tw.Flush()
+}
+
+// maps returns the values as maps if all values are maps
+func maps(values []interface{}) ([]map[string]interface{}, bool) {
+	rows := make([]map[string]interface{}, len(values))
+	for i, value := range values {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		rows[i] = m
+	}
+	return rows, true
+}
+
+// isObject returns true if the map is a confd object
+func isObject(m map[string]interface{}) bool {
+	_, hasRef := m["ref"]
+	_, hasClass := m["class"]
+	_, hasData := m["data"]
+	return hasRef && hasClass && hasData
+}
+
+// keys returns the sorted union of the keys of all maps
+func keys(maps ...map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, m := range maps {
+		for name := range m {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cells returns the cells of the row for the columns
+func cells(row map[string]interface{}, columns []string) []string {
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = cell(row[column])
+	}
+	return values
+}
+
+// cell formats a value for a table cell, strings are printed as is, all
+// other values in compact json
+func cell(value interface{}) string {
+	switch tv := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return tv
+	default:
+		data, err := json.Marshal(tv)
+		if err != nil {
+			return fmt.Sprint(tv)
+		}
+		return string(data)
+	}
+}
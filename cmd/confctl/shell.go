@@ -0,0 +1,633 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/threez/sophos-utm9/confd"
+	"golang.org/x/term"
+)
+
+// maxHistory is the number of lines kept in the history
+const maxHistory = 1000
+
+// shellHelp describes the commands that only exist in the shell
+const shellHelp = `shell commands:
+  begin [read]                     begin a write (or read) transaction
+  commit                           commit the transaction
+  rollback                         roll the transaction back
+  errors                           list the errors of the session (e.g. of
+                                   the open transaction)
+  help [function]                  show the help or the doc of a function
+  exit                             leave the shell (rolls back open transactions)
+  <function> [args]...             call an exported function, arguments are
+                                   JSON or strings
+`
+
+// transactionFunctions can't be called directly, transactions are started
+// using begin so that the commands use the transaction
+var transactionFunctions = map[string]string{
+	"lock":   "begin",
+	"unlock": "rollback",
+	"freeze": "begin read",
+	"thaw":   "commit",
+}
+
+// errExit is returned by exec to leave the shell
+var errExit = errors.New("exit")
+
+// shell is an interactive confd session
+type shell struct {
+	env  *env
+	term *term.Terminal // nil if not interactive
+	conn *confd.Conn    // connection outside of the transaction
+	tx   *confd.Tx      // open transaction, its connection is used meanwhile
+	mode string         // mode of the transaction (read or write)
+
+	// lazy loaded completion data
+	exports map[string]confd.Export
+	classes []string
+	types   map[string][]string
+	nodes   map[string][]string
+	refs    []string
+}
+
+func shellCmd(ctx context.Context, e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	s := &shell{env: e}
+	defer s.rollback(ctx)
+
+	if f, ok := e.in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		return s.interactive(ctx, f)
+	}
+	return s.batch(ctx)
+}
+
+// interactive reads the commands from the terminal with completion and
+// history
+func (s *shell) interactive(ctx context.Context, f *os.File) error {
+	state, err := term.MakeRaw(int(f.Fd()))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = term.Restore(int(f.Fd()), state) }()
+
+	s.term = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{f, s.env.out}, s.prompt())
+	if width, height, err := term.GetSize(int(f.Fd())); err == nil {
+		_ = s.term.SetSize(width, height)
+	}
+	history := loadHistory(historyPath())
+	defer history.Close()
+	s.term.History = history
+	s.term.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		return s.complete(ctx, line, pos, key)
+	}
+	s.env.out = s.term
+
+	for {
+		line, err := s.term.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = s.exec(ctx, line)
+		if err == errExit {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(s.term, "error: %v\n", err)
+		}
+		s.term.SetPrompt(s.prompt())
+	}
+}
+
+// batch executes the commands line by line, e.g. from a script. The first
+// failing command stops the execution.
+func (s *shell) batch(ctx context.Context) error {
+	scanner := bufio.NewScanner(s.env.in)
+	for scanner.Scan() {
+		err := s.exec(ctx, scanner.Text())
+		if err == errExit {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// exec executes a single line
+func (s *shell) exec(ctx context.Context, line string) error {
+	args, err := splitLine(line)
+	if err != nil || len(args) == 0 {
+		return err
+	}
+	defer func() { s.refs = nil }() // refs might have changed
+
+	switch args[0] {
+	case "exit", "quit":
+		return errExit
+	case "help":
+		return s.help(ctx, args[1:])
+	case "begin":
+		return s.begin(ctx, args[1:])
+	case "commit", "rollback":
+		if s.tx == nil {
+			return fmt.Errorf("No transaction in progress")
+		}
+		if args[0] == "commit" {
+			err = s.tx.CommitContext(ctx)
+		} else {
+			err = s.tx.RollbackContext(ctx)
+		}
+		s.end()
+		return err
+	case "errors":
+		return s.errors(ctx, args[1:])
+	case "shell":
+		return fmt.Errorf("Already in the shell")
+	}
+
+	if cmd, cmdArgs, ok := lookup(args); ok {
+		return cmd.run(ctx, s.env, cmdArgs)
+	}
+	exports, err := s.loadExports(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := exports[args[0]]; !ok {
+		return fmt.Errorf("Unknown command or function %q", args[0])
+	}
+	if cmd, ok := transactionFunctions[args[0]]; ok {
+		return fmt.Errorf("Function %q can't be called directly, use %q "+
+			"(begin, commit and rollback manage transactions)", args[0], cmd)
+	}
+	params := make([]interface{}, len(args)-1)
+	for i, arg := range args[1:] {
+		params[i] = parseValue(arg)
+	}
+	result, err := s.env.conn.SimpleRequestContext(ctx, args[0], params...)
+	if err != nil {
+		return err
+	}
+	return s.env.print(*(result.(*interface{})))
+}
+
+// begin starts a transaction
+func (s *shell) begin(ctx context.Context, args []string) (err error) {
+	if s.tx != nil {
+		return fmt.Errorf("Transaction already in progress")
+	}
+	var tx *confd.Tx
+	switch {
+	case len(args) == 0:
+		tx, err = s.env.conn.BeginWriteTransactionContext(ctx)
+		s.mode = "write"
+	case len(args) == 1 && args[0] == "read":
+		tx, err = s.env.conn.BeginReadTransactionContext(ctx)
+		s.mode = "read"
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+	s.tx, s.conn = tx, s.env.conn
+	s.env.conn = tx.Conn // commands are executed inside of the transaction
+	return nil
+}
+
+// end switches back to the connection outside of the ended transaction
+func (s *shell) end() {
+	s.env.conn = s.conn
+	s.tx, s.conn = nil, nil
+}
+
+// rollback rolls back the open transaction if any, also if the shell was
+// left because the context is done
+func (s *shell) rollback(ctx context.Context) {
+	if s.tx != nil {
+		_ = s.tx.RollbackContext(context.WithoutCancel(ctx)) // nothing we can do about it
+		s.end()
+	}
+}
+
+// errors lists the errors confd collected for the session, they only exist
+// in the shell since every other command uses a new session
+func (s *shell) errors(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	errs, err := s.env.conn.ErrListContext(ctx)
+	if err != nil {
+		return err
+	}
+	return s.env.print(errs)
+}
+
+// prompt returns the prompt showing the transaction mode
+func (s *shell) prompt() string {
+	if s.tx != nil {
+		return "confd(" + s.mode + ")> "
+	}
+	return "confd> "
+}
+
+// help prints the shell help or the doc of the function
+func (s *shell) help(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		printUsage(s.env.out)
+		_, err := fmt.Fprintf(s.env.out, "\n%s", shellHelp)
+		return err
+	}
+	exports, err := s.loadExports(ctx)
+	if err != nil {
+		return err
+	}
+	export, ok := exports[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown function %q", args[0])
+	}
+	access := "read"
+	if export.Write {
+		access = "write"
+	}
+	_, err = fmt.Fprintf(s.env.out, "%s (%s, %s) rights: %s\n  %s\n", args[0],
+		export.Module, access, strings.Join(export.Rights, ", "), export.Doc)
+	return err
+}
+
+// complete completes the word before the cursor when tab is pressed. If
+// there are multiple candidates they are printed, if a function name is
+// completed its doc is printed.
+func (s *shell) complete(ctx context.Context, line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	head := line[:pos]
+	words, err := splitLine(head)
+	if err != nil {
+		return "", 0, false
+	}
+	current := ""
+	if len(words) > 0 && !strings.HasSuffix(head, " ") {
+		current = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	var matches []string
+	for _, candidate := range s.candidates(ctx, words, current) {
+		if strings.HasPrefix(candidate, current) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	sort.Strings(matches)
+
+	completion := commonPrefix(matches)
+	if len(matches) == 1 {
+		completion += " "
+		if export, ok := s.exports[matches[0]]; ok && len(words) == 0 {
+			s.notify("%s: %s\n", matches[0], export.Doc)
+		}
+	} else if completion == current {
+		s.notify("%s\n", strings.Join(matches, "  "))
+	}
+	newHead := head[:len(head)-len(current)] + completion
+	return newHead + line[pos:], len(newHead), true
+}
+
+// candidates returns the possible words after the given words
+func (s *shell) candidates(ctx context.Context, words []string, current string) []string {
+	if len(words) == 0 {
+		candidates := []string{"begin", "commit", "rollback", "errors", "help", "exit"}
+		for name := range commands {
+			first, _, _ := strings.Cut(name, " ")
+			candidates = append(candidates, first)
+		}
+		exports, _ := s.loadExports(ctx) // no function completion on errors
+		for name := range exports {
+			if _, ok := transactionFunctions[name]; !ok {
+				candidates = append(candidates, name)
+			}
+		}
+		return unique(candidates)
+	}
+	if strings.HasPrefix(current, "REF_") {
+		return s.loadRefs(ctx)
+	}
+
+	last := words[len(words)-1]
+	switch words[0] {
+	case "object":
+		if len(words) == 1 {
+			var subcommands []string
+			for name := range commands {
+				if sub, ok := strings.CutPrefix(name, "object "); ok {
+					subcommands = append(subcommands, sub)
+				}
+			}
+			return subcommands
+		}
+		return s.loadRefs(ctx)
+	case "get", "set", "reset":
+		return s.loadNodes(ctx, words[1:])
+	case "filter":
+		switch last {
+		case "-class":
+			return s.loadClasses(ctx)
+		case "-type":
+			for i := 1; i < len(words)-1; i++ {
+				if words[i] == "-class" {
+					return s.loadTypes(ctx, words[i+1])
+				}
+			}
+			return nil
+		}
+		return []string{"-class", "-type", "-default", "-eq", "-ne", "-gt",
+			"-ge", "-lt", "-le", "-match", "-nomatch"}
+	case "meta":
+		if len(words) == 1 {
+			return []string{"objects", "nodes", "classes", "types"}
+		}
+		if len(words) == 2 && (last == "objects" || last == "types") {
+			return s.loadClasses(ctx)
+		}
+	case "help":
+		exports, _ := s.loadExports(ctx) // no completion on errors
+		names := make([]string, 0, len(exports))
+		for name := range exports {
+			names = append(names, name)
+		}
+		return names
+	case "begin":
+		return []string{"read"}
+	}
+	return nil
+}
+
+// notify prints a message above the prompt
+func (s *shell) notify(format string, args ...interface{}) {
+	if s.term != nil {
+		fmt.Fprintf(s.term, format, args...)
+	}
+}
+
+func (s *shell) loadExports(ctx context.Context) (map[string]confd.Export, error) {
+	if s.exports == nil {
+		exports, err := s.env.conn.ExportsContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.exports = exports
+	}
+	return s.exports, nil
+}
+
+func (s *shell) loadClasses(ctx context.Context) []string {
+	if s.classes == nil {
+		s.classes, _ = s.env.conn.GetObjectClassesContext(ctx) // retried next time
+	}
+	return s.classes
+}
+
+func (s *shell) loadTypes(ctx context.Context, class string) []string {
+	if s.types == nil {
+		s.types = make(map[string][]string)
+	}
+	if _, ok := s.types[class]; !ok {
+		types, err := s.env.conn.GetObjectTypesContext(ctx, class)
+		if err != nil {
+			return nil
+		}
+		s.types[class] = types
+	}
+	return s.types[class]
+}
+
+func (s *shell) loadNodes(ctx context.Context, path []string) []string {
+	if s.nodes == nil {
+		s.nodes = make(map[string][]string)
+	}
+	key := strings.Join(path, " ")
+	if names, ok := s.nodes[key]; ok {
+		return names
+	}
+	var names []string
+	if len(path) == 0 {
+		meta, err := s.env.conn.GetMetaNodesContext(ctx)
+		if err != nil {
+			return nil
+		}
+		for name := range meta {
+			names = append(names, name)
+		}
+	} else {
+		nodes, err := s.env.conn.GetNodesContext(ctx, nodePath(path)...)
+		if err != nil {
+			return nil
+		}
+		for _, name := range nodes {
+			names = append(names, string(name))
+		}
+	}
+	s.nodes[key] = names
+	return names
+}
+
+func (s *shell) loadRefs(ctx context.Context) []string {
+	if s.refs == nil {
+		objects, err := s.env.conn.GetAllObjectsContext(ctx)
+		if err != nil {
+			return nil
+		}
+		s.refs = make([]string, len(objects))
+		for i, obj := range objects {
+			s.refs[i] = obj.Ref
+		}
+	}
+	return s.refs
+}
+
+// splitLine splits the line into words, words can be quoted using single
+// or double quotes, backslash escapes the next character outside of single
+// quotes
+func splitLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, escaped := false, false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("Unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// commonPrefix returns the longest common prefix of the words
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+func unique(words []string) []string {
+	seen := make(map[string]bool, len(words))
+	var result []string
+	for _, word := range words {
+		if !seen[word] {
+			seen[word] = true
+			result = append(result, word)
+		}
+	}
+	return result
+}
+
+// historyPath returns the path of the history file, CONFCTL_HISTORY or
+// ~/.confctl_history
+func historyPath() string {
+	if path := os.Getenv("CONFCTL_HISTORY"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".confctl_history")
+}
+
+// fileHistory is a term.History that is persisted in a file
+type fileHistory struct {
+	entries []string // oldest first
+	file    *os.File // nil if the history can't be persisted
+}
+
+// loadHistory loads the history from the file, if the file can't be used
+// the history is kept in memory only. Files exceeding maxHistory lines are
+// truncated to the most recent lines.
+func loadHistory(path string) *fileHistory {
+	h := new(fileHistory)
+	if path == "" {
+		return h
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				h.entries = append(h.entries, line)
+			}
+		}
+		if len(h.entries) > maxHistory {
+			h.entries = h.entries[len(h.entries)-maxHistory:]
+			data = []byte(strings.Join(h.entries, "\n") + "\n")
+			_ = os.WriteFile(path, data, 0600) // history is best effort
+		}
+	}
+	h.file, _ = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if h.file != nil {
+		_ = h.file.Chmod(0600) // files of older versions were world readable
+	}
+	return h
+}
+
+// Add adds the entry to the history, secrets are redacted before the entry is
+// written to the file
+func (h *fileHistory) Add(entry string) {
+	if strings.TrimSpace(entry) == "" {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[1:]
+	}
+	if h.file != nil {
+		_, _ = fmt.Fprintln(h.file, redactLine(entry)) // history is best effort
+	}
+}
+
+// secretRegexp matches the names of nodes and attributes containing secrets
+var secretRegexp = regexp.MustCompile(`(?i)pass|secret|psk|key`)
+
+// redactLine removes passwords of JSON values and the values of set
+// commands, if the node path names a secret
+func redactLine(line string) string {
+	line = confd.Redact(line)
+	args, err := splitLine(line)
+	if err != nil || len(args) < 3 || args[0] != "set" {
+		return line
+	}
+	for _, word := range args[1 : len(args)-1] {
+		if secretRegexp.MatchString(word) {
+			return strings.Join(append(args[:len(args)-1], "********"), " ")
+		}
+	}
+	return line
+}
+
+// Len returns the number of entries
+func (h *fileHistory) Len() int {
+	return len(h.entries)
+}
+
+// At returns the entry, 0 is the most recent entry
+func (h *fileHistory) At(idx int) string {
+	return h.entries[len(h.entries)-1-idx]
+}
+
+// Close closes the history file
+func (h *fileHistory) Close() {
+	if h.file != nil {
+		_ = h.file.Close()
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellBatch(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+
+	out, err := confctl(t, srv, `
+begin
+set ntp status 0
+get ntp status
+rollback
+get_object REF_NetHostDns
+get ntp status
+help get_object
+`, "-o", "json", "shell")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "0\n{\n  \"autoname\": 0,\n")
+	assert.Contains(t, out, "\"name\": \"DNS\"")
+	assert.Contains(t, out, "}\n1\nget_object (Objects, read) rights: \n")
+
+	_, err = confctl(t, srv, "begin\nset ntp status 0\nexit\nrollback\n", "shell")
+	assert.NoError(t, err)
+	value, _ := srv.Node("ntp", "status")
+	assert.Equal(t, float64(1), value)
+
+	_, err = confctl(t, srv, "begin\nset ntp status 0\n", "shell")
+	assert.NoError(t, err)
+	value, _ = srv.Node("ntp", "status")
+	assert.Equal(t, float64(1), value, "open transactions are rolled back")
+
+	_, err = confctl(t, srv, "commit\n", "shell")
+	assert.Error(t, err)
+	_, err = confctl(t, srv, "unknown_function\n", "shell")
+	assert.Error(t, err)
+	_, err = confctl(t, srv, "get 'ntp\n", "shell")
+	assert.Error(t, err)
+
+	calls := len(srv.Calls())
+	for _, function := range []string{"lock", "unlock", "freeze", "thaw"} {
+		_, err = confctl(t, srv, function+"\n", "shell")
+		assert.ErrorContains(t, err, "can't be called directly", function)
+		assert.NotContains(t, srv.Calls()[calls:], function)
+	}
+}
+
+func TestShellRollbackCanceled(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &shell{env: &env{conn: conn}}
+
+	assert.NoError(t, s.exec(ctx, "begin"))
+	assert.NoError(t, s.exec(ctx, "set ntp status 0"))
+	cancel()
+	s.rollback(ctx)
+	assert.Contains(t, srv.Calls(), "unlock", "the lock is released")
+	value, _ := srv.Node("ntp", "status")
+	assert.Equal(t, float64(1), value)
+}
+
+func TestShellErrors(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	ctx := context.Background()
+	var out strings.Builder
+	s := &shell{env: &env{conn: conn, out: &out, format: "json"}}
+
+	assert.NoError(t, s.exec(ctx, "begin"))
+	assert.Error(t, s.exec(ctx, "set unknown status 0"))
+	assert.NoError(t, s.exec(ctx, "errors"))
+	assert.Contains(t, out.String(), "NODE_UNKNOWN", "errors of the transaction are listed")
+	assert.NoError(t, s.exec(ctx, "rollback"))
+	assert.Equal(t, errUsage, s.exec(ctx, "errors all"))
+
+	_, err := confctl(t, srv, "", "errors")
+	assert.Equal(t, flag.ErrHelp, err, "errors is a shell command")
+}
+
+func TestShellComplete(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	ctx := context.Background()
+	s := &shell{env: &env{conn: conn}}
+
+	complete := func(line string) string {
+		newLine, _, ok := s.complete(ctx, line, len(line), '\t')
+		if !ok {
+			return line
+		}
+		return newLine
+	}
+
+	assert.Equal(t, "get_object", complete("get_obj"))
+	assert.Equal(t, "get_object_classes ", complete("get_object_c"))
+	assert.Equal(t, "object del ", complete("object d"))
+	assert.Equal(t, "object get REF_NetHost", complete("object get REF_"))
+	assert.Equal(t, "object get REF_NetHostLocal ", complete("object get REF_NetHostL"))
+	assert.Equal(t, "get ntp ", complete("get n"))
+	assert.Equal(t, "get ntp servers ", complete("get ntp se"))
+	assert.Equal(t, "filter -class network ", complete("filter -class n"))
+	assert.Equal(t, "filter -class network -type host ", complete("filter -class network -type "))
+	assert.Equal(t, "meta types network ", complete("meta types "))
+	assert.Equal(t, "begin read ", complete("begin "))
+	assert.Equal(t, "nothing", complete("nothing"))
+
+	line, pos, ok := s.complete(ctx, "get n status", 5, '\t')
+	assert.True(t, ok)
+	assert.Equal(t, "get ntp  status", line)
+	assert.Equal(t, 8, pos)
+
+	_, _, ok = s.complete(ctx, "get n", 5, 'x')
+	assert.False(t, ok)
+}
+
+func TestSplitLine(t *testing.T) {
+	words, err := splitLine(`set ntp "a b" 'c\d' e\ f ""`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"set", "ntp", "a b", `c\d`, "e f", ""}, words)
+
+	_, err = splitLine(`"open`)
+	assert.Error(t, err)
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	h := loadHistory(path)
+	h.Add("get ntp")
+	h.Add(" ")
+	h.Add("exports")
+	assert.Equal(t, 2, h.Len())
+	assert.Equal(t, "exports", h.At(0))
+	h.Close()
+
+	h = loadHistory(path)
+	defer h.Close()
+	assert.Equal(t, 2, h.Len())
+	assert.Equal(t, "get ntp", h.At(1))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestHistoryRedacted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	assert.NoError(t, os.WriteFile(path, nil, 0644))
+	h := loadHistory(path)
+	h.Add("set remote_access l2tp psk 'top secret'")
+	h.Add(`set smtp relay '{"password":"hidden","user":"mail"}'`)
+	h.Add("set ntp status 1")
+	assert.Equal(t, "set remote_access l2tp psk 'top secret'", h.At(2),
+		"the current session keeps the entries")
+	h.Close()
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "set remote_access l2tp psk ********\n"+
+		`set smtp relay '{"password":"********","user":"mail"}'`+"\n"+
+		"set ntp status 1\n", string(data))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestHistoryTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	var data strings.Builder
+	for i := 0; i < maxHistory+10; i++ {
+		fmt.Fprintf(&data, "get %d\n", i)
+	}
+	assert.NoError(t, os.WriteFile(path, []byte(data.String()), 0600))
+	h := loadHistory(path)
+	assert.Equal(t, maxHistory, h.Len())
+	assert.Equal(t, "get 10", h.At(maxHistory-1))
+	h.Add("exports")
+	h.Close()
+
+	h = loadHistory(path)
+	defer h.Close()
+	assert.Equal(t, maxHistory, h.Len())
+	assert.Equal(t, "exports", h.At(0))
+	assert.Equal(t, "get 11", h.At(maxHistory-1))
+}
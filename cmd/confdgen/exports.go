@@ -0,0 +1,341 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/internal/textutil"
+)
+
+// confdPackage is the import path of the confd package
+const confdPackage = "github.com/threez/sophos-utm9/confd"
+
+// readExports reads a get_exports dump
+func readExports(r io.Reader) (map[string]confd.Export, error) {
+	var exports map[string]confd.Export
+	err := json.NewDecoder(r).Decode(&exports)
+	return exports, err
+}
+
+// signature describes the params and the result of a confd function, the
+// types are Go types, types of the confd package are qualified with confd
+// (e.g. "confd.AnyObject"). An empty result means the result is ignored.
+type signature struct {
+	Params []signatureParam `json:"params"`
+	Result string           `json:"result"`
+}
+
+// signatureParam is a named param of a signature
+type signatureParam struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// readSignatures reads the signatures of the confd functions, they are
+// mapped by function name
+func readSignatures(r io.Reader) (map[string]signature, error) {
+	var signatures map[string]signature
+	err := json.NewDecoder(r).Decode(&signatures)
+	return signatures, err
+}
+
+// reservedParams are used by the generated methods
+var reservedParams = map[string]bool{
+	"c": true, "ctx": true, "result": true, "err": true, "context": true,
+	"confd": true,
+}
+
+// signatureType returns the type of the signature for the package, inside of
+// the confd package the qualifier is removed
+func signatureType(typ, pkg string) (string, error) {
+	expr, err := parser.ParseExpr(typ)
+	if err == nil {
+		expr, err = convertType(expr, pkg)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Invalid type %q: %v", typ, err)
+	}
+	var b strings.Builder
+	err = printer.Fprint(&b, token.NewFileSet(), expr)
+	return b.String(), err
+}
+
+// convertType checks that the type consists of builtin types and types of
+// the confd package (pointers, slices, arrays and maps of them) and removes
+// the confd qualifier inside of the confd package
+func convertType(expr ast.Expr, pkg string) (ast.Expr, error) {
+	var err error
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t, nil
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); !ok || x.Name != "confd" {
+			return nil, fmt.Errorf("only types of package confd can be used")
+		}
+		if pkg == "confd" {
+			return t.Sel, nil
+		}
+		return t, nil
+	case *ast.StarExpr:
+		t.X, err = convertType(t.X, pkg)
+		return t, err
+	case *ast.ArrayType:
+		t.Elt, err = convertType(t.Elt, pkg)
+		return t, err
+	case *ast.MapType:
+		if t.Key, err = convertType(t.Key, pkg); err != nil {
+			return nil, err
+		}
+		t.Value, err = convertType(t.Value, pkg)
+		return t, err
+	case *ast.InterfaceType:
+		if len(t.Methods.List) == 0 {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported type")
+}
+
+// transactionFunctions are never generated, transactions have to be started
+// using Begin*Transaction so that the session serializes them with other
+// calls
+var transactionFunctions = map[string]bool{
+	"lock": true, "unlock": true, "commit": true, "rollback": true,
+	"freeze": true, "thaw": true,
+}
+
+// generateExports generates a method and a context variant per export on
+// the type. If pkg is confd the methods are added to Conn, otherwise to the
+// wrapper type that embeds *confd.Conn. Exports that are denied, transaction
+// functions and exports whose names are in skip are not generated. The
+// dumps don't contain signatures, the params and results of exports without
+// signature are untyped.
+func generateExports(exports map[string]confd.Export, signatures map[string]signature, pkg, typ, source string, skip map[string]bool) ([]byte, error) {
+	for _, function := range textutil.SortedKeys(signatures) {
+		if _, ok := exports[function]; !ok {
+			return nil, fmt.Errorf("Signature of unknown function %s", function)
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by confdgen from %s. DO NOT EDIT.\n\n",
+		filepath.Base(source))
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	if pkg == "confd" {
+		fmt.Fprintf(&buf, "import \"context\"\n")
+	} else {
+		fmt.Fprintf(&buf, "import (\n\"context\"\n\n%q\n)\n", confdPackage)
+		fmt.Fprintf(&buf, "\n// %s adds the exported confd functions to confd.Conn\n", typ)
+		fmt.Fprintf(&buf, "type %s struct {\n\t*confd.Conn\n}\n", typ)
+	}
+
+	taken := make(map[string]bool, len(skip))
+	for name := range skip {
+		taken[name] = true
+	}
+	if pkg != "confd" {
+		taken["Conn"] = true // embedded field
+	}
+	for _, function := range textutil.SortedKeys(exports) {
+		export := exports[function]
+		method := goName(function)
+		if bool(export.Deny) || transactionFunctions[function] ||
+			taken[method] || taken[method+"Context"] {
+			continue
+		}
+		taken[method] = true
+		taken[method+"Context"] = true
+
+		fmt.Fprintf(&buf, "\n%s", exportDoc(method, function, export))
+		sig, typed := signatures[function]
+		if !typed {
+			fmt.Fprintf(&buf, "func (c *%s) %s(result interface{}, params ...interface{}) error {\n",
+				typ, method)
+			fmt.Fprintf(&buf, "\treturn c.%sContext(context.Background(), result, params...)\n}\n",
+				method)
+			fmt.Fprintf(&buf, "\n// %sContext is like %s but honours the context\n", method, method)
+			fmt.Fprintf(&buf, "func (c *%s) %sContext(ctx context.Context, result interface{}, params ...interface{}) error {\n",
+				typ, method)
+			fmt.Fprintf(&buf, "\treturn c.RequestContext(ctx, %q, result, params...)\n}\n",
+				function)
+			continue
+		}
+		if err := generateTypedExport(&buf, function, method, sig, pkg, typ); err != nil {
+			return nil, fmt.Errorf("Signature of %s: %v", function, err)
+		}
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("Formatting generated code failed: %v", err)
+	}
+	return src, nil
+}
+
+// generateTypedExport generates the method and its context variant using the
+// signature of the function
+func generateTypedExport(w io.Writer, function, method string, sig signature, pkg, typ string) error {
+	decls := make([]string, len(sig.Params))
+	names := make([]string, len(sig.Params))
+	seen := make(map[string]bool, len(sig.Params))
+	for i, param := range sig.Params {
+		if !token.IsIdentifier(param.Name) || reservedParams[param.Name] || seen[param.Name] {
+			return fmt.Errorf("Invalid param name %q", param.Name)
+		}
+		seen[param.Name] = true
+		t, err := signatureType(param.Type, pkg)
+		if err != nil {
+			return err
+		}
+		names[i] = param.Name
+		decls[i] = param.Name + " " + t
+	}
+	params := strings.Join(decls, ", ")
+	args := strings.Join(append([]string{"context.Background()"}, names...), ", ")
+	ctxParams := strings.Join(append([]string{"ctx context.Context"}, decls...), ", ")
+	resultArg := "&result"
+	if sig.Result == "" {
+		resultArg = "nil" // the result is ignored
+	}
+	callArgs := strings.Join(append([]string{"ctx", fmt.Sprintf("%q", function), resultArg}, names...), ", ")
+
+	if sig.Result == "" {
+		fmt.Fprintf(w, "func (c *%s) %s(%s) error {\n\treturn c.%sContext(%s)\n}\n",
+			typ, method, params, method, args)
+		fmt.Fprintf(w, "\n// %sContext is like %s but honours the context\n", method, method)
+		fmt.Fprintf(w, "func (c *%s) %sContext(%s) error {\n\treturn c.RequestContext(%s)\n}\n",
+			typ, method, ctxParams, callArgs)
+		return nil
+	}
+	result, err := signatureType(sig.Result, pkg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "func (c *%s) %s(%s) (%s, error) {\n\treturn c.%sContext(%s)\n}\n",
+		typ, method, params, result, method, args)
+	fmt.Fprintf(w, "\n// %sContext is like %s but honours the context\n", method, method)
+	fmt.Fprintf(w, "func (c *%s) %sContext(%s) (result %s, err error) {\n", typ, method, ctxParams, result)
+	fmt.Fprintf(w, "\terr = c.RequestContext(%s)\n\treturn\n}\n", callArgs)
+	return nil
+}
+
+// exportDoc returns the doc comment of the method
+func exportDoc(method, function string, export confd.Export) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// %s calls the confd function %s", method, function)
+	if doc := strings.TrimSpace(export.Doc); doc != "" {
+		for _, line := range strings.Split(doc, "\n") {
+			fmt.Fprintf(&b, "\n// %s", strings.TrimRight(line, " \t"))
+		}
+	}
+	fmt.Fprintf(&b, "\n//\n// Module: %s", export.Module)
+	if export.Write {
+		b.WriteString(" (write)")
+	}
+	if len(export.Rights) > 0 {
+		fmt.Fprintf(&b, ", rights: %s", strings.Join(export.Rights, ", "))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// connMethods returns the methods of confd.Conn
+func connMethods() map[string]bool {
+	methods := make(map[string]bool)
+	t := reflect.TypeOf(&confd.Conn{})
+	for i := 0; i < t.NumMethod(); i++ {
+		methods[t.Method(i).Name] = true
+	}
+	return methods
+}
+
+// declaredMethods returns the methods and fields of the type declared in
+// the go files of the directory, generated files and the output file are
+// ignored so that generated methods are not skipped on regeneration
+func declaredMethods(dir, typ, output string) (map[string]bool, error) {
+	methods := make(map[string]bool)
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") || sameFile(file, output) {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if ast.IsGenerated(f) {
+			continue
+		}
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv != nil && len(d.Recv.List) == 1 &&
+					receiverType(d.Recv.List[0].Type) == typ {
+					methods[d.Name.Name] = true
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok || ts.Name.Name != typ {
+						continue
+					}
+					if st, ok := ts.Type.(*ast.StructType); ok {
+						for _, field := range st.Fields.List {
+							for _, name := range field.Names {
+								methods[name.Name] = true
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return methods, nil
+}
+
+// receiverType returns the name of the receiver type
+func receiverType(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// sameFile returns true if both paths point to the same file
+func sameFile(a, b string) bool {
+	if b == "" {
+		return false
+	}
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const exportsDump = `{
+	"get_object": {"write": 0, "deny": 0, "module": "Objects", "rights": [], "doc": "Returns the object."},
+	"get_ip_address_list": {"write": 0, "deny": 0, "module": "Interfaces", "rights": ["ADMIN"],
+		"doc": "Returns the configured addresses.\nIncludes aliases."},
+	"set_ha_mode": {"write": 1, "deny": 0, "module": "HA", "rights": ["ADMIN", "HA"], "doc": ""},
+	"shutdown": {"write": 1, "deny": 1, "module": "System", "rights": [], "doc": "Shuts down"},
+	"lock": {"write": 1, "deny": 0, "module": "Config", "rights": [], "doc": ""},
+	"commit": {"write": 1, "deny": 0, "module": "Config", "rights": [], "doc": ""},
+	"freeze": {"write": 0, "deny": 0, "module": "Config", "rights": [], "doc": ""}
+}`
+
+func TestGenerateExports(t *testing.T) {
+	exports, err := readExports(strings.NewReader(exportsDump))
+	assert.NoError(t, err)
+
+	src, err := generateExports(exports, nil, "utm", "Client", "testdata/exports.json", connMethods())
+	assert.NoError(t, err)
+	_, err = parser.ParseFile(token.NewFileSet(), "gen.go", src, 0)
+	assert.NoError(t, err)
+
+	code := squeeze(string(src))
+	assert.True(t, strings.HasPrefix(code, "// Code generated by confdgen "+
+		"from exports.json. DO NOT EDIT.\n\npackage utm\n"))
+	for _, expected := range []string{
+		"type Client struct {\n *confd.Conn\n}",
+		"// GetIPAddressList calls the confd function get_ip_address_list\n" +
+			"// Returns the configured addresses.\n// Includes aliases.\n//\n" +
+			"// Module: Interfaces, rights: ADMIN\n" +
+			"func (c *Client) GetIPAddressList(result interface{}, params ...interface{}) error {\n" +
+			" return c.GetIPAddressListContext(context.Background(), result, params...)\n}",
+		"func (c *Client) GetIPAddressListContext(ctx context.Context, result interface{}, params ...interface{}) error {\n" +
+			" return c.RequestContext(ctx, \"get_ip_address_list\", result, params...)\n}",
+		"// Module: HA (write), rights: ADMIN, HA\nfunc (c *Client) SetHaMode(",
+	} {
+		assert.Contains(t, code, expected)
+	}
+	assert.NotContains(t, code, "GetObject(", "hand-written wrapper exists")
+	assert.NotContains(t, code, "Shutdown", "denied functions are skipped")
+	for _, method := range []string{"Lock(", "Commit(", "Freeze("} {
+		assert.NotContains(t, code, method, "transactions bypass the session")
+	}
+
+	src, err = generateExports(exports, nil, "confd", "Conn", "exports.json", nil)
+	assert.NoError(t, err)
+	code = string(src)
+	assert.Contains(t, code, "import \"context\"\n")
+	assert.Contains(t, code, "func (c *Conn) GetObject(")
+	assert.NotContains(t, code, "func (c *Conn) Lock(")
+	assert.NotContains(t, code, "type Conn")
+}
+
+const signaturesDump = `{
+	"get_object": {"params": [{"name": "ref", "type": "string"}], "result": "*confd.AnyObject"},
+	"get_ip_address_list": {"params": [{"name": "iface", "type": "string"}, {"name": "all", "type": "bool"}],
+		"result": "map[string][]confd.AnyObject"},
+	"set_ha_mode": {"params": [{"name": "mode", "type": "string"}]}
+}`
+
+func TestGenerateTypedExports(t *testing.T) {
+	exports, err := readExports(strings.NewReader(exportsDump))
+	assert.NoError(t, err)
+	signatures, err := readSignatures(strings.NewReader(signaturesDump))
+	assert.NoError(t, err)
+
+	src, err := generateExports(exports, signatures, "utm", "Client", "exports.json", connMethods())
+	assert.NoError(t, err)
+	_, err = parser.ParseFile(token.NewFileSet(), "gen.go", src, 0)
+	assert.NoError(t, err)
+	code := squeeze(string(src))
+	for _, expected := range []string{
+		"// Module: Interfaces, rights: ADMIN\n" +
+			"func (c *Client) GetIPAddressList(iface string, all bool) (map[string][]confd.AnyObject, error) {\n" +
+			" return c.GetIPAddressListContext(context.Background(), iface, all)\n}",
+		"func (c *Client) GetIPAddressListContext(ctx context.Context, iface string, all bool) " +
+			"(result map[string][]confd.AnyObject, err error) {\n" +
+			" err = c.RequestContext(ctx, \"get_ip_address_list\", &result, iface, all)\n return\n}",
+		"func (c *Client) SetHaMode(mode string) error {\n" +
+			" return c.SetHaModeContext(context.Background(), mode)\n}",
+		"func (c *Client) SetHaModeContext(ctx context.Context, mode string) error {\n" +
+			" return c.RequestContext(ctx, \"set_ha_mode\", nil, mode)\n}",
+	} {
+		assert.Contains(t, code, expected)
+	}
+	assert.NotContains(t, code, "interface{}")
+
+	src, err = generateExports(exports, signatures, "confd", "Conn", "exports.json", nil)
+	assert.NoError(t, err)
+	code = squeeze(string(src))
+	assert.Contains(t, code, "func (c *Conn) GetObject(ref string) (*AnyObject, error) {")
+	assert.Contains(t, code, "(result map[string][]AnyObject, err error)")
+
+	for _, sig := range []string{
+		`{"nope": {}}`,
+		`{"set_ha_mode": {"params": [{"name": "ctx", "type": "string"}]}}`,
+		`{"set_ha_mode": {"params": [{"name": "mode", "type": "time.Duration"}]}}`,
+		`{"set_ha_mode": {"params": [{"name": "mode", "type": "func()"}]}}`,
+		`{"set_ha_mode": {"result": "[]string{"}}`,
+	} {
+		signatures, err := readSignatures(strings.NewReader(sig))
+		assert.NoError(t, err)
+		_, err = generateExports(exports, signatures, "utm", "Client", "exports.json", connMethods())
+		assert.Error(t, err, sig)
+	}
+}
+
+func TestDeclaredMethods(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"conn.go": "package confd\n\ntype Conn struct{ URL string }\n\n" +
+			"func (c *Conn) GetObject() {}\nfunc (c Conn) ErrList() {}\n" +
+			"func (o *Other) Foo() {}\n",
+		"gen.go": "// Code generated by confdgen from exports.json. DO NOT EDIT.\n\n" +
+			"package confd\n\nfunc (c *Conn) Generated() {}\n",
+		"exports_gen.go": "package confd\n\nfunc (c *Conn) Output() {}\n",
+		"conn_test.go":   "package confd\n\nfunc (c *Conn) Test() {}\n",
+	}
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	methods, err := declaredMethods(dir, "Conn", filepath.Join(dir, "exports_gen.go"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"URL": true, "GetObject": true, "ErrList": true}, methods)
+}
@@ -0,0 +1,169 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command confdgen generates Go code from confd meta information dumps.
+//
+// The objects command generates a struct per confd class and type out of
+// the result of get_meta_objects (see confd.Conn.GetMetaObjects) saved as
+// JSON:
+//
+//	confdgen objects -pkg utm -o objects_gen.go meta.json
+//
+// The exports command generates a method per confd function out of the
+// result of get_exports (see confd.Conn.Exports) saved as JSON. Functions
+// that already have a hand-written wrapper and the transaction functions
+// (lock, commit, freeze, ...) are skipped. The dump contains no signatures,
+// they can be given as JSON file mapping function names to their params and
+// result:
+//
+//	{"get_object": {"params": [{"name": "ref", "type": "string"}], "result": "confd.AnyObject"}}
+//
+// Functions with signature get typed methods, the other methods take untyped
+// params and results. Methods are added to confd.Conn if the package is
+// confd, otherwise to a type embedding *confd.Conn:
+//
+//	confdgen exports -pkg utm -type Client -sig signatures.json -o exports_gen.go exports.json
+//
+// It is meant to be used with go generate:
+//
+//	//go:generate confdgen objects -pkg utm -o objects_gen.go meta.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "objects":
+		err = objectsCmd(os.Args[2:])
+	case "exports":
+		err = exportsCmd(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "confdgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: confdgen objects [flags] meta.json")
+	fmt.Fprintln(os.Stderr, "       confdgen exports [flags] exports.json")
+	os.Exit(2)
+}
+
+// objectsCmd generates the object structs
+func objectsCmd(args []string) error {
+	flags := flag.NewFlagSet("objects", flag.ExitOnError)
+	pkg := flags.String("pkg", "main", "package name of the generated file")
+	out := flags.String("o", "", "output file (default stdout)")
+	classes := flags.String("class", "", "comma separated list of classes "+
+		"to generate (default all)")
+	_ = flags.Parse(args) // exits on error
+	if flags.NArg() != 1 {
+		usage()
+	}
+
+	in, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	meta, err := readMeta(in)
+	if err != nil {
+		return err
+	}
+
+	var only []string
+	if *classes != "" {
+		only = strings.Split(*classes, ",")
+	}
+	src, err := generateObjects(meta, *pkg, flags.Arg(0), only)
+	if err != nil {
+		return err
+	}
+	return write(*out, src)
+}
+
+// exportsCmd generates the function wrappers
+func exportsCmd(args []string) error {
+	flags := flag.NewFlagSet("exports", flag.ExitOnError)
+	pkg := flags.String("pkg", "main", "package name of the generated file")
+	out := flags.String("o", "", "output file (default stdout)")
+	typ := flags.String("type", "Client", "name of the type embedding "+
+		"*confd.Conn (ignored for package confd)")
+	sigs := flags.String("sig", "", "JSON file with the signatures of the "+
+		"functions (optional)")
+	_ = flags.Parse(args) // exits on error
+	if flags.NArg() != 1 {
+		usage()
+	}
+
+	in, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	exports, err := readExports(in)
+	if err != nil {
+		return err
+	}
+	var signatures map[string]signature
+	if *sigs != "" {
+		f, err := os.Open(*sigs)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		if signatures, err = readSignatures(f); err != nil {
+			return err
+		}
+	}
+
+	// inside of the confd package the methods that are declared in the
+	// package are hand-written, outside all methods of the embedded Conn
+	skip := connMethods()
+	if *pkg == "confd" {
+		*typ = "Conn"
+		dir := "."
+		if *out != "" {
+			dir = filepath.Dir(*out)
+		}
+		skip, err = declaredMethods(dir, *typ, *out)
+		if err != nil {
+			return err
+		}
+	}
+	src, err := generateExports(exports, signatures, *pkg, *typ, flags.Arg(0), skip)
+	if err != nil {
+		return err
+	}
+	return write(*out, src)
+}
+
+// write the source to the file or stdout if file is empty
+func write(file string, src []byte) error {
+	var w io.Writer = os.Stdout
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+	_, err := w.Write(src)
+	return err
+}
@@ -0,0 +1,205 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/internal/textutil"
+)
+
+// readMeta reads a get_meta_objects dump
+func readMeta(r io.Reader) (confd.ObjectMetaTree, error) {
+	var meta confd.ObjectMetaTree
+	err := json.NewDecoder(r).Decode(&meta)
+	return meta, err
+}
+
+// generateObjects generates the go source containing a struct for every
+// class and type of the meta tree. If only is not empty, only the listed
+// classes are generated.
+func generateObjects(meta confd.ObjectMetaTree, pkg, source string, only []string) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by confdgen from %s. DO NOT EDIT.\n\n",
+		filepath.Base(source))
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	fmt.Fprintf(&buf, "import \"github.com/threez/sophos-utm9/confd\"\n")
+
+	names := newNamer()
+	for _, class := range textutil.SortedKeys(meta) {
+		if len(only) > 0 && !contains(only, class) {
+			continue
+		}
+		for _, typ := range textutil.SortedKeys(meta[class]) {
+			generateObject(&buf, names, class, typ, meta[class][typ])
+		}
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("Formatting generated code failed: %v", err)
+	}
+	return src, nil
+}
+
+// generateObject writes the struct of one class and type. Attributes that
+// are not set are omitted, so that they don't overwrite the values of confd
+// with zero values.
+func generateObject(buf *bytes.Buffer, names *namer, class, typ string, attrs confd.AttributeDefinition) {
+	name := names.unique(goName(class) + goName(typ))
+	fmt.Fprintf(buf, "\n// %s is the confd object of class %s and type %s\n",
+		name, class, typ)
+	fmt.Fprintf(buf, "type %s struct {\n\tconfd.ObjectMeta\n", name)
+	fmt.Fprintf(buf, "\tData %sData `json:\"data\"`\n}\n", name)
+
+	fmt.Fprintf(buf, "\n// %sData contains the attributes of %s\n", name, name)
+	fmt.Fprintf(buf, "// (nil and empty attributes are omitted)\n")
+	fmt.Fprintf(buf, "type %sData struct {\n", name)
+	fields := newNamer()
+	for _, attr := range textutil.SortedKeys(attrs) {
+		constraint := confd.AttrConstraint(attrs[attr])
+		if strings.HasPrefix(attr, "_") || constraint.NameTemplate != "" {
+			continue // templates and meta keys are no attributes
+		}
+		if doc := fieldDoc(constraint); doc != "" {
+			fmt.Fprintf(buf, "\t// %s\n", doc)
+		}
+		fmt.Fprintf(buf, "\t%s %s `json:%q`\n", fields.unique(goName(attr)),
+			fieldType(constraint), attr+",omitempty")
+	}
+	fmt.Fprintf(buf, "}\n")
+
+	fmt.Fprintf(buf, "\n// New%s returns a new %s/%s object\n", name, class, typ)
+	fmt.Fprintf(buf, "func New%s() *%s {\n", name, name)
+	fmt.Fprintf(buf, "\treturn &%s{ObjectMeta: confd.ObjectMeta{Class: %q, Type: %q}}\n}\n",
+		name, class, typ)
+}
+
+// fieldType returns the type of the struct field, scalar attributes are
+// pointers to distinguish zero values from unset attributes
+func fieldType(c confd.AttrConstraint) string {
+	typ := goType(c)
+	if strings.HasPrefix(typ, "[]") || strings.HasPrefix(typ, "map[") ||
+		typ == "interface{}" {
+		return typ
+	}
+	return "*" + typ
+}
+
+// goType returns the go type of the attribute
+func goType(c confd.AttrConstraint) string {
+	switch strings.ToUpper(c.ISA) {
+	case "BOOL", "BOOLEAN":
+		return "confd.Bool"
+	case "INT", "INTEGER":
+		return "int64"
+	case "REF", "STRING":
+		return "string"
+	case "ARRAY":
+		if c.Type == "" {
+			return "[]interface{}"
+		}
+		return "[]" + goType(confd.AttrConstraint{ISA: c.Type})
+	case "HASH":
+		return "map[string]" + valueType(c.Values)
+	case "":
+		return "interface{}"
+	default:
+		// all other confd types (IP, MAC, ...) are strings
+		return "string"
+	}
+}
+
+// valueType returns the go type of the hash values
+func valueType(values interface{}) string {
+	m, ok := values.(map[string]interface{})
+	if !ok {
+		return "interface{}"
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "interface{}"
+	}
+	var c confd.AttrConstraint
+	if json.Unmarshal(data, &c) != nil || c.ISA == "" {
+		return "interface{}"
+	}
+	return goType(c)
+}
+
+// fieldDoc documents the constraints of references
+func fieldDoc(c confd.AttrConstraint) string {
+	if c.Class == "" {
+		return ""
+	}
+	doc := fmt.Sprintf("references %s objects", c.Class)
+	if len(c.Types) > 0 {
+		doc += fmt.Sprintf(" of type %s", strings.Join(c.Types, ", "))
+	}
+	return doc
+}
+
+// initialisms are written in upper case (see golint)
+var initialisms = map[string]bool{
+	"ACL": true, "API": true, "DNS": true, "HTTP": true, "HTTPS": true,
+	"ID": true, "IP": true, "JSON": true, "MAC": true, "SSH": true,
+	"SSL": true, "TCP": true, "TLS": true, "TTL": true, "UDP": true,
+	"UID": true, "URI": true, "URL": true, "VPN": true,
+}
+
+// goName converts a confd name (e.g. interface_network) into an exported
+// go identifier (e.g. InterfaceNetwork)
+func goName(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, part := range parts {
+		if initialisms[strings.ToUpper(part)] {
+			b.WriteString(strings.ToUpper(part))
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	str := b.String()
+	if str == "" || !unicode.IsLetter(rune(str[0])) {
+		str = "X" + str
+	}
+	return str
+}
+
+// namer makes generated names unique by appending a number
+type namer map[string]bool
+
+func newNamer() *namer {
+	n := make(namer)
+	return &n
+}
+
+func (n *namer) unique(name string) string {
+	candidate := name
+	for i := 2; (*n)[candidate]; i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	(*n)[candidate] = true
+	return candidate
+}
+
+func contains(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"go/parser"
+	"go/token"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+)
+
+const metaDump = `{
+	"network": {
+		"host": {
+			"name": {"_isa": "STRING", "_default": ""},
+			"address": {"_isa": "IP", "_default": "0.0.0.0"},
+			"resolved": {"_isa": "BOOL", "_default": 0},
+			"interface": {"_isa": "REF", "_class": "interface", "_types": ["ethernet", "vlan"]},
+			"duids": {"_isa": "ARRAY", "_type": "STRING"},
+			"hostnames": {"_isa": "HASH", "_values": {"_isa": "INT"}},
+			"_name": "Host [%name%]"
+		},
+		"interface_network": {
+			"name": {"_isa": "STRING"}
+		}
+	},
+	"packetfilter": {
+		"rule": {
+			"sources": {"_isa": "ARRAY", "_type": "REF", "_class": "network"},
+			"ttl": {"_isa": "INT"},
+			"log_id": {"_isa": "INT"},
+			"options": {"_isa": "HASH"}
+		}
+	}
+}`
+
+func TestGenerateObjects(t *testing.T) {
+	meta, err := readMeta(strings.NewReader(metaDump))
+	assert.NoError(t, err)
+
+	src, err := generateObjects(meta, "utm", "testdata/meta.json", nil)
+	assert.NoError(t, err)
+	_, err = parser.ParseFile(token.NewFileSet(), "gen.go", src, 0)
+	assert.NoError(t, err)
+
+	code := squeeze(string(src))
+	assert.True(t, strings.HasPrefix(code, "// Code generated by confdgen "+
+		"from meta.json. DO NOT EDIT.\n\npackage utm\n"))
+	for _, expected := range []string{
+		"type NetworkHost struct {\n confd.ObjectMeta\n" +
+			" Data NetworkHostData `json:\"data\"`\n}",
+		"Address *string `json:\"address,omitempty\"`",
+		"Resolved *confd.Bool `json:\"resolved,omitempty\"`",
+		"// references interface objects of type ethernet, vlan\n" +
+			" Interface *string `json:\"interface,omitempty\"`",
+		"Duids []string `json:\"duids,omitempty\"`",
+		"Hostnames map[string]int64 `json:\"hostnames,omitempty\"`",
+		"type NetworkInterfaceNetwork struct",
+		"Sources []string `json:\"sources,omitempty\"`",
+		"TTL *int64 `json:\"ttl,omitempty\"`",
+		"LogID *int64 `json:\"log_id,omitempty\"`",
+		"Options map[string]interface{} `json:\"options,omitempty\"`",
+		"func NewPacketfilterRule() *PacketfilterRule {\n" +
+			" return &PacketfilterRule{ObjectMeta: confd.ObjectMeta{" +
+			"Class: \"packetfilter\", Type: \"rule\"}}\n}",
+	} {
+		assert.Contains(t, code, expected)
+	}
+	assert.NotContains(t, code, "Host [%name%]")
+
+	src, err = generateObjects(meta, "utm", "meta.json", []string{"packetfilter"})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(src), "NetworkHost")
+	assert.Contains(t, string(src), "PacketfilterRule")
+}
+
+var update = flag.Bool("update", false, "update objects_gen_test.go")
+
+// TestGeneratedObjects keeps objects_gen_test.go up to date, the file is
+// compiled with the tests to type-check the generated code
+func TestGeneratedObjects(t *testing.T) {
+	meta, err := readMeta(strings.NewReader(metaDump))
+	assert.NoError(t, err)
+	src, err := generateObjects(meta, "main", "meta.json", nil)
+	assert.NoError(t, err)
+	if *update {
+		assert.NoError(t, os.WriteFile("objects_gen_test.go", src, 0644))
+	}
+	gen, err := os.ReadFile("objects_gen_test.go")
+	assert.NoError(t, err)
+	assert.Equal(t, string(src), string(gen), "run go test -update")
+}
+
+func TestGeneratedObjectsJSON(t *testing.T) {
+	// zero values that are set are kept, unset attributes are omitted
+	ttl, resolved := int64(0), confd.Bool(false)
+	rule := NewPacketfilterRule()
+	rule.Ref = "REF_PacRule"
+	rule.Data.TTL = &ttl
+	rule.Data.Sources = []string{"REF_NetHost"}
+	data, err := json.Marshal(rule.Data)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"ttl":0,"sources":["REF_NetHost"]}`, string(data))
+
+	host := NewNetworkHost()
+	host.Data.Resolved = &resolved
+	data, err = json.Marshal(host)
+	assert.NoError(t, err)
+	var decoded NetworkHost
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, *host, decoded)
+
+	// objects returned by confd can be decoded
+	var obj NetworkHost
+	assert.NoError(t, json.Unmarshal([]byte(`{"ref":"REF_NetHost","class":"network",
+		"type":"host","data":{"name":"Host","address":"10.0.0.1","resolved":1,
+		"hostnames":{"host.example.com":3600}}}`), &obj))
+	assert.Equal(t, "REF_NetHost", obj.Ref)
+	assert.Equal(t, "10.0.0.1", *obj.Data.Address)
+	assert.True(t, bool(*obj.Data.Resolved))
+	assert.Nil(t, obj.Data.Interface)
+	assert.Equal(t, map[string]int64{"host.example.com": 3600}, obj.Data.Hostnames)
+}
+
+func TestGoName(t *testing.T) {
+	assert.Equal(t, "InterfaceNetwork", goName("interface_network"))
+	assert.Equal(t, "SourceIP", goName("source_ip"))
+	assert.Equal(t, "X8021q", goName("8021q"))
+	assert.Equal(t, "IpsecConnection", goName("ipsec-connection"))
+}
+
+var blanks = regexp.MustCompile(`[ \t]+`)
+
+// squeeze removes the gofmt alignment of the generated code
+func squeeze(code string) string {
+	return blanks.ReplaceAllString(code, " ")
+}
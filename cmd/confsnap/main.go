@@ -0,0 +1,184 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command confsnap exports and restores confd configuration snapshots (see
+// package snapshot):
+//
+//	confsnap export -o utm.json
+//	confsnap restore -prune utm.json
+//	confsnap diff -format unified utm.json
+//
+// The confd is selected using the -url flag or the CONFD_URL environment
+// variable and accepts the same urls as confd.NewConn, by default the local
+// confd is used with system privileges.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/snapshot"
+	"github.com/threez/sophos-utm9/internal/cmdutil"
+)
+
+const usage = `usage: confsnap <command> [flags]
+
+commands:
+  export [-o file]           export a snapshot (default stdout)
+  restore [-prune] file      restore a snapshot ("-" reads stdin)
+  diff [-format text|json|unified] [-exit-code] old [new]
+                             compare two snapshots or a snapshot with the
+                             live configuration
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err == errDifferences {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "confsnap: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command given by args
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return flag.ErrHelp
+	}
+
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	url := cmdutil.URLFlag(flags)
+	switch args[0] {
+	case "export":
+		out := flags.String("o", "", "output file (default stdout)")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		return export(ctx, *url, *out, stdout)
+	case "restore":
+		prune := flags.Bool("prune", false, "delete objects that are not "+
+			"part of the snapshot")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return fmt.Errorf("restore requires a snapshot file")
+		}
+		return restore(ctx, *url, flags.Arg(0), stdin,
+			snapshot.RestoreOptions{Prune: *prune})
+	case "diff":
+		format := flags.String("format", "text", "output format (text, json "+
+			"or unified)")
+		exitCode := flags.Bool("exit-code", false, "exit with 1 if there "+
+			"are differences")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() < 1 || flags.NArg() > 2 {
+			return fmt.Errorf("diff requires one or two snapshot files")
+		}
+		return diff(ctx, *url, flags.Args(), *format, *exitCode, stdout)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return flag.ErrHelp
+	}
+}
+
+// export writes a snapshot to the file or w if file is empty
+func export(ctx context.Context, url, file string, w io.Writer) error {
+	conn, err := cmdutil.Connect(url)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	snap, err := snapshot.Take(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if file != "" {
+		return snap.Save(file)
+	}
+	return snap.Write(w)
+}
+
+// restore restores the snapshot of the file or r if file is "-"
+func restore(ctx context.Context, url, file string, r io.Reader, opts snapshot.RestoreOptions) error {
+	var snap *snapshot.Snapshot
+	var err error
+	if file == "-" {
+		snap, err = snapshot.Read(r)
+	} else {
+		snap, err = snapshot.Load(file)
+	}
+	if err != nil {
+		return err
+	}
+
+	conn, err := cmdutil.Connect(url)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	return snap.Restore(ctx, conn, opts)
+}
+
+// errDifferences is returned by diff if -exit-code is set
+var errDifferences = errors.New("Snapshots differ")
+
+// diff compares the snapshot files, if only one file is given, it is
+// compared with the live configuration
+func diff(ctx context.Context, url string, files []string, format string, exitCode bool, w io.Writer) error {
+	old, err := snapshot.Load(files[0])
+	if err != nil {
+		return err
+	}
+	var cur *snapshot.Snapshot
+	newName := files[0] + " (live)"
+	if len(files) == 2 {
+		newName = files[1]
+		cur, err = snapshot.Load(files[1])
+	} else {
+		var conn *confd.Conn
+		conn, err = cmdutil.Connect(url)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = conn.Close() }()
+		cur, err = snapshot.Take(ctx, conn)
+	}
+	if err != nil {
+		return err
+	}
+
+	delta := snapshot.Diff(old, cur)
+	switch format {
+	case "text":
+		err = delta.WriteText(w)
+	case "json":
+		err = delta.WriteJSON(w)
+	case "unified":
+		err = delta.WriteUnified(w, files[0], newName)
+	default:
+		return fmt.Errorf("Unknown format %q", format)
+	}
+	if err == nil && exitCode && !delta.Empty() {
+		err = errDifferences
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+func TestExportRestore(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	srv.AddObjects(confd.AnyObject{
+		ObjectMeta: confd.ObjectMeta{Ref: "REF_NetHostDns", Class: "network", Type: "host"},
+		Data:       map[string]interface{}{"name": "DNS", "address": "8.8.8.8"},
+	})
+	srv.SetNodes(map[string]interface{}{"timeout": 300})
+	url := srv.URL + "/system"
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "utm.json")
+
+	var out bytes.Buffer
+	assert.NoError(t, run(ctx, []string{"export", "-url", url}, nil, &out))
+	assert.Contains(t, out.String(), `"ref": "REF_NetHostDns"`)
+	assert.NoError(t, run(ctx, []string{"export", "-url", url, "-o", path}, nil, nil))
+
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	_, err := conn.SetNodeValue(60, "timeout")
+	assert.NoError(t, err)
+	_, err = conn.DelObject("REF_NetHostDns")
+	assert.NoError(t, err)
+
+	assert.NoError(t, run(ctx, []string{"restore", "-url", url, path}, nil, nil))
+	value, _ := srv.Node("timeout")
+	assert.Equal(t, float64(300), value)
+	_, ok := srv.Object("REF_NetHostDns")
+	assert.True(t, ok)
+
+	_, err = conn.SetNodeValue(60, "timeout")
+	assert.NoError(t, err)
+	assert.NoError(t, run(ctx, []string{"restore", "-url", url, "-"}, &out, nil))
+	value, _ = srv.Node("timeout")
+	assert.Equal(t, float64(300), value)
+
+	assert.Error(t, run(ctx, []string{"restore", "-url", url}, nil, nil))
+}
+
+func TestDiff(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	srv.SetNodes(map[string]interface{}{"timeout": 300})
+	url := srv.URL + "/system"
+	ctx := context.Background()
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")
+
+	assert.NoError(t, run(ctx, []string{"export", "-url", url, "-o", a}, nil, nil))
+	var out bytes.Buffer
+	assert.NoError(t, run(ctx, []string{"diff", "-url", url, "-exit-code", a}, nil, &out))
+	assert.Equal(t, "", out.String())
+
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	_, err := conn.SetNodeValue(60, "timeout")
+	assert.NoError(t, err)
+
+	assert.Equal(t, errDifferences, run(ctx, []string{"diff", "-url", url,
+		"-exit-code", a}, nil, &out))
+	assert.Equal(t, "~ node timeout: 300 => 60\n", out.String())
+
+	assert.NoError(t, run(ctx, []string{"export", "-url", url, "-o", b}, nil, nil))
+	out.Reset()
+	assert.NoError(t, run(ctx, []string{"diff", "-format", "unified", a, b}, nil, &out))
+	assert.Equal(t, "--- "+a+"\n+++ "+b+"\n@@ node timeout @@\n"+
+		"-timeout: 300\n+timeout: 60\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, run(ctx, []string{"diff", "-format", "json", a, b}, nil, &out))
+	assert.Contains(t, out.String(), `"path": [`)
+
+	assert.Error(t, run(ctx, []string{"diff", "-format", "xml", a, b}, nil, &out))
+}
@@ -0,0 +1,313 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// Interaction is a recorded request/response pair
+type Interaction struct {
+	Method   string          `json:"method"`
+	Params   json.RawMessage `json:"params"`
+	Response json.RawMessage `json:"response"` // complete json rpc response
+}
+
+// Cassette contains recorded interactions
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette reads the cassette from the file at path
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cassette := new(Cassette)
+	err = json.Unmarshal(data, cassette)
+	if err != nil {
+		return nil, err
+	}
+	return cassette, nil
+}
+
+// Save writes the cassette to the file at path
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Recorder is a Transport that records all interactions passing through
+// the wrapped transport into a cassette. Passwords and other secrets (see
+// Redact) in the params and responses are redacted the same way they are in
+// logs, session ids are replaced by a placeholder. The cassette is written
+// to Path every time the transport is closed or Save is called.
+//
+//	conn.Transport = confd.NewRecorder(conn.Transport, "testdata/session.json")
+type Recorder struct {
+	Transport        // Transport that is recorded
+	Path      string // Path of the cassette file
+	mu        sync.Mutex
+	cassette  Cassette
+}
+
+// NewRecorder creates a new recorder wrapping the passed transport
+func NewRecorder(transport Transport, path string) *Recorder {
+	return &Recorder{Transport: transport, Path: path}
+}
+
+// ConnectContext connects the wrapped transport
+func (r *Recorder) ConnectContext(ctx context.Context, url *url.URL) error {
+	return connectTransport(ctx, r.Transport, url)
+}
+
+// RoundTrip executes the round trip using the wrapped transport and records
+// the interaction
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var rpc request
+	err = json.Unmarshal(reqBody, &rpc)
+	if err != nil {
+		return nil, err
+	}
+	recorded, err := redactResponse(rpc.Method, bytes.TrimSpace(respBody))
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Method:   rpc.Method,
+		Params:   redactParams(rpc.Params),
+		Response: recorded,
+	})
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// Close closes the wrapped transport and saves the cassette
+func (r *Recorder) Close() error {
+	err := r.Transport.Close()
+	if serr := r.Save(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// Save writes the recorded interactions to the cassette file
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cassette.Save(r.Path)
+}
+
+// Cassette returns a copy of the recorded interactions
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Cassette{
+		Interactions: append([]Interaction(nil), r.cassette.Interactions...),
+	}
+}
+
+// Replayer is a Transport that serves the recorded interactions of a
+// cassette. Requests are matched by method and params, interactions with
+// the same method and params are served in recording order. Once all of
+// them were served, the last one is repeated. The session options passed
+// to new (e.g. the client name) differ between runs, therefore new is
+// matched by method only.
+type Replayer struct {
+	cassette  *Cassette
+	mu        sync.Mutex
+	served    map[int]bool
+	connected bool
+}
+
+// NewReplayer creates a replayer for the cassette file at path
+func NewReplayer(path string) (*Replayer, error) {
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewCassetteReplayer(cassette), nil
+}
+
+// NewCassetteReplayer creates a replayer for the passed cassette
+func NewCassetteReplayer(cassette *Cassette) *Replayer {
+	return &Replayer{cassette: cassette, served: make(map[int]bool)}
+}
+
+// Connect doesn't connect anywhere, the replayer is always available
+func (r *Replayer) Connect(url *url.URL) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connected = true
+	return nil
+}
+
+// IsConnected returns true after connect was called
+func (r *Replayer) IsConnected() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connected
+}
+
+// Close the replayer
+func (r *Replayer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connected = false
+	return nil
+}
+
+// RoundTrip serves the recorded response matching the request
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	var rpc request
+	err = json.Unmarshal(reqBody, &rpc)
+	if err != nil {
+		return nil, err
+	}
+	params := redactParams(rpc.Params)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	match := -1
+	for i, interaction := range r.cassette.Interactions {
+		if interaction.Method != rpc.Method {
+			continue
+		}
+		if rpc.Method != "new" &&
+			!bytes.Equal(compactJSON(interaction.Params), compactJSON(params)) {
+			continue
+		}
+		match = i
+		if !r.served[i] {
+			break
+		}
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("No recorded interaction for %s(%s)",
+			rpc.Method, params)
+	}
+	r.served[match] = true
+
+	// the response has to carry the id of the request
+	var resp map[string]interface{}
+	err = json.Unmarshal(r.cassette.Interactions[match].Response, &resp)
+	if err != nil {
+		return nil, err
+	}
+	resp["id"] = rpc.ID
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// readBody reads the complete body and replaces it with an in memory copy
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil {
+		return nil, nil
+	}
+	data, err := io.ReadAll(*body)
+	_ = (*body).Close() // ignore close errors
+	if err != nil {
+		return nil, err
+	}
+	*body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// sidPlaceholder replaces session ids in cassettes
+const sidPlaceholder = `"********"`
+
+// sidRegexp matches the session id in the options passed to new
+var sidRegexp = regexp.MustCompile(`"SID":("[^"]*"|[0-9]+)`)
+
+// redactParams removes passwords and session ids from the params
+func redactParams(params *json.RawMessage) json.RawMessage {
+	if params == nil {
+		return json.RawMessage("null")
+	}
+	return redactJSON(*params)
+}
+
+// redactResponse removes passwords and session ids from the response, the
+// result of get_SID is the session id
+func redactResponse(method string, body []byte) (json.RawMessage, error) {
+	if method == "get_SID" {
+		var resp map[string]json.RawMessage
+		err := json.Unmarshal(body, &resp)
+		if err != nil {
+			return nil, err
+		}
+		if result, ok := resp["result"]; ok && string(result) != "null" {
+			resp["result"] = json.RawMessage(sidPlaceholder)
+		}
+		body, err = json.Marshal(resp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return redactJSON(body), nil
+}
+
+// redactJSON replaces passwords, other secrets and session ids with
+// placeholders
+func redactJSON(data []byte) json.RawMessage {
+	data = safeSecretRegexp.ReplaceAll(data, []byte(safeSecretReplacement))
+	return json.RawMessage(sidRegexp.ReplaceAll(data, []byte(`"SID":`+sidPlaceholder)))
+}
+
+// compactJSON returns the json without insignificant whitespace
+func compactJSON(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
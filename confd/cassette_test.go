@@ -0,0 +1,138 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	srv := confdtest.NewServer()
+	srv.AddObjects(confd.AnyObject{
+		ObjectMeta: confd.ObjectMeta{Ref: "REF_Host", Class: "network", Type: "host"},
+		Data:       map[string]interface{}{"name": "Host"},
+	}, confd.AnyObject{
+		ObjectMeta: confd.ObjectMeta{Ref: "REF_User", Class: "aaa", Type: "user"},
+		Data:       map[string]interface{}{"name": "User", "password": "topsecret"},
+	})
+
+	// record
+	conn, err := confd.NewConn(srv.URL + "/system")
+	assert.NoError(t, err)
+	conn.Options.Password = "secret"
+	recorder := confd.NewRecorder(conn.Transport, path)
+	conn.Transport = recorder
+	obj, err := conn.GetAnyObject("REF_Host")
+	assert.NoError(t, err)
+	assert.Equal(t, "Host", obj.Data["name"])
+	_, err = conn.SimpleRequest("foobar")
+	assert.Error(t, err)
+	_, err = conn.GetAnyObject("REF_User")
+	assert.NoError(t, err)
+	for _, interaction := range recorder.Cassette().Interactions {
+		if interaction.Method == "get_SID" {
+			assert.JSONEq(t, `"********"`, string(result(t, interaction.Response)))
+		}
+	}
+	assert.NoError(t, conn.Close())
+	srv.Close()
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	assert.NotContains(t, string(data), "topsecret")
+	assert.Contains(t, string(data), "get_object")
+
+	// replay
+	replayer, err := confd.NewReplayer(path)
+	assert.NoError(t, err)
+	conn, err = confd.NewConn("http://127.0.0.1:1/system")
+	assert.NoError(t, err)
+	conn.Options.Password = "secret"
+	conn.Options.Name = "other-client"
+	conn.Transport = replayer
+	obj, err = conn.GetAnyObject("REF_Host")
+	assert.NoError(t, err)
+	assert.Equal(t, "Host", obj.Data["name"])
+	_, err = conn.SimpleRequest("foobar")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "FUNCTION_UNKNOWN")
+
+	_, err = conn.GetAnyObject("REF_Unknown")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "No recorded interaction for get_object")
+	assert.NoError(t, conn.Close())
+}
+
+func TestRecordSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	srv.AddObjects(confd.AnyObject{
+		ObjectMeta: confd.ObjectMeta{Ref: "REF_Site", Class: "ipsec", Type: "site_to_site"},
+		Data: map[string]interface{}{
+			"name": "Site", "psk": "s3cr3t-psk", "private_key": "s3cr3t-pem",
+			"Shared_Secret": "s3cr3t-shared", "key": "s3cr3t-key", "public_key": "",
+		},
+	})
+
+	conn := srv.Conn()
+	recorder := confd.NewRecorder(conn.Transport, path)
+	conn.Transport = recorder
+	_, err := conn.GetAnyObject("REF_Site")
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "s3cr3t")
+	assert.Contains(t, string(data), `"psk": "********"`)
+	assert.Contains(t, string(data), `"name": "Site"`)
+	assert.Contains(t, string(data), `"public_key": ""`, "empty values are kept")
+
+	assert.Equal(t, `{"Password": "********", "user": "admin"}`,
+		confd.Redact(`{"Password": "pa\"ss", "user": "admin"}`))
+}
+
+func TestRecordSessionID(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	conn := srv.Conn()
+	assert.NoError(t, conn.Connect())
+	sid := conn.Options.SID
+	assert.NotNil(t, sid)
+
+	// the session is reused with the next connect
+	recorder := confd.NewRecorder(conn.Transport, filepath.Join(t.TempDir(), "cassette.json"))
+	conn.Transport = recorder
+	assert.NoError(t, conn.Close())
+	_, err := conn.SimpleRequest("get_SID")
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+
+	methods := make(map[string]confd.Interaction)
+	for _, interaction := range recorder.Cassette().Interactions {
+		methods[interaction.Method] = interaction
+	}
+	assert.Contains(t, string(methods["new"].Params), `"SID":"********"`)
+	assert.JSONEq(t, `"********"`, string(result(t, methods["get_SID"].Response)))
+}
+
+// result returns the result of the recorded response
+func result(t *testing.T, response json.RawMessage) json.RawMessage {
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+	}
+	assert.NoError(t, json.Unmarshal(response, &resp))
+	return resp.Result
+}
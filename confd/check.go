@@ -0,0 +1,131 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrReadOnly is returned if a function that modifies the configuration is
+// called on a read only connection (see Conn.ReadOnly)
+type ErrReadOnly struct {
+	Method string
+}
+
+func (e *ErrReadOnly) Error() string {
+	return fmt.Sprintf("Function %s modifies the configuration, the "+
+		"connection is read only", e.Method)
+}
+
+// Is returns true for ErrPermissionDenied
+func (e *ErrReadOnly) Is(target error) bool {
+	return target == ErrPermissionDenied
+}
+
+// ErrInsufficientRights is returned if the user lacks rights to call the
+// function (see Conn.CheckRights)
+type ErrInsufficientRights struct {
+	Method  string
+	Missing []string // rights the user is missing, empty if denied
+}
+
+func (e *ErrInsufficientRights) Error() string {
+	if len(e.Missing) == 0 {
+		return fmt.Sprintf("Function %s is denied", e.Method)
+	}
+	return fmt.Sprintf("Function %s requires the rights %s", e.Method,
+		strings.Join(e.Missing, ", "))
+}
+
+// Is returns true for ErrPermissionDenied
+func (e *ErrInsufficientRights) Is(target error) bool {
+	return target == ErrPermissionDenied
+}
+
+// ExportAccess describes if the current user can call the exported function
+type ExportAccess struct {
+	Name    string
+	Export  Export
+	Allowed bool
+	Missing []string // rights the user is missing
+}
+
+// AccessReport lists all exported functions and if the current user can
+// call them, sorted by name
+func (c *Conn) AccessReport() ([]ExportAccess, error) {
+	return c.AccessReportContext(context.Background())
+}
+
+// AccessReportContext is like AccessReport but honours the context
+func (c *Conn) AccessReportContext(ctx context.Context) ([]ExportAccess, error) {
+	exports, err := c.cachedExports(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rights, err := c.cachedRights(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := make([]ExportAccess, 0, len(exports))
+	for name, export := range exports {
+		missing := missingRights(export, rights)
+		report = append(report, ExportAccess{
+			Name:    name,
+			Export:  export,
+			Allowed: !bool(export.Deny) && len(missing) == 0,
+			Missing: missing,
+		})
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Name < report[j].Name })
+	return report, nil
+}
+
+// checkExport refuses calls of write exports on read only connections and
+// calls the user lacks rights for before they are send. Functions that are
+// not exported are passed to the confd which reports them as unknown.
+func (c *Conn) checkExport(ctx context.Context, method string) error {
+	if (!c.ReadOnly && !c.CheckRights) ||
+		method == "get_exports" || method == "get_rights" {
+		return nil
+	}
+	exports, err := c.cachedExports(ctx)
+	if err != nil {
+		return err
+	}
+	export, ok := exports[method]
+	if !ok {
+		return nil
+	}
+	if c.ReadOnly && bool(export.Write) {
+		return &ErrReadOnly{Method: method}
+	}
+	if c.CheckRights {
+		if bool(export.Deny) {
+			return &ErrInsufficientRights{Method: method}
+		}
+		rights, err := c.cachedRights(ctx)
+		if err != nil {
+			return err
+		}
+		if missing := missingRights(export, rights); len(missing) > 0 {
+			return &ErrInsufficientRights{Method: method, Missing: missing}
+		}
+	}
+	return nil
+}
+
+// missingRights returns the rights of the export the user doesn't have
+func missingRights(export Export, rights []string) []string {
+	var missing []string
+	for _, right := range export.Rights {
+		if !containsString(rights, right) {
+			missing = append(missing, right)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+func TestReadOnly(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	conn.ReadOnly = true
+
+	_, err := conn.GetObjectClasses()
+	assert.NoError(t, err)
+
+	_, err = conn.SetObject(object("network", "host", map[string]interface{}{
+		"name": "Host",
+	}), false)
+	var rerr *confd.ErrReadOnly
+	assert.True(t, errors.As(err, &rerr))
+	assert.ErrorIs(t, err, confd.ErrPermissionDenied)
+	assert.Equal(t, "set_object", rerr.Method)
+	assert.Empty(t, srv.Objects())
+
+	_, err = conn.BeginWriteTransaction()
+	assert.True(t, errors.As(err, &rerr))
+	assert.Equal(t, "lock", rerr.Method)
+
+	tx, err := conn.BeginReadTransaction()
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+
+	assert.NotContains(t, srv.Calls(), "set_object")
+	assert.NotContains(t, srv.Calls(), "lock")
+	count := 0
+	for _, call := range srv.Calls() {
+		if call == "get_exports" {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count, "exports are cached")
+}
+
+func TestReadOnlyUsesExports(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	srv.SetExports(map[string]confd.Export{
+		"get_exports":        {Module: "Base"},
+		"get_object_classes": {Module: "Objects", Write: true},
+	})
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	_, err := conn.GetObjectClasses()
+	assert.NoError(t, err, "only read only connections are checked")
+
+	conn.ReadOnly = true
+	_, err = conn.GetObjectClasses()
+	assert.EqualError(t, err, "Function get_object_classes modifies the "+
+		"configuration, the connection is read only")
+
+	// functions that are not exported are left to the confd
+	_, err = conn.SimpleRequest("get_SID")
+	assert.NoError(t, err)
+}
+
+func TestCheckRights(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	srv.SetExports(map[string]confd.Export{
+		"get_exports":        {Module: "Base"},
+		"get_rights":         {Module: "Base"},
+		"get_object_classes": {Module: "Objects", Rights: []string{"ADMIN"}},
+		"get_objects":        {Module: "Objects", Rights: []string{"NETWORK", "ADMIN", "AUDIT"}},
+		"shutdown":           {Module: "System", Deny: true},
+	})
+	srv.SetRights("NETWORK")
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	conn.CheckRights = true
+
+	_, err := conn.GetObjectClasses()
+	var ierr *confd.ErrInsufficientRights
+	assert.True(t, errors.As(err, &ierr))
+	assert.ErrorIs(t, err, confd.ErrPermissionDenied)
+	assert.Equal(t, "get_object_classes", ierr.Method)
+	assert.Equal(t, []string{"ADMIN"}, ierr.Missing)
+	assert.NotContains(t, srv.Calls(), "get_object_classes")
+
+	_, err = conn.GetAllObjects()
+	assert.EqualError(t, err, "Function get_objects requires the rights ADMIN, AUDIT")
+
+	_, err = conn.SimpleRequest("shutdown")
+	assert.EqualError(t, err, "Function shutdown is denied")
+
+	report, err := conn.AccessReport()
+	assert.NoError(t, err)
+	assert.Len(t, report, 5)
+	assert.Equal(t, "get_exports", report[0].Name)
+	assert.True(t, report[0].Allowed)
+	assert.Equal(t, confd.ExportAccess{
+		Name:    "get_objects",
+		Export:  confd.Export{Module: "Objects", Rights: []string{"NETWORK", "ADMIN", "AUDIT"}},
+		Missing: []string{"ADMIN", "AUDIT"},
+	}, report[2])
+	assert.False(t, report[4].Allowed)
+
+	count := 0
+	for _, call := range srv.Calls() {
+		if call == "get_rights" {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count, "rights are cached")
+}
@@ -13,7 +13,7 @@ import (
 
 func TestConcurrentAccess(t *testing.T) {
 	// Starts three go routines, that concurrently access the connection
-	conn := connHelper()
+	conn := connHelper(t)
 	defer func() { _ = conn.Close() }()
 	wg := sync.WaitGroup{}
 
@@ -38,7 +38,7 @@ func TestConcurrentAccess(t *testing.T) {
 
 func TestConcurrentTransactionAccess(t *testing.T) {
 	// Starts three go routines, that concurrently access the connection
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 	wg := sync.WaitGroup{}
 	sid, err := conn.SimpleRequest("get_SID")
@@ -48,9 +48,11 @@ func TestConcurrentTransactionAccess(t *testing.T) {
 	work := func(conn *Conn) {
 		for i := 0; i < 3; i++ {
 			tx, err := conn.BeginWriteTransaction()
-			assert.NoError(t, err)
+			if !assert.NoError(t, err) {
+				continue
+			}
 			for i := 0; i < 1; i++ {
-				value, err := conn.SimpleRequest("get_SID")
+				value, err := tx.SimpleRequest("get_SID")
 				assert.NoError(t, err)
 				assert.Equal(t, sid, value)
 			}
@@ -0,0 +1,94 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package confdotel creates OpenTelemetry spans for confd connections.
+//
+//	conn.Tracer = confdotel.NewTracer(nil) // uses the global provider
+//
+// Calls are traced as children of the span in the context of the call,
+// e.g. GetObjectContext(ctx, ref). Connects and transport round trips are
+// children of the calls. Transactions are traced from begin until commit or
+// rollback, the lock, commit and rollback calls are children of the
+// transaction.
+package confdotel
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/threez/sophos-utm9/confd"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the tracer of the package
+const instrumentationName = "github.com/threez/sophos-utm9/confd/confdotel"
+
+// Tracer implements confd.Tracer
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer creates a tracer using the provider, if the provider is nil the
+// global provider is used
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return &Tracer{tracer: provider.Tracer(instrumentationName)}
+}
+
+// Start implements confd.Tracer, calls and round trips are client spans
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, confd.Span) {
+	kind := trace.SpanKindInternal
+	if name == confd.SpanCall || name == confd.SpanRoundTrip {
+		kind = trace.SpanKindClient
+	}
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(kind))
+	return ctx, &Span{span: span}
+}
+
+// Span implements confd.Span
+type Span struct {
+	span trace.Span
+}
+
+// SetAttribute implements confd.Span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.span.SetAttributes(attr(key, value))
+}
+
+// Context implements confd.Span
+func (s *Span) Context(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(ctx, s.span)
+}
+
+// End implements confd.Span, errors are recorded and set the error status
+func (s *Span) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+// attr converts the value into an attribute
+func attr(key string, value interface{}) attribute.KeyValue {
+	switch tv := value.(type) {
+	case string:
+		return attribute.String(key, tv)
+	case int:
+		return attribute.Int(key, tv)
+	case int64:
+		return attribute.Int64(key, tv)
+	case bool:
+		return attribute.Bool(key, tv)
+	case []string:
+		return attribute.StringSlice(key, tv)
+	default:
+		return attribute.String(key, fmt.Sprint(tv))
+	}
+}
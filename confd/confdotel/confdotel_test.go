@@ -0,0 +1,133 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confdotel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func tracerHelper() (*Tracer, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return NewTracer(provider), recorder
+}
+
+// attrs returns the attributes of the span as map
+func attrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestTracer(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	tracer, recorder := tracerHelper()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	conn.Tracer = tracer
+
+	ctx, parent := tracer.tracer.Start(context.Background(), "parent")
+	_, err := conn.GetObjectClassesContext(ctx)
+	assert.NoError(t, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name()
+	}
+	assert.Equal(t, []string{
+		confd.SpanRoundTrip, // new
+		confd.SpanRoundTrip, // get_SID
+		confd.SpanConnect,
+		confd.SpanRoundTrip, // get_object_classes
+		confd.SpanCall,
+		"parent",
+	}, names)
+
+	call := spans[4]
+	assert.Equal(t, parent.SpanContext().SpanID(), call.Parent().SpanID())
+	assert.Equal(t, trace.SpanKindClient, call.SpanKind())
+	assert.Equal(t, call.SpanContext().SpanID(), spans[2].Parent().SpanID(), "connect is a child of the call")
+	assert.Equal(t, spans[2].SpanContext().SpanID(), spans[0].Parent().SpanID(), "new is a child of connect")
+	assert.Equal(t, call.SpanContext().SpanID(), spans[3].Parent().SpanID())
+	a := attrs(call)
+	assert.Equal(t, "get_object_classes", a[confd.AttrMethod].AsString())
+	assert.Equal(t, int64(2), a[confd.AttrRequestID].AsInt64())
+	assert.Len(t, a[confd.AttrSIDHash].AsString(), 16)
+	assert.Equal(t, codes.Unset, call.Status().Code)
+}
+
+func TestTracerErrors(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	tracer, recorder := tracerHelper()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	conn.Tracer = tracer
+
+	_, err := conn.SimpleRequest("del_object", "REF_Missing")
+	assert.Error(t, err)
+
+	var call sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == confd.SpanCall && attrs(span)[confd.AttrMethod].AsString() == "del_object" {
+			call = span
+		}
+	}
+	if assert.NotNil(t, call) {
+		assert.Equal(t, codes.Error, call.Status().Code)
+		assert.Equal(t, []string{"OBJECT_UNKNOWN"}, attrs(call)[confd.AttrErrors].AsStringSlice())
+		assert.Len(t, call.Events(), 1, "error is recorded")
+	}
+}
+
+func TestTracerTransaction(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	tracer, recorder := tracerHelper()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	conn.Tracer = tracer
+
+	tx, err := conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	_, err = tx.GetObjectClasses()
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+
+	var txSpan sdktrace.ReadOnlySpan
+	children := make(map[string]bool)
+	for _, span := range recorder.Ended() {
+		if span.Name() == confd.SpanTransaction {
+			txSpan = span
+		}
+	}
+	if !assert.NotNil(t, txSpan) {
+		return
+	}
+	for _, span := range recorder.Ended() {
+		if span.Name() == confd.SpanCall && span.Parent().SpanID() == txSpan.SpanContext().SpanID() {
+			children[attrs(span)[confd.AttrMethod].AsString()] = true
+		}
+	}
+	assert.Equal(t, map[string]bool{"lock": true, "commit": true}, children)
+	a := attrs(txSpan)
+	assert.Equal(t, confd.TxWrite, a[confd.AttrTxKind].AsString())
+	assert.Equal(t, confd.TxCommit, a[confd.AttrTxOutcome].AsString())
+}
@@ -0,0 +1,172 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package confdprom collects prometheus metrics of confd connections.
+//
+//	collector := confdprom.NewCollector()
+//	prometheus.MustRegister(collector)
+//	conn.Observer = collector
+//
+// The duration buckets reach up to the 10 seconds after which confd kills
+// its workers, so that slow confd instances show up before calls fail.
+package confdprom
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/threez/sophos-utm9/confd"
+)
+
+const namespace = "confd_client"
+
+// buckets of the duration histograms in seconds
+var buckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 7.5, 10}
+
+// Collector implements confd.Observer and prometheus.Collector, one collector
+// can be shared by multiple connections
+type Collector struct {
+	calls         *prometheus.CounterVec
+	callDuration  *prometheus.HistogramVec
+	returnErrors  *prometheus.CounterVec
+	roundTrip     prometheus.Histogram
+	roundTripErrs prometheus.Counter
+	queueWait     prometheus.Histogram
+	connects      *prometheus.CounterVec
+	lockWait      *prometheus.HistogramVec
+	transactions  *prometheus.HistogramVec
+}
+
+// NewCollector creates a new collector
+func NewCollector() *Collector {
+	return &Collector{
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "calls_total",
+			Help:      "Number of confd calls by method and result.",
+		}, []string{"method", "result"}),
+		callDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "call_duration_seconds",
+			Help:      "Duration of confd calls including retries and error handling.",
+			Buckets:   buckets,
+		}, []string{"method"}),
+		returnErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "return_errors_total",
+			Help:      "Number of empty responses and 0 return codes by method.",
+		}, []string{"method", "error"}),
+		roundTrip: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "round_trip_duration_seconds",
+			Help:      "Duration of transport round trips.",
+			Buckets:   buckets,
+		}),
+		roundTripErrs: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "round_trip_errors_total",
+			Help:      "Number of failed transport round trips.",
+		}),
+		queueWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "queue_wait_seconds",
+			Help:      "Time messages waited for the connection worker.",
+			Buckets:   buckets,
+		}),
+		connects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "connects_total",
+			Help:      "Number of transport connects including reconnects by result.",
+		}, []string{"result"}),
+		lockWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "transaction_lock_wait_seconds",
+			Help:      "Time waited for the transaction lock of the connection.",
+			Buckets:   buckets,
+		}, []string{"kind"}),
+		transactions: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "transaction_duration_seconds",
+			Help:      "Duration of transactions by kind and outcome.",
+			Buckets:   buckets,
+		}, []string{"kind", "outcome"}),
+	}
+}
+
+// collectors returns all collectors of the collector
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{c.calls, c.callDuration, c.returnErrors,
+		c.roundTrip, c.roundTripErrs, c.queueWait, c.connects, c.lockWait,
+		c.transactions}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+// ObserveCall implements confd.Observer
+func (c *Collector) ObserveCall(method string, duration time.Duration, err error) {
+	c.calls.WithLabelValues(method, result(err)).Inc()
+	c.callDuration.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+// ObserveReturnError implements confd.Observer
+func (c *Collector) ObserveReturnError(method string, err error) {
+	label := "return_code"
+	if errors.Is(err, confd.ErrEmptyResponse) {
+		label = "empty_response"
+	}
+	c.returnErrors.WithLabelValues(method, label).Inc()
+}
+
+// ObserveRoundTrip implements confd.Observer
+func (c *Collector) ObserveRoundTrip(duration time.Duration, err error) {
+	c.roundTrip.Observe(duration.Seconds())
+	if err != nil {
+		c.roundTripErrs.Inc()
+	}
+}
+
+// ObserveQueueWait implements confd.Observer
+func (c *Collector) ObserveQueueWait(duration time.Duration) {
+	c.queueWait.Observe(duration.Seconds())
+}
+
+// ObserveConnect implements confd.Observer
+func (c *Collector) ObserveConnect(err error) {
+	c.connects.WithLabelValues(result(err)).Inc()
+}
+
+// ObserveLockWait implements confd.Observer
+func (c *Collector) ObserveLockWait(kind string, duration time.Duration) {
+	c.lockWait.WithLabelValues(kind).Observe(duration.Seconds())
+}
+
+// ObserveTransaction implements confd.Observer, failed commits and rollbacks
+// are reported with the outcome error
+func (c *Collector) ObserveTransaction(kind, outcome string, duration time.Duration, err error) {
+	if err != nil {
+		outcome = "error"
+	}
+	c.transactions.WithLabelValues(kind, outcome).Observe(duration.Seconds())
+}
+
+// result returns the result label of the error
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confdprom
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+func TestCollector(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	srv.SetNodes(map[string]interface{}{"ntp": map[string]interface{}{"status": 0}})
+	collector := NewCollector()
+	registry := prometheus.NewPedanticRegistry()
+	assert.NoError(t, registry.Register(collector))
+
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	conn.Observer = collector
+
+	_, err := conn.GetObjectClasses()
+	assert.NoError(t, err)
+	_, err = conn.SimpleRequest("del_object", "REF_Missing")
+	assert.Error(t, err)
+	tx, err := conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	_, err = tx.SetNodeValue(1, "ntp", "status")
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	tx, err = conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Rollback())
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.calls.WithLabelValues("get_object_classes", "ok")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.calls.WithLabelValues("del_object", "error")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.returnErrors.WithLabelValues("del_object", "return_code")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.connects.WithLabelValues("ok")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(collector.roundTripErrs))
+
+	count, err := testutil.GatherAndCount(registry,
+		"confd_client_round_trip_duration_seconds",
+		"confd_client_queue_wait_seconds",
+		"confd_client_transaction_lock_wait_seconds",
+		"confd_client_transaction_duration_seconds")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, count, "round trip, queue wait, write lock wait, commit and rollback")
+
+	problems, err := testutil.GatherAndLint(registry)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confdtest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/threez/sophos-utm9/confd"
+)
+
+// matchAll checks if the object matches all filter expressions (as build by
+// confd.ObjectFilter)
+func (s *Server) matchAll(obj confd.AnyObject, filters []interface{}) (bool, error) {
+	for _, filter := range filters {
+		matched, err := s.match(obj, filter)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// match checks a single filter expression, either an attribute comparison
+// [name, op, value], a default check [name, "default"] or a logical
+// expression ["_or"|"_and"|"_not", filter...]
+func (s *Server) match(obj confd.AnyObject, filter interface{}) (bool, error) {
+	expr, ok := filter.([]interface{})
+	if !ok || len(expr) == 0 {
+		return false, fmt.Errorf("Invalid filter expression %v", filter)
+	}
+	name, ok := expr[0].(string)
+	if !ok {
+		return false, fmt.Errorf("Invalid filter expression %v", filter)
+	}
+
+	switch name {
+	case "_or":
+		for _, sub := range expr[1:] {
+			matched, err := s.match(obj, sub)
+			if err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	case "_and":
+		return s.matchAll(obj, expr[1:])
+	case "_not":
+		matched, err := s.matchAll(obj, expr[1:])
+		return !matched, err
+	}
+
+	value, found := attribute(obj, name)
+	if len(expr) == 2 && expr[1] == "default" {
+		def := s.meta[obj.Class][obj.Type][name].Default
+		return !found || equal(value, def), nil
+	}
+	if len(expr) != 3 {
+		return false, fmt.Errorf("Invalid filter expression %v", filter)
+	}
+
+	op, arg := expr[1], expr[2]
+	switch op {
+	case "eq":
+		return equal(value, arg), nil
+	case "ne":
+		return !equal(value, arg), nil
+	case "gt", "ge", "lt", "le":
+		a, aok := number(value)
+		b, bok := number(arg)
+		if !aok || !bok {
+			return false, nil
+		}
+		switch op {
+		case "gt":
+			return a > b, nil
+		case "ge":
+			return a >= b, nil
+		case "lt":
+			return a < b, nil
+		default:
+			return a <= b, nil
+		}
+	case "=~", "!~":
+		re, err := regexp.Compile(fmt.Sprint(arg))
+		if err != nil {
+			return false, err
+		}
+		matched := found && re.MatchString(fmt.Sprint(value))
+		return matched == (op == "=~"), nil
+	}
+	return false, fmt.Errorf("Unknown filter operator %v", op)
+}
+
+// attribute returns the data attribute or meta data of the object
+func attribute(obj confd.AnyObject, name string) (interface{}, bool) {
+	if value, found := obj.Data[name]; found {
+		return value, true
+	}
+	switch name {
+	case "ref":
+		return obj.Ref, true
+	case "class":
+		return obj.Class, true
+	case "type":
+		return obj.Type, true
+	case "lock":
+		return obj.Lock, true
+	case "nodel":
+		return obj.Nodel, true
+	case "hidden":
+		return confd.BoolValue(bool(obj.Hidden)), true
+	}
+	return nil, false
+}
+
+// equal compares loosely like confd does, numbers are compared by value
+// everything else by string representation
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			return x == y
+		}
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// number converts the value into a number if possible, booleans are numbers
+// in confd
+func number(value interface{}) (float64, bool) {
+	switch tv := value.(type) {
+	case float64:
+		return tv, true
+	case bool:
+		return float64(confd.BoolValue(tv)), true
+	case string:
+		f, err := strconv.ParseFloat(tv, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
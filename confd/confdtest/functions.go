@@ -0,0 +1,799 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confdtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/internal/textutil"
+)
+
+// function implemented by the server
+type function struct {
+	module string
+	write  bool
+	doc    string
+	impl   func(s *Server, sess *session, params []json.RawMessage) (interface{}, error)
+}
+
+func (f function) call(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	return f.impl(s, sess, params)
+}
+
+// functions contains all implemented confd functions
+var functions map[string]function
+
+func init() {
+	functions = map[string]function{
+		"get_SID":              {"Session", false, "Returns the session id.", getSID},
+		"detach":               {"Session", false, "Detaches the session.", detach},
+		"get_exports":          {"Base", false, "Returns all exported functions.", getExports},
+		"get_rights":           {"Base", false, "Returns or checks the rights of the user.", getRights},
+		"get":                  {"Nodes", false, "Reads node data.", get},
+		"set":                  {"Nodes", true, "Sets node data.", set},
+		"reset":                {"Nodes", true, "Resets a node to its default.", reset},
+		"get_nodes":            {"Nodes", false, "Lists sub-nodes.", getNodes},
+		"get_affected_nodes":   {"Nodes", false, "Lists nodes using the object.", getAffectedNodes},
+		"get_object":           {"Objects", false, "Returns an object.", getObject},
+		"get_objects":          {"Objects", false, "Returns filtered objects.", getObjects},
+		"get_affected_objects": {"Objects", false, "Lists objects using the objects.", getAffectedObjects},
+		"get_object_classes":   {"Objects", false, "Lists object classes.", getObjectClasses},
+		"get_object_types":     {"Objects", false, "Lists object types of a class.", getObjectTypes},
+		"get_meta_objects":     {"Objects", false, "Returns object meta information.", getMetaObjects},
+		"set_object":           {"Objects", true, "Creates or updates an object.", setObject},
+		"change_object":        {"Objects", true, "Changes object attributes.", changeObject},
+		"del_object":           {"Objects", true, "Deletes an object.", delObject},
+		"move_object":          {"Objects", true, "Changes the ref of an object.", moveObject},
+		"reset_object":         {"Objects", true, "Resets an object to its default.", resetObject},
+		"lock_object":          {"Objects", true, "Sets the lock state of an object.", lockObject},
+		"lock_override":        {"Objects", false, "Overrides object locks.", lockOverride},
+		"lock":                 {"Transaction", true, "Starts a write transaction.", lock},
+		"commit":               {"Transaction", true, "Commits the write transaction.", commit},
+		"unlock":               {"Transaction", true, "Rolls the write transaction back.", unlock},
+		"freeze":               {"Transaction", false, "Starts a read transaction.", freeze},
+		"thaw":                 {"Transaction", false, "Ends the read transaction.", thaw},
+		"err_list":             {"Error", false, "Lists errors.", errList},
+		"err_list_fatal":       {"Error", false, "Lists fatal errors.", errListFatal},
+		"err_list_noack":       {"Error", false, "Lists unacknowledged errors.", errListNoAck},
+		"err_is_fatal":         {"Error", false, "Counts fatal errors.", errIsFatal},
+		"err_is_noack":         {"Error", false, "Counts unacknowledged errors.", errIsNoAck},
+		"err_ack":              {"Error", false, "Acknowledges errors.", errAck},
+	}
+}
+
+// args decodes the params into the passed pointers, missing params are
+// left untouched
+func args(params []json.RawMessage, ptrs ...interface{}) error {
+	for i, ptr := range ptrs {
+		if i >= len(params) {
+			break
+		}
+		if err := json.Unmarshal(params[i], ptr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// path decodes the params into a node path
+func path(params []json.RawMessage) ([]string, error) {
+	names := make([]string, len(params))
+	for i, param := range params {
+		if err := json.Unmarshal(param, &names[i]); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+func detach(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	return 1, nil
+}
+
+func getSID(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	return sess.sid, nil
+}
+
+func getExports(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	if s.exports != nil {
+		return s.exports, nil
+	}
+	exports := make(map[string]confd.Export)
+	for name, fn := range functions {
+		exports[name] = confd.Export{
+			Write:  confd.Bool(fn.write),
+			Module: fn.module,
+			Doc:    fn.doc,
+		}
+	}
+	return exports, nil
+}
+
+func getRights(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	if len(params) == 0 {
+		return append([]string{}, s.rights...), nil
+	}
+	var arg interface{}
+	if err := args(params, &arg); err != nil {
+		return nil, err
+	}
+	var wanted []string
+	switch tv := arg.(type) {
+	case string:
+		wanted = []string{tv}
+	case []interface{}:
+		for _, right := range tv {
+			wanted = append(wanted, fmt.Sprint(right))
+		}
+	}
+	for _, right := range s.rights {
+		for _, w := range wanted {
+			if right == w {
+				return 1, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+func get(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	p, err := path(params)
+	if err != nil {
+		return nil, err
+	}
+	value, found := lookup(s.nodes, p)
+	if !found {
+		sess.fail(nodeUnknown(p))
+		return nil, nil
+	}
+	return value, nil
+}
+
+func set(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	if len(params) < 2 {
+		return 0, fmt.Errorf("set requires a value and a path")
+	}
+	var value interface{}
+	if err := args(params, &value); err != nil {
+		return nil, err
+	}
+	p, err := path(params[1:])
+	if err != nil {
+		return nil, err
+	}
+	parent, found := lookup(s.nodes, p[:len(p)-1])
+	m, isMap := parent.(map[string]interface{})
+	if !found || !isMap {
+		sess.fail(nodeUnknown(p))
+		return 0, nil
+	}
+	m[p[len(p)-1]] = value
+	return 1, nil
+}
+
+func reset(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	p, err := path(params)
+	if err != nil {
+		return nil, err
+	}
+	value, found := lookup(s.nodeSeed, p)
+	if !found || len(p) == 0 {
+		sess.fail(nodeUnknown(p))
+		return 0, nil
+	}
+	parent, _ := lookup(s.nodes, p[:len(p)-1])
+	m, isMap := parent.(map[string]interface{})
+	if !isMap {
+		sess.fail(nodeUnknown(p))
+		return 0, nil
+	}
+	m[p[len(p)-1]] = clone(value)
+	return 1, nil
+}
+
+func getNodes(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	p, err := path(params)
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return skeleton(s.nodes), nil
+	}
+	value, found := lookup(s.nodes, p)
+	if !found {
+		sess.fail(nodeUnknown(p))
+		return nil, nil
+	}
+	names := []string{}
+	if m, isMap := value.(map[string]interface{}); isMap {
+		for name := range m {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// skeleton returns the structure of the tree without leaf values
+func skeleton(value interface{}) interface{} {
+	m, isMap := value.(map[string]interface{})
+	if !isMap {
+		return nil
+	}
+	tree := make(map[string]interface{}, len(m))
+	for name, child := range m {
+		tree[name] = skeleton(child)
+	}
+	return tree
+}
+
+func getAffectedNodes(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var ref string
+	if err := args(params, &ref); err != nil {
+		return nil, err
+	}
+	paths := [][]string{}
+	walkRefs(s.nodes, nil, func(p []string, value string) {
+		if value == ref {
+			paths = append(paths, append([]string(nil), p...))
+		}
+	})
+	return paths, nil
+}
+
+func getObject(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var ref string
+	if err := args(params, &ref); err != nil {
+		return nil, err
+	}
+	obj, found := s.objects[ref]
+	if !found {
+		sess.fail(objectUnknown(ref))
+		return nil, nil
+	}
+	return obj, nil
+}
+
+func getObjects(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var className *string
+	var typeNames []string
+	if err := args(params, &className, &typeNames); err != nil {
+		return nil, err
+	}
+	var filters []interface{}
+	for _, param := range params[min(2, len(params)):] {
+		var filter interface{}
+		if err := json.Unmarshal(param, &filter); err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	objects := []confd.AnyObject{}
+	for _, ref := range s.refs() {
+		obj := s.objects[ref]
+		if className != nil && obj.Class != *className {
+			continue
+		}
+		if len(typeNames) > 0 && !contains(typeNames, obj.Type) {
+			continue
+		}
+		matched, err := s.matchAll(obj, filters)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+func getAffectedObjects(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var refs []string
+	if err := args(params, &refs); err != nil {
+		return nil, err
+	}
+	affected := make(map[string]bool)
+	queue := append([]string(nil), refs...)
+	for len(queue) > 0 {
+		ref := queue[0]
+		queue = queue[1:]
+		if affected[ref] {
+			continue
+		}
+		affected[ref] = true
+		queue = append(queue, s.users(ref)...)
+	}
+	result := make([]string, 0, len(affected))
+	for ref := range affected {
+		result = append(result, ref)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func getObjectClasses(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	classes := make(map[string]bool)
+	for class := range s.meta {
+		classes[class] = true
+	}
+	for _, obj := range s.objects {
+		classes[obj.Class] = true
+	}
+	return textutil.SortedKeys(classes), nil
+}
+
+func getObjectTypes(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var class string
+	if err := args(params, &class); err != nil {
+		return nil, err
+	}
+	types := make(map[string]bool)
+	for typ := range s.meta[class] {
+		types[typ] = true
+	}
+	for _, obj := range s.objects {
+		if obj.Class == class {
+			types[obj.Type] = true
+		}
+	}
+	return textutil.SortedKeys(types), nil
+}
+
+func getMetaObjects(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	return s.meta, nil
+}
+
+func setObject(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var obj confd.AnyObject
+	if err := args(params, &obj); err != nil {
+		return nil, err
+	}
+	obj = cloneObject(obj)
+	if obj.Data == nil {
+		obj.Data = make(map[string]interface{})
+	}
+
+	if existing, found := s.objects[obj.Ref]; found {
+		if !s.unlocked(sess, existing) {
+			return 0, nil
+		}
+		obj.Class, obj.Type = existing.Class, existing.Type
+	} else {
+		if obj.Class == "" || obj.Type == "" {
+			sess.fail(confd.ErrDescription{
+				Name:        "The object class and type are required.",
+				MessageType: "OBJECT_INVALID",
+				Fatal:       true,
+			})
+			return 0, nil
+		}
+		s.applyDefaults(&obj)
+		if obj.Ref == "" {
+			obj.Ref = s.newRef(obj)
+		}
+	}
+
+	if other := s.named(obj); other != "" {
+		sess.fail(confd.ErrDescription{
+			Name: fmt.Sprintf("An object with the name '%v' already exists.",
+				obj.Data["name"]),
+			MessageType: "OBJECT_NAME_EXISTS",
+			ObjectName:  fmt.Sprint(obj.Data["name"]),
+			Ref:         other,
+			Class:       obj.Class,
+			Type:        obj.Type,
+			Fatal:       true,
+		})
+		return 0, nil
+	}
+	s.objects[obj.Ref] = obj
+	return obj.Ref, nil
+}
+
+func changeObject(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var ref string
+	var attrs map[string]interface{}
+	if err := args(params, &ref, &attrs); err != nil {
+		return nil, err
+	}
+	obj, found := s.objects[ref]
+	if !found {
+		sess.fail(objectUnknown(ref))
+		return 0, nil
+	}
+	if !s.unlocked(sess, obj) {
+		return 0, nil
+	}
+	changed := cloneObject(obj)
+	for name, value := range attrs {
+		changed.Data[name] = value
+	}
+	if other := s.named(changed); other != "" {
+		sess.fail(confd.ErrDescription{
+			Name: fmt.Sprintf("An object with the name '%v' already exists.",
+				changed.Data["name"]),
+			MessageType: "OBJECT_NAME_EXISTS",
+			Ref:         other,
+			Fatal:       true,
+		})
+		return 0, nil
+	}
+	s.objects[ref] = changed
+	return 1, nil
+}
+
+func delObject(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var ref string
+	if err := args(params, &ref); err != nil {
+		return nil, err
+	}
+	obj, found := s.objects[ref]
+	if !found {
+		sess.fail(objectUnknown(ref))
+		return 0, nil
+	}
+	if obj.Nodel != "" {
+		sess.fail(confd.ErrDescription{
+			Name: fmt.Sprintf("The %s %s object '%v' is protected from "+
+				"deletion.", obj.Type, obj.Class, obj.Data["name"]),
+			MessageType: "OBJECT_DELETE_LOCKED",
+			Ref:         ref,
+			Class:       obj.Class,
+			Type:        obj.Type,
+			Fatal:       true,
+		})
+		return 0, nil
+	}
+	if !s.unlocked(sess, obj) {
+		return 0, nil
+	}
+	if users := s.users(ref); len(users) > 0 || len(s.nodeUsers(ref)) > 0 {
+		sess.fail(confd.ErrDescription{
+			Name: fmt.Sprintf("The %s %s object '%v' is in use.",
+				obj.Type, obj.Class, obj.Data["name"]),
+			MessageType: "OBJECT_DELETE_USED",
+			Ref:         ref,
+			Class:       obj.Class,
+			Type:        obj.Type,
+			Fatal:       true,
+		})
+		return 0, nil
+	}
+	delete(s.objects, ref)
+	return 1, nil
+}
+
+func moveObject(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var oldRef, newRef string
+	if err := args(params, &oldRef, &newRef); err != nil {
+		return nil, err
+	}
+	obj, found := s.objects[oldRef]
+	if !found {
+		sess.fail(objectUnknown(oldRef))
+		return 0, nil
+	}
+	if _, taken := s.objects[newRef]; taken {
+		sess.fail(confd.ErrDescription{
+			Name:        fmt.Sprintf("The reference '%s' is already used.", newRef),
+			MessageType: "OBJECT_REF_EXISTS",
+			Ref:         newRef,
+			Fatal:       true,
+		})
+		return 0, nil
+	}
+	delete(s.objects, oldRef)
+	obj.Ref = newRef
+	s.objects[newRef] = obj
+	// keep all places where the object is used consistent
+	for ref, o := range s.objects {
+		o.Data = replaceRef(o.Data, oldRef, newRef).(map[string]interface{})
+		s.objects[ref] = o
+	}
+	s.nodes = replaceRef(s.nodes, oldRef, newRef).(map[string]interface{})
+	return 1, nil
+}
+
+func resetObject(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var ref string
+	if err := args(params, &ref); err != nil {
+		return nil, err
+	}
+	obj, found := s.defaults[ref]
+	if !found {
+		sess.fail(objectUnknown(ref))
+		return 0, nil
+	}
+	s.objects[ref] = cloneObject(obj)
+	return 1, nil
+}
+
+func lockObject(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var ref string
+	var state interface{}
+	if err := args(params, &ref, &state); err != nil {
+		return nil, err
+	}
+	obj, found := s.objects[ref]
+	if !found {
+		sess.fail(objectUnknown(ref))
+		return 0, nil
+	}
+	switch tv := state.(type) {
+	case string:
+		if obj.Lock != "" && !sess.override {
+			sess.fail(objectLocked(obj))
+			return 0, nil
+		}
+		obj.Lock = tv
+	default:
+		if obj.Lock != "" && !sess.override {
+			sess.fail(objectLocked(obj))
+			return 0, nil
+		}
+		obj.Lock = ""
+	}
+	s.objects[ref] = obj
+	return 1, nil
+}
+
+func lockOverride(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var override confd.Bool
+	if err := args(params, &override); err != nil {
+		return nil, err
+	}
+	sess.override = bool(override)
+	return 1, nil
+}
+
+// lock, commit and unlock return 0 if the lock is held by another session,
+// the message type confd reports isn't known, therefore none is reported
+func lock(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	if s.lockedBy != nil {
+		return 0, nil
+	}
+	s.lockedBy = sess
+	s.lockBackup = &backup{
+		objects: make(map[string]confd.AnyObject, len(s.objects)),
+		nodes:   clone(s.nodes).(map[string]interface{}),
+	}
+	for ref, obj := range s.objects {
+		s.lockBackup.objects[ref] = cloneObject(obj)
+	}
+	return 1, nil
+}
+
+func commit(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	if s.lockedBy != sess {
+		return 0, nil
+	}
+	s.lockedBy, s.lockBackup, sess.acks = nil, nil, nil
+	return 1, nil
+}
+
+func unlock(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	if s.lockedBy != sess {
+		return 0, nil
+	}
+	s.objects = s.lockBackup.objects
+	s.nodes = s.lockBackup.nodes
+	s.lockedBy, s.lockBackup, sess.acks = nil, nil, nil
+	return 1, nil
+}
+
+func freeze(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	sess.frozen = true
+	return 1, nil
+}
+
+func thaw(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	sess.frozen = false
+	return 1, nil
+}
+
+func errList(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	return append(confd.ErrList{}, sess.errs...), nil
+}
+
+func errListFatal(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	errs := confd.ErrList{}
+	for _, desc := range sess.errs {
+		if desc.Fatal {
+			errs = append(errs, desc)
+		}
+	}
+	return errs, nil
+}
+
+func errListNoAck(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	errs := confd.ErrList{}
+	for _, desc := range sess.errs {
+		if !sess.acked(desc) {
+			errs = append(errs, desc)
+		}
+	}
+	return errs, nil
+}
+
+func errIsFatal(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	errs, _ := errListFatal(s, sess, params)
+	return len(errs.(confd.ErrList)), nil
+}
+
+func errIsNoAck(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	errs, _ := errListNoAck(s, sess, params)
+	return len(errs.(confd.ErrList)), nil
+}
+
+func errAck(s *Server, sess *session, params []json.RawMessage) (interface{}, error) {
+	var arg interface{}
+	if err := args(params, &arg); err != nil {
+		return nil, err
+	}
+	switch tv := arg.(type) {
+	case string:
+		switch tv {
+		case "all":
+			sess.acks = []interface{}{map[string]interface{}{}}
+		case "last":
+			for _, desc := range sess.errs {
+				sess.acks = append(sess.acks, clone(desc))
+			}
+		case "none":
+			sess.acks = nil
+		}
+	case []interface{}:
+		sess.acks = append(sess.acks, tv...)
+	case map[string]interface{}:
+		sess.acks = append(sess.acks, tv)
+	}
+	return 1, nil
+}
+
+// unlocked checks that the object can be modified by the session
+func (s *Server) unlocked(sess *session, obj confd.AnyObject) bool {
+	if obj.Lock != "" && !sess.override {
+		sess.fail(objectLocked(obj))
+		return false
+	}
+	return true
+}
+
+// named returns the ref of another object of the same class and type with
+// the same name
+func (s *Server) named(obj confd.AnyObject) string {
+	name, hasName := obj.Data["name"]
+	if !hasName {
+		return ""
+	}
+	for _, ref := range s.refs() {
+		other := s.objects[ref]
+		if ref != obj.Ref && other.Class == obj.Class && other.Type == obj.Type &&
+			fmt.Sprint(other.Data["name"]) == fmt.Sprint(name) {
+			return ref
+		}
+	}
+	return ""
+}
+
+// users returns the refs of all objects referencing ref
+func (s *Server) users(ref string) []string {
+	var users []string
+	for _, other := range s.refs() {
+		if other == ref {
+			continue
+		}
+		walkRefs(s.objects[other].Data, nil, func(_ []string, value string) {
+			if value == ref && (len(users) == 0 || users[len(users)-1] != other) {
+				users = append(users, other)
+			}
+		})
+	}
+	return users
+}
+
+// nodeUsers returns the paths of all nodes referencing ref
+func (s *Server) nodeUsers(ref string) [][]string {
+	var paths [][]string
+	walkRefs(s.nodes, nil, func(p []string, value string) {
+		if value == ref {
+			paths = append(paths, append([]string(nil), p...))
+		}
+	})
+	return paths
+}
+
+// applyDefaults sets the meta information defaults for missing attributes
+func (s *Server) applyDefaults(obj *confd.AnyObject) {
+	for name, constraint := range s.meta[obj.Class][obj.Type] {
+		if _, set := obj.Data[name]; !set && constraint.Default != nil {
+			obj.Data[name] = clone(constraint.Default)
+		}
+	}
+}
+
+// walkRefs calls fn for all string values in the tree that look like refs
+func walkRefs(value interface{}, p []string, fn func(p []string, value string)) {
+	switch tv := value.(type) {
+	case map[string]interface{}:
+		for _, name := range textutil.SortedKeys(tv) {
+			walkRefs(tv[name], append(p, name), fn)
+		}
+	case []interface{}:
+		for _, item := range tv {
+			walkRefs(item, p, fn)
+		}
+	case string:
+		if strings.HasPrefix(tv, "REF_") {
+			fn(p, tv)
+		}
+	}
+}
+
+// replaceRef returns a copy of the tree with all oldRef values replaced
+func replaceRef(value interface{}, oldRef, newRef string) interface{} {
+	switch tv := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(tv))
+		for name, child := range tv {
+			m[name] = replaceRef(child, oldRef, newRef)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(tv))
+		for i, child := range tv {
+			a[i] = replaceRef(child, oldRef, newRef)
+		}
+		return a
+	case string:
+		if tv == oldRef {
+			return newRef
+		}
+	}
+	return value
+}
+
+func contains(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeUnknown(p []string) confd.ErrDescription {
+	return confd.ErrDescription{
+		Name:        fmt.Sprintf("The node '%s' is unknown.", strings.Join(p, "/")),
+		MessageType: "NODE_UNKNOWN",
+		Fatal:       true,
+	}
+}
+
+func objectUnknown(ref string) confd.ErrDescription {
+	return confd.ErrDescription{
+		Name:        fmt.Sprintf("The object '%s' is unknown.", ref),
+		MessageType: "OBJECT_UNKNOWN",
+		Ref:         ref,
+		Fatal:       true,
+	}
+}
+
+func objectLocked(obj confd.AnyObject) confd.ErrDescription {
+	return confd.ErrDescription{
+		Name:        fmt.Sprintf("The object '%s' is locked.", obj.Ref),
+		MessageType: "OBJECT_LOCKED",
+		Ref:         obj.Ref,
+		Class:       obj.Class,
+		Type:        obj.Type,
+		Fatal:       true,
+	}
+}
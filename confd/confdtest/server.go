@@ -0,0 +1,382 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package confdtest provides an in-memory confd server for testing.
+//
+// The server speaks the same JSON-RPC dialect as the confd of the UTM and is
+// backed by a seedable object store and node tree:
+//
+//	srv := confdtest.NewServer()
+//	defer srv.Close()
+//	srv.AddObjects(confd.AnyObject{...})
+//	srv.SetNodes(map[string]interface{}{"ntp": map[string]interface{}{...}})
+//
+//	conn := srv.Conn()
+//	defer conn.Close()
+package confdtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/threez/sophos-utm9/confd"
+)
+
+// Server is an in-memory confd server
+type Server struct {
+	URL string // URL of the server, e.g. http://127.0.0.1:1234
+
+	http       *httptest.Server
+	mu         sync.Mutex
+	objects    map[string]confd.AnyObject // current objects by ref
+	defaults   map[string]confd.AnyObject // seeded objects, used for resets
+	nodes      map[string]interface{}     // current node tree
+	nodeSeed   map[string]interface{}     // seeded node tree, used for resets
+	meta       confd.ObjectMetaTree
+	exports    map[string]confd.Export
+	rights     []string
+	sessions   map[string]*session // sessions by sid
+	conns      map[string]*session // sessions by remote address
+	lockedBy   *session            // session holding the write lock
+	nextSID    int
+	nextRef    int
+	calls      []string
+	lockBackup *backup
+}
+
+// backup of the state taken when a write transaction begins
+type backup struct {
+	objects map[string]confd.AnyObject
+	nodes   map[string]interface{}
+}
+
+// session represents a confd session
+type session struct {
+	sid      int
+	options  confd.Options
+	errs     confd.ErrList
+	acks     []interface{} // acknowledged error patterns
+	frozen   bool
+	override bool // lock override
+}
+
+// request of the json rpc dialect
+type request struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	ID     uint64            `json:"id"`
+}
+
+// response of the json rpc dialect
+type response struct {
+	Result interface{} `json:"result"`
+	Error  *string     `json:"error"`
+	ID     uint64      `json:"id"`
+}
+
+// NewServer starts and returns a new server. The caller should call Close
+// when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		objects:  make(map[string]confd.AnyObject),
+		defaults: make(map[string]confd.AnyObject),
+		nodes:    make(map[string]interface{}),
+		nodeSeed: make(map[string]interface{}),
+		meta:     make(confd.ObjectMetaTree),
+		sessions: make(map[string]*session),
+		conns:    make(map[string]*session),
+		nextSID:  1,
+		nextRef:  1,
+	}
+	s.http = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.http.URL
+	return s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.http.CloseClientConnections()
+	s.http.Close()
+}
+
+// Conn returns a new system connection to the server
+func (s *Server) Conn() *confd.Conn {
+	conn, err := confd.NewConn(strings.Replace(s.URL, "http://", "http://system@", 1) + "/system")
+	if err != nil {
+		panic(err) // can't happen, the url is always valid
+	}
+	conn.Options.Name = "confdtest"
+	return conn
+}
+
+// AddObjects seeds the object store. The objects are used as defaults when
+// objects are reset. Objects without a ref get a generated ref.
+func (s *Server) AddObjects(objects ...confd.AnyObject) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, obj := range objects {
+		obj = cloneObject(obj)
+		if obj.Ref == "" {
+			obj.Ref = s.newRef(obj)
+		}
+		s.objects[obj.Ref] = obj
+		s.defaults[obj.Ref] = cloneObject(obj)
+	}
+}
+
+// SetNodes seeds the node tree. The tree is used as defaults when nodes are
+// reset.
+func (s *Server) SetNodes(tree map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes = clone(tree).(map[string]interface{})
+	s.nodeSeed = clone(tree).(map[string]interface{})
+}
+
+// SetMeta seeds the object meta information, defaults of the meta
+// information are applied to new objects
+func (s *Server) SetMeta(meta confd.ObjectMetaTree) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meta = meta
+}
+
+// SetExports replaces the exports returned by get_exports. By default all
+// functions implemented by the server are exported.
+func (s *Server) SetExports(exports map[string]confd.Export) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exports = exports
+}
+
+// SetRights sets the rights of all users
+func (s *Server) SetRights(rights ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rights = rights
+}
+
+// Object returns the current state of the object with the given ref
+func (s *Server) Object(ref string) (confd.AnyObject, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[ref]
+	return cloneObject(obj), ok
+}
+
+// Objects returns all current objects sorted by ref
+func (s *Server) Objects() []confd.AnyObject {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects := make([]confd.AnyObject, 0, len(s.objects))
+	for _, ref := range s.refs() {
+		objects = append(objects, cloneObject(s.objects[ref]))
+	}
+	return objects
+}
+
+// Node returns the current value of the node with the given path
+func (s *Server) Node(path ...string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := lookup(s.nodes, path)
+	return clone(value), ok
+}
+
+// Calls returns the names of all functions called so far
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, req.Method)
+	resp := response{ID: req.ID}
+	result, err := s.call(r.RemoteAddr, &req)
+	if err != nil {
+		str := err.Error()
+		resp.Error = &str
+	} else {
+		resp.Result = result
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp) // ignore write errors
+}
+
+// call dispatches the request to the implementing function
+func (s *Server) call(remoteAddr string, req *request) (interface{}, error) {
+	if req.Method == "new" {
+		return s.newSession(remoteAddr, req.Params)
+	}
+	sess := s.conns[remoteAddr]
+	if sess == nil {
+		// requests without session are handled anonymously
+		sess = &session{sid: -1}
+	}
+
+	fn, ok := functions[req.Method]
+	if !ok {
+		sess.errs = nil
+		sess.fail(confd.ErrDescription{
+			Name: fmt.Sprintf("No public function '%s' is provided by this "+
+				"Confd.", req.Method),
+			MessageType: "FUNCTION_UNKNOWN",
+			Fatal:       true,
+		})
+		return nil, nil
+	}
+
+	// errors are collected per public call, or during write transactions
+	errCall := strings.HasPrefix(req.Method, "err_")
+	inTx := s.lockedBy == sess
+	if !errCall && !inTx {
+		sess.errs = nil
+	}
+	result, err := fn.call(s, sess, req.Params)
+	if !errCall && !inTx && s.lockedBy != sess {
+		sess.acks = nil // acknowledgements are valid for one call only
+	}
+	return result, err
+}
+
+// newSession creates or reuses (if the sid is known) a session
+func (s *Server) newSession(remoteAddr string, params []json.RawMessage) (interface{}, error) {
+	var options confd.Options
+	if len(params) > 0 {
+		if err := json.Unmarshal(params[0], &options); err != nil {
+			return nil, err
+		}
+	}
+	if options.SID != nil {
+		if sess, ok := s.sessions[fmt.Sprint(options.SID)]; ok {
+			s.conns[remoteAddr] = sess
+			return 1, nil
+		}
+	}
+	sess := &session{sid: s.nextSID, options: options}
+	s.nextSID++
+	s.sessions[fmt.Sprint(sess.sid)] = sess
+	s.conns[remoteAddr] = sess
+	return 1, nil
+}
+
+// fail records the error for the session
+func (sess *session) fail(desc confd.ErrDescription) {
+	sess.errs = append(sess.errs, desc)
+}
+
+// acked returns true if the error was acknowledged
+func (sess *session) acked(desc confd.ErrDescription) bool {
+	if desc.Fatal {
+		return false // fatal errors can't be acknowledged
+	}
+	for _, pattern := range sess.acks {
+		if matchErr(pattern, desc) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchErr checks if the error matches the acknowledge pattern, all fields
+// set in the pattern have to match
+func matchErr(pattern interface{}, desc confd.ErrDescription) bool {
+	p, ok := pattern.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	var fields map[string]interface{}
+	data, _ := json.Marshal(desc)
+	_ = json.Unmarshal(data, &fields)
+	for key, value := range p {
+		if fmt.Sprint(fields[key]) != fmt.Sprint(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// refs returns all refs sorted
+func (s *Server) refs() []string {
+	refs := make([]string, 0, len(s.objects))
+	for ref := range s.objects {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// newRef generates a unique ref for the object
+func (s *Server) newRef(obj confd.AnyObject) string {
+	for {
+		ref := fmt.Sprintf("REF_%s%s%d", camel(obj.Class), camel(obj.Type), s.nextRef)
+		s.nextRef++
+		if _, ok := s.objects[ref]; !ok {
+			return ref
+		}
+	}
+}
+
+func camel(str string) string {
+	if str == "" {
+		return ""
+	}
+	return strings.ToUpper(str[:1]) + str[1:]
+}
+
+// clone returns a deep copy of the json compatible value, numbers are
+// normalized to float64 as they would be send over the wire
+func clone(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err) // only json compatible values are stored
+	}
+	var copied interface{}
+	if err := json.Unmarshal(data, &copied); err != nil {
+		panic(err)
+	}
+	return copied
+}
+
+// cloneObject returns a deep copy of the object
+func cloneObject(obj confd.AnyObject) confd.AnyObject {
+	if obj.Data != nil {
+		obj.Data = clone(obj.Data).(map[string]interface{})
+	}
+	return obj
+}
+
+// lookup returns the node at path
+func lookup(tree map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = tree
+	for _, name := range path {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[name]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
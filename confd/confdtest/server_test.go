@@ -0,0 +1,323 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confdtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+)
+
+func serverHelper() *Server {
+	srv := NewServer()
+	srv.AddObjects(
+		confd.AnyObject{
+			ObjectMeta: confd.ObjectMeta{Ref: "REF_AnonymousUser", Class: "aaa", Type: "user"},
+			Data: map[string]interface{}{
+				"name": "Anonymous", "comment": "Anonymous user", "status": 1,
+				"enabled": 1, "hidden": 1, "loc": "english",
+			},
+		},
+		confd.AnyObject{
+			ObjectMeta: confd.ObjectMeta{Ref: "REF_SystemUser", Class: "aaa", Type: "user"},
+			Data: map[string]interface{}{
+				"name": "system", "comment": "super user", "status": 1,
+				"enabled": 1, "hidden": 1, "loc": "english",
+			},
+		},
+		confd.AnyObject{
+			ObjectMeta: confd.ObjectMeta{Ref: "REF_DefaultInternalNetwork",
+				Class: "network", Type: "interface_network", Nodel: "1"},
+			Data: map[string]interface{}{"name": "Internal (Network)"},
+		},
+		confd.AnyObject{
+			ObjectMeta: confd.ObjectMeta{Ref: "REF_NetHost", Class: "network", Type: "host"},
+			Data:       map[string]interface{}{"name": "Host", "address": "10.0.0.1"},
+		},
+		confd.AnyObject{
+			ObjectMeta: confd.ObjectMeta{Ref: "REF_NetGroup", Class: "network", Type: "group"},
+			Data: map[string]interface{}{
+				"name": "Group", "members": []interface{}{"REF_NetHost"},
+			},
+		},
+	)
+	srv.SetNodes(map[string]interface{}{
+		"ntp": map[string]interface{}{
+			"status":           1,
+			"allowed_networks": []interface{}{"REF_DefaultInternalNetwork"},
+		},
+	})
+	srv.SetMeta(confd.ObjectMetaTree{
+		"aaa": {"user": {"status": {Default: float64(1)}}},
+		"network": {"host": {
+			"address":  {Default: "0.0.0.0"},
+			"resolved": {Default: float64(0)},
+		}},
+	})
+	return srv
+}
+
+func TestNodes(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	value, err := conn.GetNodeValue("ntp", "status")
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), value)
+
+	ok, err := conn.SetNodeValue(0, "ntp", "status")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	value, ok = srv.Node("ntp", "status")
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), value)
+	value, err = conn.GetNodeValue("ntp", "status")
+	assert.NoError(t, err)
+	assert.Nil(t, value, "confd returns 0 like a failure")
+
+	names, err := conn.GetNodes("ntp")
+	assert.NoError(t, err)
+	assert.Equal(t, []confd.NodeName{"allowed_networks", "status"}, names)
+
+	ok, err = conn.ResetNode("ntp", "status")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	value, _ = srv.Node("ntp", "status")
+	assert.Equal(t, float64(1), value)
+
+	ok, err = conn.SetNodeValue(1, "foo", "bar")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	errs, err := conn.ErrList()
+	assert.NoError(t, err)
+	assert.Equal(t, "FATAL [NODE_UNKNOWN] The node 'foo/bar' is unknown.",
+		errs.Error())
+
+	paths, err := conn.GetAffectedNodes("REF_DefaultInternalNetwork")
+	assert.NoError(t, err)
+	assert.Equal(t, []confd.NodePath{{"ntp", "allowed_networks"}}, paths)
+}
+
+func TestObjects(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	obj, err := conn.GetAnyObject("REF_AnonymousUser")
+	assert.NoError(t, err)
+	assert.Equal(t, "aaa", obj.Class)
+	assert.Equal(t, "Anonymous user", obj.Data["comment"])
+
+	var host = confd.AnyObject{
+		ObjectMeta: confd.ObjectMeta{Class: "network", Type: "host"},
+		Data: map[string]interface{}{
+			"name": "Google DNS", "address": "8.8.8.8",
+		},
+	}
+	ref, err := conn.SetObject(&host, true)
+	assert.NoError(t, err)
+	assert.Contains(t, ref, "REF_")
+	stored, ok := srv.Object(ref)
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), stored.Data["resolved"], "meta defaults apply")
+
+	_, err = conn.SetObject(&host, true)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "[OBJECT_NAME_EXISTS]")
+
+	assert.NoError(t, conn.ChangeObject(ref, map[string]interface{}{
+		"address": "8.8.4.4",
+	}))
+	assert.NoError(t, conn.MoveObject(ref, "REF_GOOGLEDNS"))
+	obj, err = conn.GetAnyObject("REF_GOOGLEDNS")
+	assert.NoError(t, err)
+	assert.Equal(t, "8.8.4.4", obj.Data["address"])
+
+	assert.NoError(t, conn.LockObject("REF_GOOGLEDNS"))
+	assert.Error(t, conn.ChangeObject("REF_GOOGLEDNS", map[string]interface{}{}))
+	assert.NoError(t, conn.UnlockObject("REF_GOOGLEDNS"))
+
+	deleted, err := conn.DelObject("REF_GOOGLEDNS")
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	_, ok = srv.Object("REF_GOOGLEDNS")
+	assert.False(t, ok)
+
+	deleted, err = conn.DelObject("REF_NetHost")
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+	errs, err := conn.ErrList()
+	assert.NoError(t, err)
+	assert.Contains(t, errs.Error(), "[OBJECT_DELETE_USED]")
+
+	refs, err := conn.GetAffectedObjects([]string{"REF_NetHost"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"REF_NetGroup", "REF_NetHost"}, refs)
+
+	assert.NoError(t, conn.MoveObject("REF_NetHost", "REF_NetHost2"))
+	group, _ := srv.Object("REF_NetGroup")
+	assert.Equal(t, []interface{}{"REF_NetHost2"}, group.Data["members"])
+
+	classes, err := conn.GetObjectClasses()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"aaa", "network"}, classes)
+}
+
+func TestFilterObjects(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	objects, err := conn.FilterObjects().
+		ClassName("aaa").
+		TypeName("user").
+		Eq("status", 1).
+		Gt("enabled", 0).
+		Gte("enabled", 1).
+		Lt("enabled", 2).
+		Lte("enabled", 1).
+		Default("status").
+		Ne("hidden", 0).
+		Or(conn.FilterObjects().Eq("name", "system").Eq("name", "Anonymous")).
+		Matches("comment", "super").
+		NotMatches("loc", "german").
+		And(conn.FilterObjects().Not(conn.FilterObjects().Eq("name", "foo"))).
+		Get()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(objects))
+	assert.Equal(t, "REF_SystemUser", objects[0].Ref)
+
+	objects, err = conn.GetAllObjects()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(objects))
+
+	objects, err = conn.FilterObjects().ClassName("network").
+		TypeName("host").TypeName("group").Get()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(objects))
+}
+
+func TestTransactions(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	tx, err := conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	_, err = tx.SetNodeValue(0, "ntp", "status")
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Rollback())
+	value, _ := srv.Node("ntp", "status")
+	assert.Equal(t, float64(1), value)
+
+	tx, err = conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	_, err = tx.SetNodeValue(0, "ntp", "status")
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	value, _ = srv.Node("ntp", "status")
+	assert.Equal(t, float64(0), value)
+
+	rtx, err := conn.BeginReadTransaction()
+	assert.NoError(t, err)
+	assert.NoError(t, rtx.Commit())
+
+	other := srv.Conn()
+	defer func() { _ = other.Close() }()
+	tx, err = conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	_, err = other.BeginWriteTransaction()
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, confd.ErrLockConflict)
+	assert.NoError(t, tx.Commit())
+}
+
+func TestErrors(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	_, err := conn.SimpleRequest("foobar")
+	assert.Error(t, err)
+	assert.Equal(t, "FATAL [FUNCTION_UNKNOWN] No public "+
+		"function 'foobar' is provided by this Confd.", err.Error())
+
+	deleted, err := conn.DelObject("REF_DefaultInternalNetwork")
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+
+	num, err := conn.ErrIsFatal()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), num)
+
+	errs, err := conn.ErrList()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, "OBJECT_DELETE_LOCKED", errs[0].MessageType)
+	assert.Contains(t, errs[0].Error(), "The interface_network network object "+
+		"'Internal (Network)' is protected from deletion.")
+
+	errs, err = conn.ErrListNoAck()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(errs), "fatal errors can't be acknowledged")
+
+	// errors are reset by the next public call
+	_, err = conn.SimpleRequest("get_SID")
+	assert.NoError(t, err)
+	errs, err = conn.ErrList()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(errs))
+}
+
+func TestSessions(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	sid, err := conn.SimpleRequest("get_SID")
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+	sid2, err := conn.SimpleRequest("get_SID")
+	assert.NoError(t, err)
+	assert.Equal(t, sid, sid2, "session is reused")
+
+	other := srv.Conn()
+	defer func() { _ = other.Close() }()
+	sid3, err := other.SimpleRequest("get_SID")
+	assert.NoError(t, err)
+	assert.NotEqual(t, sid, sid3)
+}
+
+func TestExportsAndRights(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	exports, err := conn.Exports()
+	assert.NoError(t, err)
+	assert.Equal(t, "Session", exports["get_SID"].Module)
+	assert.True(t, bool(exports["set_object"].Write))
+	assert.False(t, bool(exports["get_object"].Write))
+
+	srv.SetRights("ADMIN", "NETWORK")
+	rights, err := conn.GetRights()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ADMIN", "NETWORK"}, rights)
+	ok, err := conn.HasRight("NETWORK")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = conn.HasOneOfRights([]string{"FOO", "BAR"})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
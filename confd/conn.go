@@ -5,16 +5,25 @@
 package confd
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 )
 
-var safePasswordRegexp = regexp.MustCompile(`password":"[^"]+"`)
+// safeSecretRegexp matches the non-empty string values of JSON keys that
+// contain passwords, pre-shared keys, secrets or private keys
+var safeSecretRegexp = regexp.MustCompile(
+	`(?i)("[a-z0-9_-]*(?:password|psk|secret|key|private)[a-z0-9_-]*"\s*:\s*)"(?:[^"\\]|\\.)+"`)
+
+// safeSecretReplacement replaces the values matched by safeSecretRegexp
+const safeSecretReplacement = `${1}"********"`
 
 const (
 	msgConnect = iota
@@ -28,30 +37,49 @@ type roundTripHandler func(*http.Request) (*http.Response, error)
 // sessionMsg is the object send to the worker goroutine
 type sessionMsg struct {
 	Type     int
+	Context  context.Context
 	Request  *http.Request
 	Response *http.Response
 	Error    error
 	Done     chan bool
 }
 
-// BUG(threez) It currently requires to connect directly to the confd database.
-// This can be done by connecting through an ssh tunnel and forward the port
-// 4472, e.g.:
-//
-//      ssh -L 4472:127.0.0.1:4472 root@utm
+// newSessionMsg creates a message for the worker goroutine, done is buffered
+// so that the worker never blocks on callers that stopped waiting
+func newSessionMsg(ctx context.Context, msgType int) *sessionMsg {
+	return &sessionMsg{Type: msgType, Context: ctx, Done: make(chan bool, 1)}
+}
 
 // Conn is the confd connection object
 type Conn struct {
 	Transport              Transport
 	AutomaticErrorHandling bool
-	URL                    *url.URL    // URL that the connection connects to
-	Logger                 *log.Logger // Logger if specified, will log confd actions
-	Options                *Options    // Options represent connection options
-	id                     struct {
+	URL                    *url.URL     // URL that the connection connects to
+	Logger                 *log.Logger  // Logger if specified, will log confd actions
+	LogHandler             slog.Handler // LogHandler if specified, receives a structured record per call
+	Middleware             []Middleware // Middleware wraps every call, the first is the outermost
+	Observer               Observer     // Observer if specified, is notified about calls, e.g. for metrics
+	Tracer                 Tracer       // Tracer if specified, starts spans for calls and transactions
+	Options                *Options     // Options represent connection options
+	Validator              *Validator   // Validator if specified, validates objects before they are set
+	ReadOnly               bool         // ReadOnly if set, refuses calls that modify the configuration
+	CheckRights            bool         // CheckRights if set, refuses calls the user has no rights for
+	NoTxWait               bool         // NoTxWait if set, calls return ErrTxOpen instead of waiting for the open transaction
+	Retry                  *RetryPolicy // Retry if specified, retries read calls that failed because of transport errors
+	*session                            // state shared with the transaction handles
+	tx                     *Tx          // transaction the connection is bound to
+}
+
+// session is the state of a connection that is shared by the connection and
+// the handles of its transactions
+type session struct {
+	exports exportCache // exports used to check calls
+	rights  rightsCache // rights of the user used to check calls
+	id      struct {
 		Value      uint64 // json rpc counter
 		sync.Mutex        // prevent double counting
 	}
-	txMu   *sync.Mutex // prevent multiple write/read transactions
+	gate   txGate // serializes transactions and calls outside of them
 	queue  chan *sessionMsg
 	worker struct {
 		refs uint64 // counts the references to the worker
@@ -59,7 +87,12 @@ type Conn struct {
 	}
 }
 
-// NewConn creates a new confd connection (is not acually connecting)
+// NewConn creates a new confd connection (is not acually connecting).
+// The URL scheme selects the transport: http:// connects directly using
+// plain tcp, https:// using TLS (see TLSTransport) and ssh+confd:// tunnels
+// through ssh (see SSHTransport), e.g.:
+//
+//	confd.NewConn("ssh+confd://root@utm/system")
 func NewConn(URL string) (conn *Conn, err error) {
 	u, err := url.Parse(URL)
 	if err != nil {
@@ -67,18 +100,30 @@ func NewConn(URL string) (conn *Conn, err error) {
 	}
 
 	conn = &Conn{
-		URL:       u,
-		Logger:    nil,
-		Options:   newOptions(u),
-		Transport: &tcpTransport{Timeout: defaultTimeout},
-		queue:     make(chan *sessionMsg),
-		txMu:      &sync.Mutex{},
+		URL:                    u,
+		Logger:                 nil,
+		Options:                newOptions(u),
+		Transport:              newTransport(u),
+		session:                &session{queue: make(chan *sessionMsg)},
 		AutomaticErrorHandling: true,
 	}
 
 	return
 }
 
+// newTransport returns the transport matching the url scheme, https:// urls
+// use TLS, ssh+confd:// urls ssh, everything else plain tcp
+func newTransport(u *url.URL) Transport {
+	switch u.Scheme {
+	case "https":
+		return NewTLSTransport(nil)
+	case sshScheme:
+		return NewSSHTransport()
+	default:
+		return &tcpTransport{Timeout: defaultTimeout}
+	}
+}
+
 // NewAnonymousConn creates a new confd connection (is not acually connecting)
 // to http://127.0.0.1:4472/ (Local Connection)
 func NewAnonymousConn() (conn *Conn) {
@@ -109,22 +154,67 @@ func NewUserConn(username, password, ip string) (conn *Conn) {
 
 // SimpleRequest sends a simple request (untyped response) to the confd
 func (c *Conn) SimpleRequest(method string, params ...interface{}) (interface{}, error) {
+	return c.SimpleRequestContext(context.Background(), method, params...)
+}
+
+// SimpleRequestContext sends a simple request (untyped response) to the
+// confd, the request is aborted if the context is done
+func (c *Conn) SimpleRequestContext(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
 	result := new(interface{})
-	err := c.Request(method, result, params...)
+	err := c.RequestContext(ctx, method, result, params...)
 	return result, err
 }
 
 // Request allows to send request with typed (parsed with json) responses
 func (c *Conn) Request(method string, result interface{}, params ...interface{}) (err error) {
+	return c.RequestContext(context.Background(), method, result, params...)
+}
+
+// RequestContext allows to send request with typed (parsed with json)
+// responses, the request is aborted if the context is done. The call passes
+// the middleware of the connection.
+func (c *Conn) RequestContext(ctx context.Context, method string, result interface{}, params ...interface{}) (err error) {
+	call := &Call{Method: method, Params: params, Result: result}
+	return c.chain()(ctx, call)
+}
+
+// call executes the call after it passed the middleware
+func (c *Conn) call(ctx context.Context, call *Call) (err error) {
+	ctx, span := c.tracer().Start(ctx, SpanCall)
+	stats := callStats{start: time.Now(), attempts: 1}
+	defer func() {
+		call.ID = stats.id
+		c.endCallSpan(span, call, &stats, err)
+		c.observer().ObserveCall(call.Method, time.Since(stats.start), err)
+		c.logCall(ctx, call.Method, &stats, err)
+	}()
+
+	ctx, release, err := c.holdGate(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	err = c.checkExport(ctx, call.Method)
+	if err != nil {
+		return err
+	}
+
 	c.requireWorker()
 	defer c.releaseWorker()
-	err = c.request(c.queuedExecution, method, result, params...)
+	err = c.request(ctx, &stats, c.queuedExecution, call.Method, call.Result, call.Params...)
+	for c.retry(ctx, call.Method, stats.attempts, err) {
+		stats.attempts++
+		err = c.request(ctx, &stats, c.queuedExecution, call.Method, call.Result, call.Params...)
+	}
+	if err == ErrEmptyResponse || err == ErrReturnCode {
+		c.observer().ObserveReturnError(call.Method, err)
+	}
 
 	// automatic error handling
 	if c.AutomaticErrorHandling &&
 		(err == ErrEmptyResponse || err == ErrReturnCode) {
 		c.logf("!! Started automatic error handling because of: %s", err)
-		errList, errCmd := c.ErrList()
+		errList, errCmd := c.ErrListContext(ctx)
 		if errCmd != nil {
 			return errCmd
 		}
@@ -142,33 +232,46 @@ func (c *Conn) Request(method string, result interface{}, params ...interface{})
 // Connect creates a new confd session by calling new and get_SID confd calls.
 // It is preffered to not use the call and create sessions if requests are made
 func (c *Conn) Connect() (err error) {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect but aborts if the context is done
+func (c *Conn) ConnectContext(ctx context.Context) (err error) {
 	c.requireWorker()
 	defer c.releaseWorker()
 	c.logf("Connect to %s", c.safeURL())
-	msg := sessionMsg{Type: msgConnect, Done: make(chan bool)}
-	c.queue <- &msg
-	<-msg.Done // Wait until request was processed
-	return msg.Error
+	return c.enqueue(ctx, newSessionMsg(ctx, msgConnect))
 }
 
 // Close the confd connection
 func (c *Conn) Close() (err error) {
+	return c.CloseContext(context.Background())
+}
+
+// CloseContext closes the confd connection, the detach of the session is
+// aborted if the context is done
+func (c *Conn) CloseContext(ctx context.Context) (err error) {
 	c.requireWorker()
 	defer c.releaseWorker()
 	c.logf("Disconnect from %s", c.safeURL())
-	_ = c.request(c.queuedExecution, "detach", nil) // ignore if we can't detach
-	msg := sessionMsg{Type: msgClose, Done: make(chan bool)}
-	c.queue <- &msg
+	_ = c.request(ctx, nil, c.queuedExecution, "detach", nil) // ignore if we can't detach
+	// the transport needs to be closed even if the context is done already
+	msg := newSessionMsg(ctx, msgClose)
+	c.queue <- msg
 	<-msg.Done // Wait until request was processed
 	return msg.Error
 }
 
-func (c *Conn) request(handler roundTripHandler, method string, result interface{}, params ...interface{}) error {
+// request executes the call, if stats is given the sizes are recorded
+func (c *Conn) request(ctx context.Context, stats *callStats, handler roundTripHandler, method string, result interface{}, params ...interface{}) error {
 	// make sure we are connected
-	err := c.connect()
+	err := c.connect(ctx)
 	if err != nil {
 		return err
 	}
+	if stats != nil {
+		stats.sid = c.Options.SID
+	}
 
 	// request
 	r, err := newRequest(method, params, c.nextID())
@@ -176,7 +279,11 @@ func (c *Conn) request(handler roundTripHandler, method string, result interface
 		return err
 	}
 	c.logf("=> %s", r.String())
-	req, err := r.HTTP(c.URL.Host)
+	if stats != nil {
+		stats.id = r.ID
+		stats.paramsSize = len(*r.Params)
+	}
+	req, err := r.HTTP(ctx, c.URL.Host)
 	if err != nil {
 		return err
 	}
@@ -184,13 +291,16 @@ func (c *Conn) request(handler roundTripHandler, method string, result interface
 	// send request
 	resp, err := handler(req)
 	if err != nil {
-		return err
+		return newTransportError(ctx, err)
 	}
 
 	// decode response
 	respObj, err := newResponse(resp.Body)
 	if respObj != nil {
 		c.logf("<= %v", respObj)
+		if stats != nil && respObj.Result != nil {
+			stats.resultSize = len(*respObj.Result)
+		}
 	}
 	if err != nil {
 		return err
@@ -215,8 +325,13 @@ func (c *Conn) run() {
 		case msg := <-c.queue:
 			switch msg.Type {
 			case msgConnect:
-				msg.Error = c.connect()
+				msg.Error = c.connect(msg.Context)
 			case msgRequest:
+				// skip requests of callers that are not waiting anymore
+				if err := msg.Request.Context().Err(); err != nil {
+					msg.Error = err
+					break
+				}
 				msg.Response, msg.Error = c.directExecution(msg.Request)
 			case msgClose:
 				msg.Error = c.close()
@@ -249,8 +364,8 @@ func (c *Conn) releaseWorker() {
 	c.worker.Lock()
 	c.worker.refs--
 	if c.worker.refs == 0 {
-		msg := sessionMsg{Type: msgQuit, Done: make(chan bool)}
-		c.queue <- &msg
+		msg := newSessionMsg(context.Background(), msgQuit)
+		c.queue <- msg
 		<-msg.Done // Wait until request was processed
 	}
 	c.worker.Unlock()
@@ -258,19 +373,22 @@ func (c *Conn) releaseWorker() {
 
 // Connect creates a new confd session by calling new and get_SID confd calls.
 // It is preffered to not use the call and create sessions if requests are made
-func (c *Conn) connect() (err error) {
+func (c *Conn) connect(ctx context.Context) (err error) {
 	if c.Transport.IsConnected() {
 		return
 	}
-	err = c.Transport.Connect(c.URL)
+	ctx, span := c.tracer().Start(ctx, SpanConnect)
+	defer func() { span.End(unwrapTransportError(err)) }()
+	err = connectTransport(ctx, c.Transport, c.URL)
+	c.observer().ObserveConnect(err)
 	if err != nil {
 		c.logf("Unable to connect %s", err)
-		return
+		return newTransportError(ctx, err)
 	}
-	err = c.request(c.directExecution, "new", nil, c.Options)
+	err = c.request(ctx, nil, c.directExecution, "new", nil, c.Options)
 	if err == nil && c.Options.SID == nil {
 		// if we got a sid we will use it next time
-		err = c.request(c.directExecution, "get_SID", &c.Options.SID)
+		err = c.request(ctx, nil, c.directExecution, "get_SID", &c.Options.SID)
 	}
 	if err != nil {
 		c.logf("Unable to create session %v", err)
@@ -281,41 +399,86 @@ func (c *Conn) connect() (err error) {
 // Close the confd connection
 func (c *Conn) close() (err error) {
 	_ = c.Transport.Close() // ignore close errors
-	c.txMu = &sync.Mutex{}  // force unlocking of the transaction to prevent dead locks
+	c.gate.abort()          // the transaction is lost with the connection
 	return
 }
 
 // logf takes care of logging if a logger is present and removes password
-// information of a given form
+// and other secret information of a given form
 func (c *Conn) logf(format string, args ...interface{}) {
+	if c.Logger == nil && !c.logEnabled(slog.LevelDebug) {
+		return
+	}
+	str := Redact(fmt.Sprintf(format, args...))
 	if c.Logger != nil {
-		str := fmt.Sprintf(format, args...)
-		str = safePasswordRegexp.ReplaceAllString(str, `password":"********"`)
 		c.Logger.Print(str)
 	}
+	if c.logEnabled(slog.LevelDebug) {
+		c.log(slog.NewRecord(time.Now(), slog.LevelDebug, str, 0))
+	}
+}
+
+// Redact removes password information of a given form, the same way it is
+// removed from logs, e.g. before persisting JSON that may contain passwords.
+// Besides passwords the values of keys containing psk, secret, key or
+// private are removed.
+func Redact(str string) string {
+	return safeSecretRegexp.ReplaceAllString(str, safeSecretReplacement)
 }
 
 // Returns a url that doesn't contain a password
 func (c *Conn) safeURL() string {
-	if c.Options.Password != "" {
-		return strings.Replace(c.URL.String(), c.Options.Password, "********", 1)
+	// placeholder consists of unreserved characters only, to prevent escaping
+	const placeholder = "PASSWORDPLACEHOLDER"
+	u := *c.URL
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), placeholder)
+		}
+	}
+	// ssh urls pass the confd password as query parameter
+	if query := u.Query(); query.Get("password") != "" {
+		query.Set("password", placeholder)
+		u.RawQuery = query.Encode()
 	}
-	return c.URL.String()
+	return strings.Replace(u.String(), placeholder, "********", -1)
 }
 
 func (c *Conn) queuedExecution(req *http.Request) (*http.Response, error) {
-	msg := sessionMsg{Type: msgRequest, Request: req, Done: make(chan bool)}
-	c.queue <- &msg
-	<-msg.Done // Wait until request was processed
-
-	if msg.Error != nil {
-		return nil, msg.Error
+	msg := newSessionMsg(req.Context(), msgRequest)
+	msg.Request = req
+	err := c.enqueue(req.Context(), msg)
+	if err != nil {
+		return nil, err
 	}
 	return msg.Response, nil
 }
 
+// enqueue passes the message to the worker and waits until it was processed
+// or the context is done. Messages of callers that stopped waiting are
+// discarded by the worker.
+func (c *Conn) enqueue(ctx context.Context, msg *sessionMsg) error {
+	start := time.Now()
+	select {
+	case c.queue <- msg:
+		c.observer().ObserveQueueWait(time.Since(start))
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-msg.Done: // Wait until request was processed
+		return msg.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (c *Conn) directExecution(req *http.Request) (*http.Response, error) {
+	_, span := c.tracer().Start(req.Context(), SpanRoundTrip)
+	start := time.Now()
 	resp, err := c.Transport.RoundTrip(req)
+	c.observer().ObserveRoundTrip(time.Since(start), err)
+	span.End(err)
 	// send receive operation failed, connection will be closed
 	if err != nil {
 		_ = c.close() // ignore close errors
@@ -5,7 +5,10 @@
 package confd
 
 import (
+	"context"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -13,17 +16,26 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func connHelper() *Conn {
+// utmHelper skips tests that need the confd of a UTM on 127.0.0.1:4472
+// (e.g. through an ssh tunnel), unless CONFD_TEST_UTM is set
+func utmHelper(t *testing.T) {
+	t.Helper()
+	if os.Getenv("CONFD_TEST_UTM") == "" {
+		t.Skip("needs a UTM confd on 127.0.0.1:4472, set CONFD_TEST_UTM to run")
+	}
+}
+
+func connHelper(t *testing.T) *Conn {
+	utmHelper(t)
 	conn := NewAnonymousConn()
 	conn.Logger = log.New(os.Stdout, "confd ", log.LstdFlags)
 	conn.Options.Name = "confd-package-test"
-	t := conn.Transport.(*tcpTransport)
-	t.Timeout = time.Second * 1
+	conn.Transport.(*tcpTransport).Timeout = time.Second * 1
 	return conn
 }
 
-func systemConnHelper() *Conn {
-	conn := connHelper()
+func systemConnHelper(t *testing.T) *Conn {
+	conn := connHelper(t)
 	conn.Options.Username = "system"
 	return conn
 }
@@ -41,11 +53,12 @@ func TestConnFailed(t *testing.T) {
 }
 
 func TestInvalidCmd(t *testing.T) {
-	conn := connHelper()
+	conn := connHelper(t)
 	_, err := conn.SimpleRequest("foobar")
 	assert.Error(t, err)
 	assert.Equal(t, err.Error(), "FATAL [FUNCTION_UNKNOWN] No public "+
 		"function 'foobar' is provided by this Confd.")
+	assert.ErrorIs(t, err, ErrFunctionUnknown)
 }
 
 func TestSafeURL(t *testing.T) {
@@ -55,7 +68,7 @@ func TestSafeURL(t *testing.T) {
 }
 
 func TestSID(t *testing.T) {
-	conn := connHelper()
+	conn := connHelper(t)
 	defer func() { _ = conn.Close() }()
 	assert.True(t, conn.Options.SID == nil)
 
@@ -68,3 +81,34 @@ func TestSID(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, conn.Options.SID == old)
 }
+
+func TestRequestContextDeadline(t *testing.T) {
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	conn, err := NewConn(server.URL)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	started := time.Now()
+	_, err = conn.SimpleRequestContext(ctx, "get_SID")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(started) < time.Millisecond*300,
+		"must timeout before 300ms")
+	assert.False(t, conn.Transport.IsConnected())
+}
+
+func TestRequestContextCanceled(t *testing.T) {
+	conn, err := NewConn("http://127.0.0.1:50001")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = conn.SimpleRequestContext(ctx, "get_SID")
+	assert.Equal(t, context.Canceled, err)
+}
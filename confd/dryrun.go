@@ -0,0 +1,324 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/threez/sophos-utm9/internal/textutil"
+)
+
+// errDryRun is returned to WithWriteTransaction to roll the dry run back
+var errDryRun = errors.New("Dry run")
+
+// DryRunReport describes what confd accepted and rejected during a dry run
+type DryRunReport struct {
+	Err    error   `json:"-"`                // Err returned by the function
+	Errors ErrList `json:"errors,omitempty"` // Errors reported during the transaction
+	Fatal  ErrList `json:"fatal,omitempty"`  // Fatal errors
+	NoAck  ErrList `json:"noack,omitempty"`  // NoAck errors that are not acknowledged
+	// Before and After contain the objects and top level nodes modified by
+	// the function, before their first modification and at the end
+	Before DryRunState `json:"before"`
+	After  DryRunState `json:"after"`
+	// Untracked lists the other functions that modify the configuration
+	// called by the function, their changes are not part of Before and After
+	Untracked []string `json:"untracked,omitempty"`
+}
+
+// DryRunState contains the objects and nodes modified during a dry run
+type DryRunState struct {
+	Objects []AnyObject            `json:"objects"` // sorted by ref, missing objects are omitted
+	Nodes   map[string]interface{} `json:"nodes"`   // top level nodes
+}
+
+// Accepted returns true if the function succeeded and confd reported neither
+// fatal nor unacknowledged errors
+func (r *DryRunReport) Accepted() bool {
+	return r.Err == nil && len(r.Fatal) == 0 && len(r.NoAck) == 0
+}
+
+// WithDryRun calls fn inside of a write transaction like WithWriteTransaction,
+// but the transaction is always rolled back, the configuration is never
+// modified. The report contains the errors reported by confd and the objects
+// and nodes modified using the handle, they are read before they are
+// modified the first time and at the end of the dry run. Objects that are
+// changed indirectly (e.g. references updated by move_object) are not part
+// of the report. The returned error describes failures of the dry run itself,
+// the error of fn is part of the report.
+func (c *Conn) WithDryRun(ctx context.Context, fn func(tx *Tx) error) (*DryRunReport, error) {
+	report := new(DryRunReport)
+	err := c.WithWriteTransaction(ctx, func(tx *Tx) (err error) {
+		rec := newDryRunRecorder(tx.Conn)
+		n := len(tx.Middleware)
+		tx.Middleware = append(tx.Middleware[:n:n], rec.record)
+		report.Err = fn(tx)
+
+		// the errors are collected first, before other calls are made
+		if report.Errors, err = tx.ErrListContext(ctx); err != nil {
+			return err
+		}
+		if report.Fatal, err = tx.ErrListFatalContext(ctx); err != nil {
+			return err
+		}
+		if report.NoAck, err = tx.ErrListNoAckContext(ctx); err != nil {
+			return err
+		}
+		return rec.report(ctx, report)
+	})
+	var terr *ErrTransaction
+	switch {
+	case !errors.As(err, &terr):
+		return nil, err // the transaction didn't begin
+	case terr.Cause != errDryRun:
+		return nil, err
+	case terr.Rollback != nil:
+		return nil, terr.Rollback
+	}
+	return report, nil
+}
+
+// dryRunRecorder records the objects and nodes modified during a dry run
+// and their state before the first modification
+type dryRunRecorder struct {
+	conn     *Conn // conn of the transaction without the recorder
+	mu       sync.Mutex
+	err      error // err of the first failed read
+	dupErrs  bool  // reads of missing objects reported errors
+	before   DryRunState
+	refs     map[string]bool
+	nodes    map[string]bool
+	untraced []string // other functions called, might modify the configuration
+}
+
+func newDryRunRecorder(conn *Conn) *dryRunRecorder {
+	reader := *conn // the copy shares session and transaction
+	return &dryRunRecorder{
+		conn:   &reader,
+		before: DryRunState{Nodes: make(map[string]interface{})},
+		refs:   make(map[string]bool),
+		nodes:  make(map[string]bool),
+	}
+}
+
+// record is the middleware recording the calls of the transaction handle,
+// the state of the modified objects and nodes is read before the call. The
+// mutex isn't held during the call, since the call itself might make calls
+// using the handle (e.g. the automatic error handling).
+func (r *dryRunRecorder) record(next CallHandler) CallHandler {
+	return func(ctx context.Context, call *Call) error {
+		create := r.prepare(ctx, call)
+		err := next(ctx, call)
+		if result, ok := call.Result.(*interface{}); ok && create {
+			if ref, _ := (*result).(string); ref != "" {
+				r.mu.Lock()
+				r.refs[ref] = true // created, there is no state before
+				r.mu.Unlock()
+			}
+		}
+		return err
+	}
+}
+
+// prepare reads the state of the objects and nodes the call modifies, it
+// returns true if the call creates an object
+func (r *dryRunRecorder) prepare(ctx context.Context, call *Call) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch call.Method {
+	case "set_object":
+		var meta ObjectMeta
+		if len(call.Params) > 0 {
+			data, err := json.Marshal(call.Params[0])
+			if err == nil {
+				_ = json.Unmarshal(data, &meta) // ref stays empty
+			}
+		}
+		if meta.Ref == "" {
+			return true
+		}
+		r.addRef(ctx, meta.Ref, meta.Class, meta.Type)
+	case "change_object", "del_object", "reset_object", "lock_object":
+		r.addRef(ctx, param(call, 0), "", "")
+	case "move_object":
+		r.addRef(ctx, param(call, 0), "", "")
+		old, _ := r.before.object(param(call, 0))
+		r.addRef(ctx, param(call, 1), old.Class, old.Type)
+	case "set":
+		r.addNode(ctx, param(call, 1))
+	case "reset":
+		r.addNode(ctx, param(call, 0))
+	default:
+		r.untraced = append(r.untraced, call.Method)
+	}
+	return false
+}
+
+// addRef reads the object before its first modification, the mutex is held.
+// If class and type are known, the objects of the type are read instead, so
+// that missing objects aren't reported as error.
+func (r *dryRunRecorder) addRef(ctx context.Context, ref, class, typ string) {
+	if ref == "" || r.refs[ref] {
+		return
+	}
+	r.refs[ref] = true
+	if class == "" {
+		obj, found, err := r.object(ctx, ref)
+		if err != nil && r.err == nil {
+			r.err = err
+		}
+		r.dupErrs = r.dupErrs || (err == nil && !found)
+		if found {
+			r.before.Objects = append(r.before.Objects, obj)
+		}
+		return
+	}
+	filter := r.conn.FilterObjects().ClassName(class)
+	if typ != "" {
+		filter = filter.TypeName(typ)
+	}
+	objects, err := filter.GetContext(ctx)
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("Failed to get object %s: %w", ref, err)
+	}
+	for _, obj := range objects {
+		if obj.Ref == ref {
+			r.before.Objects = append(r.before.Objects, obj)
+		}
+	}
+}
+
+// addNode reads the top level node before its first modification, the mutex
+// is held
+func (r *dryRunRecorder) addNode(ctx context.Context, name string) {
+	if name == "" || r.nodes[name] {
+		return
+	}
+	r.nodes[name] = true
+	value, err := r.node(ctx, name)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	r.before.Nodes[name] = value
+}
+
+// report completes the report with the recorded state, the untraced
+// functions and the state at the end of the dry run. It returns errDryRun
+// to roll the transaction back.
+func (r *dryRunRecorder) report(ctx context.Context, report *DryRunReport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if r.dupErrs {
+		report.Errors = uniqueErrs(report.Errors)
+		report.Fatal = uniqueErrs(report.Fatal)
+		report.NoAck = uniqueErrs(report.NoAck)
+	}
+
+	exports, err := r.conn.cachedExports(ctx)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, method := range r.untraced {
+		if bool(exports[method].Write) && !seen[method] {
+			seen[method] = true
+			report.Untracked = append(report.Untracked, method)
+		}
+	}
+	sort.Strings(report.Untracked)
+
+	report.Before = r.before
+	sort.Slice(report.Before.Objects, func(i, j int) bool {
+		return report.Before.Objects[i].Ref < report.Before.Objects[j].Ref
+	})
+	report.After = DryRunState{Nodes: make(map[string]interface{}, len(r.nodes))}
+	for _, ref := range textutil.SortedKeys(r.refs) {
+		obj, found, err := r.object(ctx, ref)
+		if err != nil {
+			return err
+		}
+		if found {
+			report.After.Objects = append(report.After.Objects, obj)
+		}
+	}
+	for _, name := range textutil.SortedKeys(r.nodes) {
+		if report.After.Nodes[name], err = r.node(ctx, name); err != nil {
+			return err
+		}
+	}
+	return errDryRun
+}
+
+// object reads the object, missing objects aren't an error
+func (r *dryRunRecorder) object(ctx context.Context, ref string) (AnyObject, bool, error) {
+	obj, err := r.conn.GetAnyObjectContext(ctx, ref)
+	var errs ErrList
+	if errors.Is(err, ErrEmptyResponse) || errors.As(err, &errs) {
+		return AnyObject{}, false, nil
+	}
+	if err != nil {
+		return AnyObject{}, false, fmt.Errorf("Failed to get object %s: %w", ref, err)
+	}
+	return *obj, true, nil
+}
+
+// node reads the top level node
+func (r *dryRunRecorder) node(ctx context.Context, name string) (interface{}, error) {
+	var value interface{}
+	err := r.conn.RequestContext(ctx, "get", &value, name)
+	if err == ErrReturnCode {
+		value, err = float64(0), nil // the node value is 0
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get node %s: %w", name, err)
+	}
+	return value, nil
+}
+
+// object returns the object of the state with the given ref
+func (s DryRunState) object(ref string) (AnyObject, bool) {
+	for _, obj := range s.Objects {
+		if obj.Ref == ref {
+			return obj, true
+		}
+	}
+	return AnyObject{}, false
+}
+
+// uniqueErrs removes duplicated error descriptions, reading a missing
+// object reports the same error as the call modifying it
+func uniqueErrs(errs ErrList) ErrList {
+	var unique ErrList
+	for _, desc := range errs {
+		duplicate := false
+		for _, other := range unique {
+			if reflect.DeepEqual(desc, other) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			unique = append(unique, desc)
+		}
+	}
+	return unique
+}
+
+// param returns the parameter as string, empty if missing
+func param(call *Call, i int) string {
+	if i >= len(call.Params) {
+		return ""
+	}
+	return fmt.Sprint(call.Params[i])
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+)
+
+func TestWithDryRun(t *testing.T) {
+	srv := withTxHelper()
+	defer srv.Close()
+	host := object("network", "host", map[string]interface{}{"name": "Host"})
+	host.Ref = "REF_NetHost"
+	srv.AddObjects(host)
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	var created string
+	report, err := conn.WithDryRun(context.Background(), func(tx *confd.Tx) error {
+		if _, err := tx.SetNodeValue(1, "ntp", "status"); err != nil {
+			return err
+		}
+		if err := tx.ChangeObject(host.Ref, map[string]interface{}{"name": "Changed"}); err != nil {
+			return err
+		}
+		var err error
+		created, err = tx.SetObject(object("network", "host",
+			map[string]interface{}{"name": "New"}), false)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.True(t, report.Accepted())
+	assert.Empty(t, report.Errors)
+	assert.Empty(t, report.Untracked)
+
+	if assert.Len(t, report.Before.Objects, 1, "created objects have no state before") {
+		assert.Equal(t, "Host", report.Before.Objects[0].Data["name"])
+	}
+	assert.Equal(t, map[string]interface{}{"ntp": map[string]interface{}{"status": float64(0)}},
+		report.Before.Nodes)
+	if assert.Len(t, report.After.Objects, 2) {
+		assert.Equal(t, "Changed", report.After.Objects[0].Data["name"])
+		assert.Equal(t, created, report.After.Objects[1].Ref)
+	}
+	assert.Equal(t, map[string]interface{}{"ntp": map[string]interface{}{"status": float64(1)}},
+		report.After.Nodes)
+
+	// only the modified objects were read and nothing was changed
+	assert.NotContains(t, srv.Calls(), "get_objects")
+	assert.NotContains(t, srv.Calls(), "commit")
+	assert.Contains(t, srv.Calls(), "unlock")
+	obj, _ := srv.Object(host.Ref)
+	assert.Equal(t, "Host", obj.Data["name"])
+	_, err = conn.GetObjectClasses()
+	assert.NoError(t, err, "the transaction ended")
+}
+
+func TestWithDryRunRejected(t *testing.T) {
+	srv := withTxHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	report, err := conn.WithDryRun(context.Background(), func(tx *confd.Tx) error {
+		return tx.ChangeObject("REF_Missing", map[string]interface{}{"name": "Missing"})
+	})
+	assert.NoError(t, err)
+	assert.Error(t, report.Err)
+	assert.False(t, report.Accepted())
+	assert.Len(t, report.Errors, 1, "the error of the read isn't reported twice")
+	assert.Empty(t, report.Before.Objects)
+	assert.Empty(t, report.After.Objects)
+
+	report, err = conn.WithDryRun(context.Background(), func(tx *confd.Tx) error {
+		_, err := tx.SimpleRequest("reset", "ntp")
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, report.After.Nodes, "ntp")
+
+	report, err = conn.WithDryRun(context.Background(), func(tx *confd.Tx) error {
+		_, err := tx.SimpleRequest("lock_override")
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, report.Untracked, "read functions aren't reported")
+}
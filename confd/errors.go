@@ -5,10 +5,45 @@
 package confd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 )
 
+// Errors to classify the errors of calls using errors.Is. Error descriptions
+// reported by confd match the error of their message type, e.g.
+//
+//	if errors.Is(err, confd.ErrObjectLocked) { ... }
+//
+// The errors of the client that describe the same problem match as well,
+// e.g. *ValidationError matches ErrValidation.
+//
+// ErrPermissionDenied is only matched by the errors of the client side rights
+// checks and read only connections (*ErrInsufficientRights and *ErrReadOnly),
+// no message type of confd is known to report missing rights.
+var (
+	ErrFunctionUnknown  = errors.New("Function is unknown")
+	ErrPermissionDenied = errors.New("Permission denied")
+	ErrObjectInUse      = errors.New("Object is in use")
+	ErrObjectLocked     = errors.New("Object is locked")
+	ErrNameConflict     = errors.New("Object name or reference already exists")
+	ErrValidation       = errors.New("Validation failed")           // *_INVALID message types and *ValidationError
+	ErrLockConflict     = errors.New("Configuration lock conflict") // lock was refused
+	ErrTransport        = errors.New("Transport failed")            // connection or round trip failed
+	ErrProtocol         = errors.New("Protocol error")              // response isn't a valid JSON-RPC response
+)
+
+// msgTypeErrors maps the confd message types to the errors they match,
+// message types ending with _INVALID are matched by ErrValidation
+var msgTypeErrors = map[string]error{
+	"FUNCTION_UNKNOWN":     ErrFunctionUnknown,
+	"OBJECT_DELETE_USED":   ErrObjectInUse,
+	"OBJECT_DELETE_LOCKED": ErrObjectLocked,
+	"OBJECT_NAME_EXISTS":   ErrNameConflict,
+	"OBJECT_REF_EXISTS":    ErrNameConflict,
+}
+
 // ErrDescription is returned by ErrList* functions and details the occured
 // error
 type ErrDescription struct {
@@ -39,6 +74,16 @@ func (e ErrList) Error() string {
 	return strings.Join(errStr, " and ")
 }
 
+// Unwrap returns the error descriptions, so that errors.Is and errors.As
+// match any of them
+func (e ErrList) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, desc := range e {
+		errs[i] = desc
+	}
+	return errs
+}
+
 func (e ErrDescription) Error() string {
 	if bool(e.Fatal) {
 		return fmt.Sprintf("FATAL [%s] %s", e.MessageType, e.Name)
@@ -46,33 +91,67 @@ func (e ErrDescription) Error() string {
 	return fmt.Sprintf("[%s] %s", e.MessageType, e.Name)
 }
 
+// Is returns true if the message type matches the target error (see
+// ErrFunctionUnknown and others)
+func (e ErrDescription) Is(target error) bool {
+	if err, ok := msgTypeErrors[e.MessageType]; ok {
+		return err == target
+	}
+	return target == ErrValidation && strings.HasSuffix(e.MessageType, "_INVALID")
+}
+
 // ErrAck add some error context patterns to the list of acknowledged errors.
 // These errors will be ignored during the next public method call or for the
 // time of the transaction.
 func (c *Conn) ErrAck(errs ErrList) error {
-	return c.Request("err_ack", nil, errs)
+	return c.ErrAckContext(context.Background(), errs)
+}
+
+// ErrAckContext is like ErrAck but honours the context
+func (c *Conn) ErrAckContext(ctx context.Context, errs ErrList) error {
+	return c.RequestContext(ctx, "err_ack", nil, errs)
 }
 
 // ErrAckAll expands to the catch-all pattern {}
 func (c *Conn) ErrAckAll() error {
-	return c.Request("err_ack", nil, "all")
+	return c.ErrAckAllContext(context.Background())
+}
+
+// ErrAckAllContext is like ErrAckAll but honours the context
+func (c *Conn) ErrAckAllContext(ctx context.Context) error {
+	return c.RequestContext(ctx, "err_ack", nil, "all")
 }
 
 // ErrAckLast expands to the result of ErrList()
 func (c *Conn) ErrAckLast() error {
-	return c.Request("err_ack", nil, "last")
+	return c.ErrAckLastContext(context.Background())
+}
+
+// ErrAckLastContext is like ErrAckLast but honours the context
+func (c *Conn) ErrAckLastContext(ctx context.Context) error {
+	return c.RequestContext(ctx, "err_ack", nil, "last")
 }
 
 // ErrAckNone clears the list of patterns.
 func (c *Conn) ErrAckNone() error {
-	return c.Request("err_ack", nil, "none")
+	return c.ErrAckNoneContext(context.Background())
+}
+
+// ErrAckNoneContext is like ErrAckNone but honours the context
+func (c *Conn) ErrAckNoneContext(ctx context.Context) error {
+	return c.RequestContext(ctx, "err_ack", nil, "none")
 }
 
 // ErrIsFatal tells whether the last public method call had fatal errors.
 // Returns the number of fatal errors during the last method call.
 func (c *Conn) ErrIsFatal() (uint64, error) {
+	return c.ErrIsFatalContext(context.Background())
+}
+
+// ErrIsFatalContext is like ErrIsFatal but honours the context
+func (c *Conn) ErrIsFatalContext(ctx context.Context) (uint64, error) {
 	var num uint64
-	err := c.Request("err_is_fatal", &num)
+	err := c.RequestContext(ctx, "err_is_fatal", &num)
 	return num, err
 }
 
@@ -80,31 +159,51 @@ func (c *Conn) ErrIsFatal() (uint64, error) {
 // had been acknowledged. Returns The number of non-acknowledged errors during
 // the last method call.
 func (c *Conn) ErrIsNoack() (uint64, error) {
+	return c.ErrIsNoackContext(context.Background())
+}
+
+// ErrIsNoackContext is like ErrIsNoack but honours the context
+func (c *Conn) ErrIsNoackContext(ctx context.Context) (uint64, error) {
 	var num uint64
-	err := c.Request("err_is_noack", &num)
+	err := c.RequestContext(ctx, "err_is_noack", &num)
 	return num, err
 }
 
 // ErrList lists the errors that occurred since the last write transaction, or,
 // when not in transaction, during the last transanction.
 func (c *Conn) ErrList() (ErrList, error) {
+	return c.ErrListContext(context.Background())
+}
+
+// ErrListContext is like ErrList but honours the context
+func (c *Conn) ErrListContext(ctx context.Context) (ErrList, error) {
 	var errors ErrList
-	err := c.Request("err_list", &errors)
+	err := c.RequestContext(ctx, "err_list", &errors)
 	return errors, err
 }
 
 // ErrListFatal lists all fatal errors that occurred since the last write
 // transaction, or, when not in transaction, during the last transanction.
 func (c *Conn) ErrListFatal() (ErrList, error) {
+	return c.ErrListFatalContext(context.Background())
+}
+
+// ErrListFatalContext is like ErrListFatal but honours the context
+func (c *Conn) ErrListFatalContext(ctx context.Context) (ErrList, error) {
 	var errors ErrList
-	err := c.Request("err_list_fatal", &errors)
+	err := c.RequestContext(ctx, "err_list_fatal", &errors)
 	return errors, err
 }
 
 // ErrListNoAck lists unacknowledged errors that occurred since the last write
 // transaction, or, when not in transaction, during the last transanction.
 func (c *Conn) ErrListNoAck() (ErrList, error) {
+	return c.ErrListNoAckContext(context.Background())
+}
+
+// ErrListNoAckContext is like ErrListNoAck but honours the context
+func (c *Conn) ErrListNoAckContext(ctx context.Context) (ErrList, error) {
 	var errors ErrList
-	err := c.Request("err_list_noack", &errors)
+	err := c.RequestContext(ctx, "err_list_noack", &errors)
 	return errors, err
 }
@@ -0,0 +1,115 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+func TestErrorKinds(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	host := object("network", "host", map[string]interface{}{"name": "Host"})
+	host.Ref = "REF_NetHost"
+	host.Nodel = "1"
+	srv.AddObjects(host)
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	_, err := conn.SimpleRequest("foobar")
+	assert.ErrorIs(t, err, confd.ErrFunctionUnknown)
+	assert.NotErrorIs(t, err, confd.ErrObjectLocked)
+	var desc confd.ErrDescription
+	assert.True(t, errors.As(err, &desc))
+	assert.Equal(t, "FUNCTION_UNKNOWN", desc.MessageType)
+
+	ok, err := conn.DelObject(host.Ref)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	errs, err := conn.ErrList()
+	assert.NoError(t, err)
+	assert.ErrorIs(t, errs, confd.ErrObjectLocked)
+
+	_, err = conn.SetObject(object("network", "host",
+		map[string]interface{}{"name": "Host"}), false)
+	assert.ErrorIs(t, err, confd.ErrNameConflict)
+	_, err = conn.SetObject(confd.AnyObject{}, false)
+	assert.ErrorIs(t, err, confd.ErrValidation)
+
+	other := srv.Conn()
+	defer func() { _ = other.Close() }()
+	tx, err := conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	_, err = other.BeginWriteTransaction()
+	assert.ErrorIs(t, err, confd.ErrLockConflict)
+	assert.ErrorIs(t, err, confd.ErrReturnCode, "the error of the lock call is kept")
+	assert.NoError(t, tx.Rollback())
+}
+
+func TestErrDescriptionIs(t *testing.T) {
+	desc := confd.ErrDescription{MessageType: "OBJECT_DELETE_LOCKED"}
+	assert.ErrorIs(t, desc, confd.ErrObjectLocked)
+	desc = confd.ErrDescription{MessageType: "OBJECT_DELETE_USED"}
+	assert.ErrorIs(t, desc, confd.ErrObjectInUse)
+	desc = confd.ErrDescription{MessageType: "OBJECT_REF_EXISTS"}
+	assert.ErrorIs(t, desc, confd.ErrNameConflict)
+	desc = confd.ErrDescription{MessageType: "SOMETHING_INVALID", Rights: "ADMIN"}
+	assert.ErrorIs(t, desc, confd.ErrValidation)
+	assert.NotErrorIs(t, desc, confd.ErrPermissionDenied, "rights aren't guessed")
+	desc = confd.ErrDescription{MessageType: "SOMETHING_ELSE"}
+	assert.NotErrorIs(t, desc, confd.ErrValidation)
+
+	errs := confd.ErrList{
+		{MessageType: "SOMETHING_ELSE"},
+		{MessageType: "FUNCTION_UNKNOWN"},
+	}
+	assert.ErrorIs(t, errs, confd.ErrFunctionUnknown)
+	assert.NotErrorIs(t, errs, confd.ErrObjectLocked)
+}
+
+func TestTransportError(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	conn, transport := retryHelper(srv)
+	defer func() { _ = conn.Close() }()
+	conn.Retry = nil
+
+	// the error of the transport is wrapped, not replaced
+	transport.fail(1)
+	_, err := conn.GetObjectClasses()
+	assert.ErrorIs(t, err, confd.ErrTransport)
+	assert.ErrorIs(t, err, errFlaky)
+	assert.Equal(t, errFlaky.Error(), err.Error())
+
+	conn, err = confd.NewConn("http://127.0.0.1:1/system")
+	assert.NoError(t, err)
+	err = conn.Connect()
+	assert.ErrorIs(t, err, confd.ErrTransport)
+	var opErr *net.OpError
+	assert.True(t, errors.As(err, &opErr), "%T", err)
+}
+
+func TestProtocolError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<html>not json</html>"))
+	}))
+	defer srv.Close()
+	conn, err := confd.NewConn(srv.URL)
+	assert.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.GetObjectClasses()
+	assert.ErrorIs(t, err, confd.ErrProtocol)
+	assert.NotErrorIs(t, err, confd.ErrTransport)
+	assert.ErrorIs(t, confd.ErrEmptyResponse, confd.ErrProtocol)
+}
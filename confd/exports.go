@@ -4,6 +4,11 @@
 
 package confd
 
+import (
+	"context"
+	"sync"
+)
+
 // Export represents an exported confd function
 type Export struct {
 	Write  Bool     `json:"write"`
@@ -16,7 +21,38 @@ type Export struct {
 
 // Exports returns all available exports (see definition of export)
 func (c *Conn) Exports() (map[string]Export, error) {
+	return c.ExportsContext(context.Background())
+}
+
+// ExportsContext is like Exports but honours the context
+func (c *Conn) ExportsContext(ctx context.Context) (map[string]Export, error) {
 	response := make(map[string]Export)
-	err := c.Request("get_exports", &response)
+	err := c.RequestContext(ctx, "get_exports", &response)
 	return response, err
 }
+
+// exportCache holds the exports of the confd once they were requested
+type exportCache struct {
+	exports map[string]Export
+	sync.Mutex
+}
+
+// cachedExports returns the exports, they are requested once per connection.
+// The mutex isn't held during the request, since the request itself waits
+// for the gate of the session.
+func (c *Conn) cachedExports(ctx context.Context) (map[string]Export, error) {
+	c.exports.Lock()
+	exports := c.exports.exports
+	c.exports.Unlock()
+	if exports != nil {
+		return exports, nil
+	}
+	exports, err := c.ExportsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.exports.Lock()
+	c.exports.exports = exports
+	c.exports.Unlock()
+	return exports, nil
+}
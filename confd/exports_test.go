@@ -11,7 +11,7 @@ import (
 )
 
 func TestExports(t *testing.T) {
-	conn := connHelper()
+	conn := connHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	exports, err := conn.Exports()
@@ -4,6 +4,10 @@
 
 package confd
 
+import (
+	"context"
+)
+
 // FilterObjects allows filtering of objects.
 // Multiple top level filter imply "and" expression.
 func (c *Conn) FilterObjects() *ObjectFilter {
@@ -20,6 +24,11 @@ type ObjectFilter struct {
 
 // Get all objects found by the filter
 func (f *ObjectFilter) Get() ([]AnyObject, error) {
+	return f.GetContext(context.Background())
+}
+
+// GetContext is like Get but honours the context
+func (f *ObjectFilter) GetContext(ctx context.Context) ([]AnyObject, error) {
 	var objects []AnyObject
 	args := make([]interface{}, 2+len(f.attributeFilters))
 	args[0] = f.className
@@ -27,7 +36,7 @@ func (f *ObjectFilter) Get() ([]AnyObject, error) {
 	for i, arg := range f.attributeFilters {
 		args[i+2] = arg
 	}
-	err := f.conn.Request("get_objects", &objects, args...)
+	err := f.conn.RequestContext(ctx, "get_objects", &objects, args...)
 	return objects, err
 }
 
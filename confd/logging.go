@@ -0,0 +1,101 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// callStats are collected during a call and logged using the LogHandler
+type callStats struct {
+	start      time.Time
+	attempts   int
+	id         uint64      // id of the last request
+	sid        interface{} // session id of the last request
+	paramsSize int         // size of the json encoded params
+	resultSize int         // size of the json encoded result
+}
+
+// logEnabled returns true if the LogHandler handles records of the level
+func (c *Conn) logEnabled(level slog.Level) bool {
+	return c.LogHandler != nil && c.LogHandler.Enabled(context.Background(), level)
+}
+
+// log passes the record to the LogHandler, errors of the handler are ignored
+func (c *Conn) log(record slog.Record) {
+	_ = c.LogHandler.Handle(context.Background(), record)
+}
+
+// logCall emits the record of a call. Successful calls are logged with info
+// level, failed calls with error level. Error lists are logged with all
+// error descriptions.
+func (c *Conn) logCall(ctx context.Context, method string, stats *callStats, err error) {
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelError
+	}
+	if c.LogHandler == nil || !c.LogHandler.Enabled(ctx, level) {
+		return
+	}
+
+	record := slog.NewRecord(time.Now(), level, "confd call", 0)
+	record.AddAttrs(
+		slog.String("method", method),
+		slog.Uint64("id", stats.id),
+		slog.Int("params_size", stats.paramsSize),
+		slog.Int("result_size", stats.resultSize),
+		slog.Duration("duration", time.Since(stats.start)),
+		slog.Int("attempts", stats.attempts),
+	)
+	if err != nil {
+		record.AddAttrs(
+			slog.String("error", Redact(err.Error())),
+			slog.String("error_type", fmt.Sprintf("%T", err)),
+		)
+		var errs ErrList
+		if errors.As(err, &errs) {
+			attrs := make([]interface{}, len(errs))
+			for i, desc := range errs {
+				attrs[i] = slog.Any(strconv.Itoa(i), desc)
+			}
+			record.AddAttrs(slog.Group("errors", attrs...))
+		}
+	}
+	_ = c.LogHandler.Handle(ctx, record) // ignore errors of the handler
+}
+
+// LogValue implements slog.LogValuer, only fields that are set are logged
+func (e ErrDescription) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("msgtype", e.MessageType),
+		slog.String("name", e.Name),
+		slog.Bool("fatal", bool(e.Fatal)),
+	}
+	for _, field := range []struct{ key, value string }{
+		{"ref", e.Ref},
+		{"class", e.Class},
+		{"type", e.Type},
+		{"objname", e.ObjectName},
+		{"rights", e.Rights},
+		{"perms", e.Permission},
+		{"format", e.Format},
+	} {
+		if field.value != "" {
+			attrs = append(attrs, slog.String(field.key, field.value))
+		}
+	}
+	if len(e.Attributes) > 0 {
+		attrs = append(attrs, slog.Any("attrs", e.Attributes))
+	}
+	if len(e.ObjectAttributes) > 0 {
+		attrs = append(attrs, slog.Any("Oattrs", e.ObjectAttributes))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,96 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+// records returns the logged json records with the given message
+func records(t *testing.T, buf *bytes.Buffer, msg string) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		if record["msg"] == msg {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+func TestLogHandler(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	srv.AddObjects(confd.AnyObject{
+		ObjectMeta: confd.ObjectMeta{Ref: "REF_Locked", Class: "network",
+			Type: "host", Nodel: "1"},
+		Data: map[string]interface{}{"name": "Locked"},
+	})
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	var buf bytes.Buffer
+	conn.LogHandler = slog.NewJSONHandler(&buf, nil)
+
+	_, err := conn.GetObjectClasses()
+	assert.NoError(t, err)
+	calls := records(t, &buf, "confd call")
+	if assert.Len(t, calls, 1) {
+		call := calls[0]
+		assert.Equal(t, "INFO", call["level"])
+		assert.Equal(t, "get_object_classes", call["method"])
+		assert.Equal(t, float64(2), call["id"], "new and get_SID are sent before")
+		assert.Equal(t, float64(len("null")), call["params_size"])
+		assert.Equal(t, float64(len(`["network"]`)), call["result_size"])
+		assert.Contains(t, call, "duration")
+		assert.Equal(t, float64(1), call["attempts"])
+		assert.NotContains(t, call, "error")
+	}
+	assert.Empty(t, records(t, &buf, "=> [2] get_object_classes()"),
+		"debug messages are filtered by the handler")
+
+	buf.Reset()
+	_, err = conn.DelObject("REF_Locked")
+	assert.NoError(t, err)
+	_, err = conn.SimpleRequest("del_object", "REF_Locked")
+	assert.Error(t, err)
+	calls = records(t, &buf, "confd call")
+	if assert.Len(t, calls, 3) {
+		// del_object returning a Bool, del_object failing, err_list
+		call := calls[2]
+		assert.Equal(t, "ERROR", call["level"])
+		assert.Equal(t, "del_object", call["method"])
+		assert.Equal(t, "confd.ErrList", call["error_type"])
+		errs := call["errors"].(map[string]interface{})
+		desc := errs["0"].(map[string]interface{})
+		assert.Equal(t, "OBJECT_DELETE_LOCKED", desc["msgtype"])
+		assert.Equal(t, "REF_Locked", desc["ref"])
+		assert.Equal(t, true, desc["fatal"])
+	}
+}
+
+func TestLogHandlerRedaction(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	conn.Options.Password = "secret"
+	var buf bytes.Buffer
+	conn.LogHandler = slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+
+	_, err := conn.SimpleRequest("get_SID")
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "new(")
+	assert.Contains(t, buf.String(), `password\":\"********\"`)
+	assert.NotContains(t, buf.String(), "secret")
+}
@@ -5,36 +5,39 @@
 package confd
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestErr(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	tx, err := conn.BeginWriteTransaction()
-	assert.NoError(t, err)
+	if !assert.NoError(t, err) {
+		return
+	}
 	defer func() { _ = tx.Rollback() }()
 
-	conn.requireWorker()
+	tx.requireWorker()
 	// Try to delete an object that is protected/used. Deletion should throw a
 	// non-acknowledgeable fatal error.
-	err = conn.request(conn.queuedExecution, "del_object",
+	err = tx.request(context.Background(), nil, tx.queuedExecution, "del_object",
 		nil, "REF_DefaultInternalNetwork")
 	assert.Equal(t, ErrReturnCode, err)
-	conn.releaseWorker()
+	tx.releaseWorker()
 
-	num, err := conn.ErrIsFatal()
+	num, err := tx.ErrIsFatal()
 	assert.NoError(t, err)
 	assert.True(t, num > 0)
 
-	num, err = conn.ErrIsNoack()
+	num, err = tx.ErrIsNoack()
 	assert.NoError(t, err)
 	assert.True(t, num > 0)
 
-	errs, err := conn.ErrList()
+	errs, err := tx.ErrList()
 	assert.NoError(t, err)
 	assert.True(t, len(errs) > 0)
 	assert.Equal(t, "OBJECT_DELETE_LOCKED", errs[0].MessageType)
@@ -42,11 +45,11 @@ func TestErr(t *testing.T) {
 		"The interface network object 'Internal (Network)' is protected from "+
 			"deletion.")
 
-	errs, err = conn.ErrListFatal()
+	errs, err = tx.ErrListFatal()
 	assert.NoError(t, err)
 	assert.True(t, len(errs) > 0)
 
-	errs, err = conn.ErrListNoAck()
+	errs, err = tx.ErrListNoAck()
 	assert.NoError(t, err)
 	assert.True(t, len(errs) > 0)
 }
@@ -5,6 +5,7 @@
 package confd
 
 import (
+	"context"
 	"encoding/json"
 )
 
@@ -54,60 +55,100 @@ type NodeTree map[string]interface{}
 
 // GetMetaObjects returns objects meta-information.
 func (c *Conn) GetMetaObjects() (ObjectMetaTree, error) {
+	return c.GetMetaObjectsContext(context.Background())
+}
+
+// GetMetaObjectsContext is like GetMetaObjects but honours the context
+func (c *Conn) GetMetaObjectsContext(ctx context.Context) (ObjectMetaTree, error) {
 	var ret ObjectMetaTree
-	err := c.Request("get_meta_objects", &ret)
+	err := c.RequestContext(ctx, "get_meta_objects", &ret)
 	return ret, err
 }
 
 // GetMetaNodes returns complete nodes information.
 func (c *Conn) GetMetaNodes() (map[string]interface{}, error) {
+	return c.GetMetaNodesContext(context.Background())
+}
+
+// GetMetaNodesContext is like GetMetaNodes but honours the context
+func (c *Conn) GetMetaNodesContext(ctx context.Context) (map[string]interface{}, error) {
 	var ret map[string]interface{}
-	err := c.Request("get_nodes", &ret)
+	err := c.RequestContext(ctx, "get_nodes", &ret)
 	return ret, err
 }
 
 // GetObjectClasses returns all available object classes
 func (c *Conn) GetObjectClasses() ([]string, error) {
+	return c.GetObjectClassesContext(context.Background())
+}
+
+// GetObjectClassesContext is like GetObjectClasses but honours the context
+func (c *Conn) GetObjectClassesContext(ctx context.Context) ([]string, error) {
 	var ret []string
-	err := c.Request("get_object_classes", &ret)
+	err := c.RequestContext(ctx, "get_object_classes", &ret)
 	return ret, err
 }
 
 // GetObjectTypes returns a list of types for the given class name
 func (c *Conn) GetObjectTypes(class string) ([]string, error) {
+	return c.GetObjectTypesContext(context.Background(), class)
+}
+
+// GetObjectTypesContext is like GetObjectTypes but honours the context
+func (c *Conn) GetObjectTypesContext(ctx context.Context, class string) ([]string, error) {
 	var ret []string
-	err := c.Request("get_object_types", &ret, class)
+	err := c.RequestContext(ctx, "get_object_types", &ret, class)
 	return ret, err
 }
 
 // GetAvailableNodes returns a list of nodes starting at path
 func (c *Conn) GetAvailableNodes(path ...NodeName) ([]string, error) {
+	return c.GetAvailableNodesContext(context.Background(), path...)
+}
+
+// GetAvailableNodesContext is like GetAvailableNodes but honours the context
+func (c *Conn) GetAvailableNodesContext(ctx context.Context, path ...NodeName) ([]string, error) {
 	var ret []string
 	args := pathToArgs(path)
-	err := c.Request("get_nodes", &ret, args...)
+	err := c.RequestContext(ctx, "get_nodes", &ret, args...)
 	return ret, err
 }
 
 // GetMeta returns a map containing all possible nodes and their values
 func (c *Conn) GetMeta() (NodeTree, error) {
+	return c.GetMetaContext(context.Background())
+}
+
+// GetMetaContext is like GetMeta but honours the context
+func (c *Conn) GetMetaContext(ctx context.Context) (NodeTree, error) {
 	var ret NodeTree
-	err := c.Request("get_meta", &ret)
+	err := c.RequestContext(ctx, "get_meta", &ret)
 	return ret, err
 }
 
 // GetScalars returns the available scalar values in the path
 func (c *Conn) GetScalars(path ...NodeName) ([]string, error) {
+	return c.GetScalarsContext(context.Background(), path...)
+}
+
+// GetScalarsContext is like GetScalars but honours the context
+func (c *Conn) GetScalarsContext(ctx context.Context, path ...NodeName) ([]string, error) {
 	var ret []string
 	args := pathToArgs(path)
-	err := c.Request("get_scalars", &ret, args...)
+	err := c.RequestContext(ctx, "get_scalars", &ret, args...)
 	return ret, err
 }
 
 // GetArrays returns the available array values in the path
 func (c *Conn) GetArrays(path ...NodeName) ([]string, error) {
+	return c.GetArraysContext(context.Background(), path...)
+}
+
+// GetArraysContext is like GetArrays but honours the context
+func (c *Conn) GetArraysContext(ctx context.Context, path ...NodeName) ([]string, error) {
 	var ret []string
 	args := pathToArgs(path)
-	err := c.Request("get_arrays", &ret, args...)
+	err := c.RequestContext(ctx, "get_arrays", &ret, args...)
 	return ret, err
 }
 
@@ -11,7 +11,7 @@ import (
 )
 
 func TestMetaObjects(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	ret, err := conn.GetMetaObjects()
@@ -24,7 +24,7 @@ func TestMetaObjects(t *testing.T) {
 }
 
 func TestClassesAndTypes(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	classes, err := conn.GetObjectClasses()
@@ -37,7 +37,7 @@ func TestClassesAndTypes(t *testing.T) {
 }
 
 func TestAvailableNodes(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	paths, err := conn.GetAvailableNodes()
@@ -50,7 +50,7 @@ func TestAvailableNodes(t *testing.T) {
 }
 
 func TestScalarsAndArrays(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	paths, err := conn.GetScalars("settings")
@@ -63,7 +63,7 @@ func TestScalarsAndArrays(t *testing.T) {
 }
 
 func TestMeta(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	data, err := conn.GetMeta()
@@ -0,0 +1,44 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+)
+
+// Call is a confd call passed through the middleware. Middleware can modify
+// method and params before the request is encoded and inspect or replace the
+// result after the response was decoded.
+type Call struct {
+	Method string
+	Params []interface{}
+	ID     uint64      // ID of the request, set once the request was send
+	Result interface{} // Result the response is decoded into, can be nil
+}
+
+// CallHandler executes the call, the returned error is the error of the call
+type CallHandler func(ctx context.Context, call *Call) error
+
+// Middleware wraps the handler of the next middleware or the connection.
+// A middleware can end the call without calling next, e.g. to return
+// cached results or to refuse the call:
+//
+//	func audit(next confd.CallHandler) confd.CallHandler {
+//		return func(ctx context.Context, call *confd.Call) error {
+//			err := next(ctx, call)
+//			log.Printf("%s(%v) = %v", call.Method, call.Params, err)
+//			return err
+//		}
+//	}
+type Middleware func(next CallHandler) CallHandler
+
+// chain returns the handler of the connection wrapped by the middleware
+func (c *Conn) chain() CallHandler {
+	handler := CallHandler(c.call)
+	for i := len(c.Middleware) - 1; i >= 0; i-- {
+		handler = c.Middleware[i](handler)
+	}
+	return handler
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+func TestMiddleware(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	srv.SetNodes(map[string]interface{}{"ntp": map[string]interface{}{"status": 1}})
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	var trace []string
+	var ids []uint64
+	tracer := func(name string) confd.Middleware {
+		return func(next confd.CallHandler) confd.CallHandler {
+			return func(ctx context.Context, call *confd.Call) error {
+				trace = append(trace, name+" "+call.Method)
+				err := next(ctx, call)
+				trace = append(trace, name+" done")
+				if name == "outer" {
+					ids = append(ids, call.ID)
+				}
+				return err
+			}
+		}
+	}
+	rewrite := func(next confd.CallHandler) confd.CallHandler {
+		return func(ctx context.Context, call *confd.Call) error {
+			if call.Method == "get_value" {
+				call.Method = "get"
+			}
+			return next(ctx, call)
+		}
+	}
+	conn.Middleware = []confd.Middleware{tracer("outer"), rewrite, tracer("inner")}
+
+	var status float64
+	assert.NoError(t, conn.Request("get_value", &status, "ntp", "status"))
+	assert.Equal(t, float64(1), status)
+	assert.Equal(t, []string{"outer get_value", "inner get", "inner done", "outer done"}, trace)
+	assert.Equal(t, []uint64{2}, ids, "new and get_SID are sent before")
+	assert.NotContains(t, srv.Calls(), "get_value")
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	denied := errors.New("denied by policy")
+	conn.Middleware = []confd.Middleware{
+		func(next confd.CallHandler) confd.CallHandler {
+			return func(ctx context.Context, call *confd.Call) error {
+				switch call.Method {
+				case "get_object_classes":
+					*call.Result.(*[]string) = []string{"cached"}
+					return nil
+				case "del_object":
+					return denied
+				}
+				return next(ctx, call)
+			}
+		},
+	}
+
+	classes, err := conn.GetObjectClasses()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cached"}, classes)
+	_, err = conn.DelObject("REF_NetHost")
+	assert.Equal(t, denied, err)
+	assert.Empty(t, srv.Calls(), "nothing was sent")
+}
@@ -4,6 +4,10 @@
 
 package confd
 
+import (
+	"context"
+)
+
 // NodeName the name of a node
 type NodeName string
 
@@ -18,15 +22,25 @@ type NodePath []NodeName
 
 // GetNode 5ead node data. Returned data type depends on called node.
 func (c *Conn) GetNode(path ...NodeName) (Node, error) {
+	return c.GetNodeContext(context.Background(), path...)
+}
+
+// GetNodeContext is like GetNode but honours the context
+func (c *Conn) GetNodeContext(ctx context.Context, path ...NodeName) (Node, error) {
 	var node Node
-	err := c.Request("get", &node, pathToArgs(path)...)
+	err := c.RequestContext(ctx, "get", &node, pathToArgs(path)...)
 	return node, err
 }
 
 // GetNodeValue 5ead node data. Returned data type depends on called node.
 func (c *Conn) GetNodeValue(path ...NodeName) (NodeValue, error) {
+	return c.GetNodeValueContext(context.Background(), path...)
+}
+
+// GetNodeValueContext is like GetNodeValue but honours the context
+func (c *Conn) GetNodeValueContext(ctx context.Context, path ...NodeName) (NodeValue, error) {
 	var node NodeValue
-	err := c.Request("get", &node, pathToArgs(path)...)
+	err := c.RequestContext(ctx, "get", &node, pathToArgs(path)...)
 	if err == ErrReturnCode {
 		err = nil // ignore 0 return value as failure
 	}
@@ -36,38 +50,63 @@ func (c *Conn) GetNodeValue(path ...NodeName) (NodeValue, error) {
 // GetAffectedNodes get a list of nodes that directly or indirectly use a list
 // of given objects.
 func (c *Conn) GetAffectedNodes(ref string) ([]NodePath, error) {
+	return c.GetAffectedNodesContext(context.Background(), ref)
+}
+
+// GetAffectedNodesContext is like GetAffectedNodes but honours the context
+func (c *Conn) GetAffectedNodesContext(ctx context.Context, ref string) ([]NodePath, error) {
 	var paths []NodePath
-	err := c.Request("get_affected_nodes", &paths, ref)
+	err := c.RequestContext(ctx, "get_affected_nodes", &paths, ref)
 	return paths, err
 }
 
 // ResetNode reset a node in the main tree to its default value.
 // Returns true if successful, false otherwise
 func (c *Conn) ResetNode(path ...NodeName) (bool, error) {
+	return c.ResetNodeContext(context.Background(), path...)
+}
+
+// ResetNodeContext is like ResetNode but honours the context
+func (c *Conn) ResetNodeContext(ctx context.Context, path ...NodeName) (bool, error) {
 	var ok Bool
-	err := c.Request("reset", &ok, pathToArgs(path)...)
+	err := c.RequestContext(ctx, "reset", &ok, pathToArgs(path)...)
 	return bool(ok), err
 }
 
 // SetNode set node data in the main tree.
 func (c *Conn) SetNode(node Node, path ...NodeName) (bool, error) {
-	return c.SetNodeValue(node, path...)
+	return c.SetNodeContext(context.Background(), node, path...)
+}
+
+// SetNodeContext is like SetNode but honours the context
+func (c *Conn) SetNodeContext(ctx context.Context, node Node, path ...NodeName) (bool, error) {
+	return c.SetNodeValueContext(ctx, node, path...)
 }
 
 // SetNodeValue set node data in the main tree.
 func (c *Conn) SetNodeValue(node NodeValue, path ...NodeName) (bool, error) {
+	return c.SetNodeValueContext(context.Background(), node, path...)
+}
+
+// SetNodeValueContext is like SetNodeValue but honours the context
+func (c *Conn) SetNodeValueContext(ctx context.Context, node NodeValue, path ...NodeName) (bool, error) {
 	var ok Bool
 	args := make([]interface{}, len(path)+1)
 	args[0] = node
 	copy(args[1:], pathToArgs(path))
-	err := c.Request("set", &ok, args...)
+	err := c.RequestContext(ctx, "set", &ok, args...)
 	return bool(ok), err
 }
 
 // GetNodes list of sub-nodes for a given node.
 func (c *Conn) GetNodes(path ...NodeName) ([]NodeName, error) {
+	return c.GetNodesContext(context.Background(), path...)
+}
+
+// GetNodesContext is like GetNodes but honours the context
+func (c *Conn) GetNodesContext(ctx context.Context, path ...NodeName) ([]NodeName, error) {
 	var names []NodeName
-	err := c.Request("get_nodes", &names, pathToArgs(path)...)
+	err := c.RequestContext(ctx, "get_nodes", &names, pathToArgs(path)...)
 	return names, err
 }
 
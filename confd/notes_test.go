@@ -11,7 +11,7 @@ import (
 )
 
 func TestNode(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	var validInterfaces = []string{"REF_NetNet100008", "REF_NetworkAny"}
@@ -4,6 +4,12 @@
 
 package confd
 
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
 // ObjectMeta confd object metadata
 type ObjectMeta struct {
 	Ref      string `json:"ref,omitempty"`
@@ -23,26 +29,52 @@ type AnyObject struct {
 
 // ChangeObject changes the object ref attributes
 func (c *Conn) ChangeObject(ref string, attributes interface{}) (err error) {
-	_, err = c.SimpleRequest("change_object", ref, attributes)
+	return c.ChangeObjectContext(context.Background(), ref, attributes)
+}
+
+// ChangeObjectContext is like ChangeObject but honours the context
+func (c *Conn) ChangeObjectContext(ctx context.Context, ref string, attributes interface{}) (err error) {
+	if c.Validator != nil {
+		err = c.validateChange(ctx, ref, attributes)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = c.SimpleRequestContext(ctx, "change_object", ref, attributes)
 	return err
 }
 
 // GetAnyObject returns an AnyObject for the given ref or nil
 func (c *Conn) GetAnyObject(ref string) (*AnyObject, error) {
+	return c.GetAnyObjectContext(context.Background(), ref)
+}
+
+// GetAnyObjectContext is like GetAnyObject but honours the context
+func (c *Conn) GetAnyObjectContext(ctx context.Context, ref string) (*AnyObject, error) {
 	response := new(AnyObject)
-	return response, c.GetObject(ref, response)
+	return response, c.GetObjectContext(ctx, ref, response)
 }
 
 // GetObject returns object for the given ref or nil
 func (c *Conn) GetObject(ref string, object interface{}) error {
-	err := c.Request("get_object", object, ref)
+	return c.GetObjectContext(context.Background(), ref, object)
+}
+
+// GetObjectContext is like GetObject but honours the context
+func (c *Conn) GetObjectContext(ctx context.Context, ref string, object interface{}) error {
+	err := c.RequestContext(ctx, "get_object", object, ref)
 	return err
 }
 
 // DelObject deletes an object by ref
 func (c *Conn) DelObject(ref string) (bool, error) {
+	return c.DelObjectContext(context.Background(), ref)
+}
+
+// DelObjectContext is like DelObject but honours the context
+func (c *Conn) DelObjectContext(ctx context.Context, ref string) (bool, error) {
 	var ok Bool
-	err := c.Request("del_object", &ok, ref)
+	err := c.RequestContext(ctx, "del_object", &ok, ref)
 	return bool(ok), err
 }
 
@@ -51,40 +83,70 @@ func (c *Conn) DelObject(ref string) (bool, error) {
 // Note - Since all objects carry references to themselves, the list submitted
 // in the argument will also be included in the returned list.
 func (c *Conn) GetAffectedObjects(refs []string) ([]string, error) {
+	return c.GetAffectedObjectsContext(context.Background(), refs)
+}
+
+// GetAffectedObjectsContext is like GetAffectedObjects but honours the context
+func (c *Conn) GetAffectedObjectsContext(ctx context.Context, refs []string) ([]string, error) {
 	var affected []string
-	err := c.Request("get_affected_objects", &affected, refs)
+	err := c.RequestContext(ctx, "get_affected_objects", &affected, refs)
 	return affected, err
 }
 
 // GetAllObjects returns all confd stored conf objects
 func (c *Conn) GetAllObjects() ([]AnyObject, error) {
-	return c.FilterObjects().Get()
+	return c.GetAllObjectsContext(context.Background())
+}
+
+// GetAllObjectsContext is like GetAllObjects but honours the context
+func (c *Conn) GetAllObjectsContext(ctx context.Context) ([]AnyObject, error) {
+	return c.FilterObjects().GetContext(ctx)
 }
 
 // LockObject sets the lockstate of the object to locked
 func (c *Conn) LockObject(ref string) error {
-	_, err := c.SimpleRequest("lock_object", ref, "user")
+	return c.LockObjectContext(context.Background(), ref)
+}
+
+// LockObjectContext is like LockObject but honours the context
+func (c *Conn) LockObjectContext(ctx context.Context, ref string) error {
+	_, err := c.SimpleRequestContext(ctx, "lock_object", ref, "user")
 	return err
 }
 
 // UnlockObject sets the lockstate of the object to unlocked
 func (c *Conn) UnlockObject(ref string) error {
-	_, _ = c.SimpleRequest("lock_override", 1)
-	_, err := c.SimpleRequest("lock_object", ref, BoolValue(false))
-	_, _ = c.SimpleRequest("lock_override", 0)
+	return c.UnlockObjectContext(context.Background(), ref)
+}
+
+// UnlockObjectContext is like UnlockObject but honours the context
+func (c *Conn) UnlockObjectContext(ctx context.Context, ref string) error {
+	_, _ = c.SimpleRequestContext(ctx, "lock_override", 1)
+	_, err := c.SimpleRequestContext(ctx, "lock_object", ref, BoolValue(false))
+	_, _ = c.SimpleRequestContext(ctx, "lock_override", 0)
 	return err
 }
 
 // MoveObject change the reference string of an existing object,
 // keeping all places where it is used consistent.
 func (c *Conn) MoveObject(oldRef string, newRef string) error {
-	_, err := c.SimpleRequest("move_object", oldRef, newRef)
+	return c.MoveObjectContext(context.Background(), oldRef, newRef)
+}
+
+// MoveObjectContext is like MoveObject but honours the context
+func (c *Conn) MoveObjectContext(ctx context.Context, oldRef string, newRef string) error {
+	_, err := c.SimpleRequestContext(ctx, "move_object", oldRef, newRef)
 	return err
 }
 
 // ResetObject reset an object to its state in the default storage.
 func (c *Conn) ResetObject(ref string) error {
-	_, err := c.SimpleRequest("reset_object", ref)
+	return c.ResetObjectContext(context.Background(), ref)
+}
+
+// ResetObjectContext is like ResetObject but honours the context
+func (c *Conn) ResetObjectContext(ctx context.Context, ref string) error {
+	_, err := c.SimpleRequestContext(ctx, "reset_object", ref)
 	return err
 }
 
@@ -93,9 +155,59 @@ func (c *Conn) ResetObject(ref string) error {
 // " (2)" to the name and increment the number until a free name is found.
 // Returns the ref of the created object
 func (c *Conn) SetObject(obj interface{}, fuzzyName bool) (string, error) {
-	ref, err := c.SimpleRequest("set_object", obj)
+	return c.SetObjectContext(context.Background(), obj, fuzzyName)
+}
+
+// SetObjectContext is like SetObject but honours the context
+func (c *Conn) SetObjectContext(ctx context.Context, obj interface{}, fuzzyName bool) (string, error) {
+	if c.Validator != nil {
+		err := c.Validator.ValidateContext(ctx, obj)
+		if err != nil {
+			return "", err
+		}
+	}
+	ref, err := c.SimpleRequestContext(ctx, "set_object", obj)
 	if err != nil {
 		return "", err
 	}
 	return (*ref.(*interface{})).(string), err
 }
+
+// ResolveRef returns class and type of the referenced object
+func (c *Conn) ResolveRef(ref string) (class, typ string, err error) {
+	return c.ResolveRefContext(context.Background(), ref)
+}
+
+// ResolveRefContext is like ResolveRef but honours the context
+func (c *Conn) ResolveRefContext(ctx context.Context, ref string) (class, typ string, err error) {
+	var obj ObjectMeta
+	err = c.GetObjectContext(ctx, ref, &obj)
+	if err == nil && obj.Class == "" {
+		err = fmt.Errorf("Object %s doesn't exist", ref)
+	}
+	return obj.Class, obj.Type, err
+}
+
+// validateChange validates the object ref with the changed attributes
+func (c *Conn) validateChange(ctx context.Context, ref string, attributes interface{}) error {
+	obj, err := c.GetAnyObjectContext(ctx, ref)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(attributes)
+	if err != nil {
+		return err
+	}
+	var changes map[string]interface{}
+	err = json.Unmarshal(data, &changes)
+	if err != nil {
+		return err
+	}
+	if obj.Data == nil {
+		obj.Data = make(map[string]interface{})
+	}
+	for name, value := range changes {
+		obj.Data[name] = value
+	}
+	return c.Validator.ValidateContext(ctx, obj)
+}
@@ -11,7 +11,7 @@ import (
 )
 
 func TestGetAnyObject(t *testing.T) {
-	conn := connHelper()
+	conn := connHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	obj, err := conn.GetAnyObject("REF_AnonymousUser")
@@ -21,7 +21,7 @@ func TestGetAnyObject(t *testing.T) {
 }
 
 func TestAffectedObjects(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	refs, err := conn.GetAffectedObjects([]string{"REF_DefaultInternalNetwork"})
@@ -32,7 +32,7 @@ func TestAffectedObjects(t *testing.T) {
 }
 
 func TestFilterObjects(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	objects, err := conn.FilterObjects().
@@ -55,7 +55,7 @@ func TestFilterObjects(t *testing.T) {
 }
 
 func TestAllObjects(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	objects, err := conn.GetAllObjects()
@@ -64,10 +64,12 @@ func TestAllObjects(t *testing.T) {
 }
 
 func TestSetObject(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 	tx, err := conn.BeginWriteTransaction()
-	assert.NoError(t, err)
+	if !assert.NoError(t, err) {
+		return
+	}
 	defer func() { _ = tx.Rollback() }()
 
 	var host = AnyObject{
@@ -82,24 +84,24 @@ func TestSetObject(t *testing.T) {
 		},
 	}
 
-	ref, err := conn.SetObject(&host, true)
+	ref, err := tx.SetObject(&host, true)
 	assert.NoError(t, err)
 	assert.Contains(t, ref, "REF_")
 
-	obj, err := conn.GetAnyObject(ref)
+	obj, err := tx.GetAnyObject(ref)
 	assert.NoError(t, err)
 	assert.Equal(t, obj.Data["address"], "8.8.8.8")
 
-	err = conn.MoveObject(ref, "REF_GOOGLEDNS")
+	err = tx.MoveObject(ref, "REF_GOOGLEDNS")
 	assert.NoError(t, err)
 
-	obj, err = conn.GetAnyObject("REF_GOOGLEDNS")
+	obj, err = tx.GetAnyObject("REF_GOOGLEDNS")
 	assert.NoError(t, err)
 	assert.Equal(t, obj.Data["address"], "8.8.8.8")
 
-	err = conn.LockObject("REF_GOOGLEDNS")
+	err = tx.LockObject("REF_GOOGLEDNS")
 	assert.NoError(t, err)
 
-	err = conn.UnlockObject("REF_GOOGLEDNS")
+	err = tx.UnlockObject("REF_GOOGLEDNS")
 	assert.NoError(t, err)
 }
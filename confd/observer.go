@@ -0,0 +1,61 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"time"
+)
+
+// Transaction kinds and outcomes passed to the Observer
+const (
+	TxRead     = "read"
+	TxWrite    = "write"
+	TxCommit   = "commit"
+	TxRollback = "rollback"
+)
+
+// Observer is notified about the internals of a connection, e.g. to collect
+// metrics (see package confdprom). Implementations have to be safe for
+// concurrent use and must not block.
+type Observer interface {
+	// ObserveCall is called after every call with the final error
+	ObserveCall(method string, duration time.Duration, err error)
+	// ObserveReturnError is called if the confd returned an empty response
+	// (ErrEmptyResponse) or the return code 0 (ErrReturnCode)
+	ObserveReturnError(method string, err error)
+	// ObserveRoundTrip is called after every round trip of the transport
+	ObserveRoundTrip(duration time.Duration, err error)
+	// ObserveQueueWait is called with the time a message waited for the
+	// worker of the connection
+	ObserveQueueWait(duration time.Duration)
+	// ObserveConnect is called after the transport connected or failed to
+	// connect, this includes reconnects
+	ObserveConnect(err error)
+	// ObserveLockWait is called with the time waited for the transaction
+	// lock of the connection
+	ObserveLockWait(kind string, duration time.Duration)
+	// ObserveTransaction is called once a transaction is committed or
+	// rolled back
+	ObserveTransaction(kind, outcome string, duration time.Duration, err error)
+}
+
+// nopObserver is used if the connection has no observer
+type nopObserver struct{}
+
+func (nopObserver) ObserveCall(string, time.Duration, error)                {}
+func (nopObserver) ObserveReturnError(string, error)                        {}
+func (nopObserver) ObserveRoundTrip(time.Duration, error)                   {}
+func (nopObserver) ObserveQueueWait(time.Duration)                          {}
+func (nopObserver) ObserveConnect(error)                                    {}
+func (nopObserver) ObserveLockWait(string, time.Duration)                   {}
+func (nopObserver) ObserveTransaction(string, string, time.Duration, error) {}
+
+// observer returns the observer of the connection
+func (c *Conn) observer() Observer {
+	if c.Observer == nil {
+		return nopObserver{}
+	}
+	return c.Observer
+}
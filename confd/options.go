@@ -54,7 +54,17 @@ func newOptions(url *url.URL) *Options {
 	username := anonymousUser
 	password := anonymousPassword
 
-	if url.User != nil {
+	if url.Scheme == sshScheme {
+		// the user info is used for the ssh login, the confd credentials are
+		// passed using query parameters
+		query := url.Query()
+		if query.Get("user") != "" {
+			username = query.Get("user")
+		}
+		if query.Get("password") != "" {
+			password = query.Get("password")
+		}
+	} else if url.User != nil {
 		if url.User.Username() != "" {
 			username = url.User.Username()
 		}
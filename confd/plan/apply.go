@@ -0,0 +1,94 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/snapshot"
+)
+
+// ApplyError is returned if a change couldn't be applied, the transaction
+// was rolled back in this case
+type ApplyError struct {
+	Change Change
+	Err    error // usually a confd.ErrList
+}
+
+func (e *ApplyError) Error() string {
+	return fmt.Sprintf("Failed to %s: %v", e.Change, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
+
+// Apply applies all changes in a single write transaction (see
+// confd.Conn.WithWriteTransaction). If any change fails or confd reports
+// errors, the transaction is rolled back and a *confd.ErrTransaction wrapping
+// an *ApplyError is returned.
+func (p *Plan) Apply(ctx context.Context, conn *confd.Conn) error {
+	if p.Empty() {
+		return nil
+	}
+	return conn.WithWriteTransaction(ctx, func(tx *confd.Tx) error {
+		for _, c := range p.Changes {
+			err := apply(ctx, tx.Conn, c)
+			if err == nil || errors.Is(err, confd.ErrReturnCode) {
+				errs, lerr := tx.ErrListContext(ctx)
+				if lerr != nil {
+					err = lerr
+				} else if len(errs) > 0 {
+					err = errs
+				}
+			}
+			if err != nil {
+				return &ApplyError{Change: c, Err: err}
+			}
+		}
+		return nil
+	})
+}
+
+// DryRun applies all changes in a write transaction that is always rolled
+// back (see snapshot.DryRun). All changes are applied even if some fail, so
+// that the report contains all errors. The failed changes are returned as
+// *ApplyError in the error of the report.
+func (p *Plan) DryRun(ctx context.Context, conn *confd.Conn) (*snapshot.DryRunReport, error) {
+	return snapshot.DryRun(ctx, conn, func(tx *confd.Tx) error {
+		var errs []error
+		for _, c := range p.Changes {
+			if err := apply(ctx, tx.Conn, c); err != nil {
+				errs = append(errs, &ApplyError{Change: c, Err: err})
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// apply sends the change to confd
+func apply(ctx context.Context, conn *confd.Conn, c Change) error {
+	switch c.Action {
+	case Create:
+		_, err := conn.SetObjectContext(ctx, confd.AnyObject{
+			ObjectMeta: confd.ObjectMeta{Class: c.Class, Type: c.Type},
+			Data:       c.After,
+		}, false)
+		return err
+	case Update:
+		return conn.ChangeObjectContext(ctx, c.Ref, c.After)
+	case Delete:
+		ok, err := conn.DelObjectContext(ctx, c.Ref)
+		if err == nil && !ok {
+			return confd.ErrReturnCode // the errors are reported by confd
+		}
+		return err
+	}
+	return fmt.Errorf("Unknown action %q", c.Action)
+}
@@ -0,0 +1,317 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package plan implements a declarative plan and apply workflow for confd
+// objects. A Document describes the desired state of objects identified by
+// class, type and name. Compute compares the document with the live
+// objects and returns a Plan of creates, updates and deletes, that can be
+// reviewed and applied in a single write transaction:
+//
+//	doc, err := plan.Load("hosts.yaml")
+//	...
+//	p, err := plan.Compute(ctx, conn, doc)
+//	...
+//	fmt.Print(p)
+//	err = p.Apply(ctx, conn)
+package plan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/internal/textutil"
+	"gopkg.in/yaml.v3"
+)
+
+// States of a resource
+const (
+	Present = "present" // object is created or updated (default)
+	Absent  = "absent"  // object is deleted if it exists
+)
+
+// Resource is the desired state of an object. The object is identified by
+// class, type and name. Only the given attributes are managed, all others
+// keep the live or default values.
+type Resource struct {
+	Class string                 `json:"class"`
+	Type  string                 `json:"type"`
+	Name  string                 `json:"name"`
+	State string                 `json:"state,omitempty"`
+	Data  map[string]interface{} `json:"data,omitempty"`
+}
+
+// Document describes the desired state of objects
+type Document struct {
+	Objects []Resource `json:"objects"`
+	// Prune lists class/type pairs (e.g. network/host), objects of the
+	// listed types that are not part of the document are deleted. Objects
+	// that are protected from deletion (nodel) are never pruned.
+	Prune []string `json:"prune,omitempty"`
+}
+
+// Parse parses a YAML or JSON document
+func Parse(data []byte) (*Document, error) {
+	// decode yaml (a superset of json) and normalize using json, so that
+	// values have the same types as objects returned by confd
+	var raw interface{}
+	err := yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+	normalized, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	doc := new(Document)
+	err = json.Unmarshal(normalized, doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc, doc.validate()
+}
+
+// Load reads and parses the document from the file
+func Load(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// validate checks that the resources are complete and unique
+func (d *Document) validate() error {
+	seen := make(map[string]bool)
+	for i, res := range d.Objects {
+		if res.Class == "" || res.Type == "" || res.Name == "" {
+			return fmt.Errorf("Object %d requires class, type and name", i)
+		}
+		if res.State != "" && res.State != Present && res.State != Absent {
+			return fmt.Errorf("Object %s has unknown state %q", res, res.State)
+		}
+		if seen[res.String()] {
+			return fmt.Errorf("Object %s is defined multiple times", res)
+		}
+		seen[res.String()] = true
+	}
+	for _, ct := range d.Prune {
+		if _, _, ok := strings.Cut(ct, "/"); !ok {
+			return fmt.Errorf("Prune %q is not of the form class/type", ct)
+		}
+	}
+	return nil
+}
+
+func (r Resource) String() string {
+	return fmt.Sprintf("%s/%s %q", r.Class, r.Type, r.Name)
+}
+
+// Action of a change
+type Action string
+
+// Actions of a plan
+const (
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+)
+
+// Change is a single planned change of an object
+type Change struct {
+	Action Action `json:"action"`
+	Ref    string `json:"ref,omitempty"` // empty for creates
+	Class  string `json:"class"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	// Before contains the live values of the changed attributes (nil for
+	// creates) and After the desired values (nil for deletes)
+	Before map[string]interface{} `json:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty"`
+}
+
+func (c Change) String() string {
+	str := fmt.Sprintf("%s %s/%s %q", c.Action, c.Class, c.Type, c.Name)
+	if c.Ref != "" {
+		str += " (" + c.Ref + ")"
+	}
+	return str
+}
+
+// Plan contains the changes that are required to reach the desired state.
+// Creates are applied first, then updates and deletes, so that created
+// objects can be referenced and deleted objects are unreferenced first.
+type Plan struct {
+	Changes []Change `json:"changes"`
+}
+
+// Empty returns true if no changes are required
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// Compute compares the document with the live objects and returns the
+// required changes
+func Compute(ctx context.Context, conn *confd.Conn, doc *Document) (*Plan, error) {
+	live, err := liveObjects(ctx, conn, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var creates, updates, deletes []Change
+	desired := make(map[string]bool)
+	for _, res := range doc.Objects {
+		key := res.Class + "/" + res.Type
+		desired[key+"/"+res.Name] = true
+		obj, found := live[key][res.Name]
+
+		switch {
+		case res.State == Absent && found:
+			deletes = append(deletes, change(Delete, res, obj))
+		case res.State == Absent:
+			// nothing to do
+		case !found:
+			c := change(Create, res, obj)
+			c.After = map[string]interface{}{"name": res.Name}
+			for name, value := range res.Data {
+				c.After[name] = value
+			}
+			creates = append(creates, c)
+		default:
+			c := change(Update, res, obj)
+			for name, value := range res.Data {
+				if !equal(obj.Data[name], value) {
+					if c.After == nil {
+						c.Before = make(map[string]interface{})
+						c.After = make(map[string]interface{})
+					}
+					c.Before[name] = obj.Data[name]
+					c.After[name] = value
+				}
+			}
+			if c.After != nil {
+				updates = append(updates, c)
+			}
+		}
+	}
+
+	for _, key := range doc.Prune {
+		var pruned []Change
+		for name, obj := range live[key] {
+			if desired[key+"/"+name] || obj.Nodel != "" {
+				continue
+			}
+			pruned = append(pruned, change(Delete, Resource{
+				Class: obj.Class, Type: obj.Type, Name: name}, obj))
+		}
+		sort.Slice(pruned, func(i, j int) bool {
+			return pruned[i].Ref < pruned[j].Ref
+		})
+		deletes = append(deletes, pruned...)
+	}
+
+	changes := append(append(creates, updates...), deletes...)
+	return &Plan{Changes: changes}, nil
+}
+
+// liveObjects returns the live objects of all classes and types that are
+// used in the document indexed by class/type and name
+func liveObjects(ctx context.Context, conn *confd.Conn, doc *Document) (map[string]map[string]confd.AnyObject, error) {
+	live := make(map[string]map[string]confd.AnyObject)
+	keys := append([]string{}, doc.Prune...)
+	for _, res := range doc.Objects {
+		keys = append(keys, res.Class+"/"+res.Type)
+	}
+	for _, key := range keys {
+		if _, ok := live[key]; ok {
+			continue
+		}
+		class, typ, _ := strings.Cut(key, "/")
+		objects, err := conn.FilterObjects().ClassName(class).TypeName(typ).
+			GetContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		live[key] = make(map[string]confd.AnyObject)
+		for _, obj := range objects {
+			if name, ok := obj.Data["name"].(string); ok {
+				live[key][name] = obj
+			}
+		}
+	}
+	return live, nil
+}
+
+// change creates a change for the resource, obj is the live object if any
+func change(action Action, res Resource, obj confd.AnyObject) Change {
+	return Change{
+		Action: action,
+		Ref:    obj.Ref,
+		Class:  res.Class,
+		Type:   res.Type,
+		Name:   res.Name,
+	}
+}
+
+// equal compares the values after normalizing them using json
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func normalize(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	if json.Unmarshal(data, &normalized) != nil {
+		return value
+	}
+	return normalized
+}
+
+// String returns the plan in a human readable form
+func (p *Plan) String() string {
+	var b strings.Builder
+	_, _ = p.WriteTo(&b) // strings.Builder never fails
+	return b.String()
+}
+
+// WriteTo writes the plan in a human readable form
+func (p *Plan) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	counts := make(map[Action]int)
+	for _, c := range p.Changes {
+		counts[c.Action]++
+		switch c.Action {
+		case Create:
+			fmt.Fprintf(&b, "+ %s/%s %q\n", c.Class, c.Type, c.Name)
+			for _, name := range textutil.SortedKeys(c.After) {
+				fmt.Fprintf(&b, "    %s: %s\n", name, textutil.Format(c.After[name]))
+			}
+		case Update:
+			fmt.Fprintf(&b, "~ %s/%s %q (%s)\n", c.Class, c.Type, c.Name, c.Ref)
+			for _, name := range textutil.SortedKeys(c.After) {
+				fmt.Fprintf(&b, "    %s: %s => %s\n", name, textutil.Format(c.Before[name]),
+					textutil.Format(c.After[name]))
+			}
+		case Delete:
+			fmt.Fprintf(&b, "- %s/%s %q (%s)\n", c.Class, c.Type, c.Name, c.Ref)
+		}
+	}
+	if p.Empty() {
+		fmt.Fprintf(&b, "No changes.\n")
+	} else {
+		fmt.Fprintf(&b, "Plan: %d to create, %d to update, %d to delete.\n",
+			counts[Create], counts[Update], counts[Delete])
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
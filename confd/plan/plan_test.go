@@ -0,0 +1,269 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plan
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+const document = `
+objects:
+  - class: network
+    type: host
+    name: Google DNS
+    data:
+      address: 8.8.8.8
+      comment: public
+  - class: network
+    type: host
+    name: Cloudflare DNS
+    data:
+      address: 1.1.1.1
+      resolved: 0
+  - class: network
+    type: host
+    name: Old
+    state: absent
+  - class: network
+    type: host
+    name: Missing
+    state: absent
+prune:
+  - network/host
+`
+
+func serverHelper() *confdtest.Server {
+	srv := confdtest.NewServer()
+	srv.AddObjects(
+		host("REF_NetHostCloudflare", "Cloudflare DNS", "1.0.0.1"),
+		host("REF_NetHostOld", "Old", "10.0.0.1"),
+		host("REF_NetHostStale", "Stale", "10.0.0.2"),
+		host("REF_NetHostProtected", "Protected", "10.0.0.3"),
+	)
+	protected, _ := srv.Object("REF_NetHostProtected")
+	protected.Nodel = "system"
+	srv.AddObjects(protected)
+	return srv
+}
+
+func host(ref, name, address string) confd.AnyObject {
+	return confd.AnyObject{
+		ObjectMeta: confd.ObjectMeta{Ref: ref, Class: "network", Type: "host"},
+		Data: map[string]interface{}{
+			"name": name, "address": address, "comment": "", "resolved": 0,
+		},
+	}
+}
+
+func TestParse(t *testing.T) {
+	doc, err := Parse([]byte(document))
+	assert.NoError(t, err)
+	assert.Len(t, doc.Objects, 4)
+	assert.Equal(t, "8.8.8.8", doc.Objects[0].Data["address"])
+	assert.Equal(t, float64(0), doc.Objects[1].Data["resolved"])
+	assert.Equal(t, Absent, doc.Objects[2].State)
+	assert.Equal(t, []string{"network/host"}, doc.Prune)
+
+	doc, err = Parse([]byte(`{"objects": [{"class": "network", "type": "host", "name": "A"}]}`))
+	assert.NoError(t, err)
+	assert.Len(t, doc.Objects, 1)
+
+	_, err = Parse([]byte(`{"objects": [{"class": "network", "name": "A"}]}`))
+	assert.Error(t, err)
+	_, err = Parse([]byte(`{"objects": [{"class": "network", "type": "host", "name": "A"},
+		{"class": "network", "type": "host", "name": "A"}]}`))
+	assert.Error(t, err)
+	_, err = Parse([]byte(`{"prune": ["network"]}`))
+	assert.Error(t, err)
+}
+
+func TestCompute(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	doc, err := Parse([]byte(document))
+	assert.NoError(t, err)
+	p, err := Compute(context.Background(), conn, doc)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []Change{
+		{Action: Create, Class: "network", Type: "host", Name: "Google DNS",
+			After: map[string]interface{}{
+				"name": "Google DNS", "address": "8.8.8.8", "comment": "public"}},
+		{Action: Update, Ref: "REF_NetHostCloudflare", Class: "network",
+			Type: "host", Name: "Cloudflare DNS",
+			Before: map[string]interface{}{"address": "1.0.0.1"},
+			After:  map[string]interface{}{"address": "1.1.1.1"}},
+		{Action: Delete, Ref: "REF_NetHostOld", Class: "network", Type: "host",
+			Name: "Old"},
+		{Action: Delete, Ref: "REF_NetHostStale", Class: "network",
+			Type: "host", Name: "Stale"},
+	}, p.Changes)
+
+	assert.Equal(t, `+ network/host "Google DNS"
+    address: "8.8.8.8"
+    comment: "public"
+    name: "Google DNS"
+~ network/host "Cloudflare DNS" (REF_NetHostCloudflare)
+    address: "1.0.0.1" => "1.1.1.1"
+- network/host "Old" (REF_NetHostOld)
+- network/host "Stale" (REF_NetHostStale)
+Plan: 1 to create, 1 to update, 2 to delete.
+`, p.String())
+}
+
+func TestApply(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	ctx := context.Background()
+
+	doc, err := Parse([]byte(document))
+	assert.NoError(t, err)
+	p, err := Compute(ctx, conn, doc)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Apply(ctx, conn))
+
+	names := make(map[string]string)
+	for _, obj := range srv.Objects() {
+		names[obj.Data["name"].(string)] = obj.Data["address"].(string)
+	}
+	assert.Equal(t, map[string]string{
+		"Google DNS":     "8.8.8.8",
+		"Cloudflare DNS": "1.1.1.1",
+		"Protected":      "10.0.0.3",
+	}, names)
+
+	// second run has nothing to do
+	p, err = Compute(ctx, conn, doc)
+	assert.NoError(t, err)
+	assert.True(t, p.Empty())
+	assert.Equal(t, "No changes.\n", p.String())
+	assert.NoError(t, p.Apply(ctx, conn))
+}
+
+func TestApplyRollback(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	ctx := context.Background()
+
+	p := &Plan{Changes: []Change{
+		{Action: Create, Class: "network", Type: "host", Name: "New",
+			After: map[string]interface{}{"name": "New", "address": "10.0.0.9"}},
+		{Action: Delete, Ref: "REF_NetHostProtected", Class: "network",
+			Type: "host", Name: "Protected"},
+	}}
+	err := p.Apply(ctx, conn)
+	var applyErr *ApplyError
+	assert.True(t, errors.As(err, &applyErr))
+	assert.Equal(t, Delete, applyErr.Change.Action)
+	var errs confd.ErrList
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, "OBJECT_DELETE_LOCKED", errs[0].MessageType)
+
+	assert.Len(t, srv.Objects(), 4)
+	_, ok := srv.Object("REF_NetHostProtected")
+	assert.True(t, ok)
+}
+
+func TestDryRun(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	ctx := context.Background()
+
+	p := &Plan{Changes: []Change{
+		{Action: Delete, Ref: "REF_NetHostProtected", Class: "network",
+			Type: "host", Name: "Protected"},
+		{Action: Create, Class: "network", Type: "host", Name: "New",
+			After: map[string]interface{}{"name": "New", "address": "10.0.0.9"}},
+	}}
+	report, err := p.DryRun(ctx, conn)
+	assert.NoError(t, err)
+	assert.False(t, report.Accepted())
+	var applyErr *ApplyError
+	assert.True(t, errors.As(report.Err, &applyErr))
+	assert.Equal(t, Delete, applyErr.Change.Action)
+	if assert.Len(t, report.Errors, 1) {
+		assert.Equal(t, "OBJECT_DELETE_LOCKED", report.Errors[0].MessageType)
+	}
+	if assert.Len(t, report.Delta.Added, 1, "the following changes were applied") {
+		assert.Equal(t, "New", report.Delta.Added[0].Data["name"])
+	}
+
+	assert.Len(t, srv.Objects(), 4)
+	assert.NotContains(t, srv.Calls(), "commit")
+}
+
+func TestApplyCanceled(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	ctx, cancel := context.WithCancel(context.Background())
+	conn.Middleware = []confd.Middleware{
+		func(next confd.CallHandler) confd.CallHandler {
+			return func(ctx context.Context, call *confd.Call) error {
+				if call.Method == "set_object" {
+					cancel() // e.g. interrupted by the user
+				}
+				return next(ctx, call)
+			}
+		},
+	}
+
+	p := &Plan{Changes: []Change{
+		{Action: Create, Class: "network", Type: "host", Name: "New",
+			After: map[string]interface{}{"name": "New", "address": "10.0.0.9"}},
+	}}
+	err := p.Apply(ctx, conn)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Contains(t, srv.Calls(), "unlock", "rollback ignores the canceled context")
+
+	other := srv.Conn()
+	defer func() { _ = other.Close() }()
+	tx, err := other.BeginWriteTransaction()
+	assert.NoError(t, err, "the lock was released")
+	assert.NoError(t, tx.Rollback())
+}
+
+func TestApplyPanic(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	conn.Middleware = []confd.Middleware{
+		func(next confd.CallHandler) confd.CallHandler {
+			return func(ctx context.Context, call *confd.Call) error {
+				if call.Method == "set_object" {
+					panic("broken change")
+				}
+				return next(ctx, call)
+			}
+		},
+	}
+
+	p := &Plan{Changes: []Change{
+		{Action: Create, Class: "network", Type: "host", Name: "New",
+			After: map[string]interface{}{"name": "New", "address": "10.0.0.9"}},
+	}}
+	assert.PanicsWithValue(t, "broken change", func() {
+		_ = p.Apply(context.Background(), conn)
+	})
+	assert.Contains(t, srv.Calls(), "unlock", "the panic rolled the transaction back")
+	assert.Len(t, srv.Objects(), 4)
+}
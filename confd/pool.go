@@ -0,0 +1,221 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultMaxOpen is the number of sessions a pool opens if not specified.
+// Every session occupies a confd worker, therefore the number is small.
+const DefaultMaxOpen = 4
+
+// DefaultHealthCheckInterval is the time a session can be idle before it is
+// checked on reuse
+const DefaultHealthCheckInterval = time.Minute
+
+// ErrPoolClosed is returned if a connection is requested from a closed pool
+var ErrPoolClosed = errors.New("Pool is closed")
+
+// Pool manages multiple independent confd sessions, every connection of the
+// pool has its own session (SID) and worker. Connections are handed out to
+// one user at a time, concurrent users therefore don't queue behind each
+// other as long as the pool has free sessions.
+type Pool struct {
+	// NewConn creates the connections of the pool, can be replaced to
+	// configure the connections (e.g. Logger or ReadOnly)
+	NewConn func() (*Conn, error)
+	// HealthCheckInterval is the time a session can be idle before it is
+	// checked when it is handed out again, sessions failing the check are
+	// replaced
+	HealthCheckInterval time.Duration
+	maxOpen             int
+	sem                 chan struct{} // limits the number of open sessions
+	mu                  sync.Mutex
+	idle                []idleConn
+	open                int
+	closed              bool
+}
+
+// idleConn is a connection waiting in the pool
+type idleConn struct {
+	conn  *Conn
+	since time.Time
+}
+
+// PoolStats describes the state of the pool
+type PoolStats struct {
+	MaxOpen int // maximum number of sessions
+	Open    int // number of open sessions, idle and in use
+	Idle    int // number of idle sessions
+}
+
+// NewPool creates a pool of at most maxOpen sessions to the confd at the URL
+// (see NewConn), if maxOpen is 0 the DefaultMaxOpen is used. Sessions are
+// opened on demand.
+func NewPool(URL string, maxOpen int) (*Pool, error) {
+	if _, err := url.Parse(URL); err != nil {
+		return nil, err
+	}
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpen
+	}
+	return &Pool{
+		NewConn:             func() (*Conn, error) { return NewConn(URL) },
+		HealthCheckInterval: DefaultHealthCheckInterval,
+		maxOpen:             maxOpen,
+		sem:                 make(chan struct{}, maxOpen),
+	}, nil
+}
+
+// Get returns a connection of the pool, if all sessions are in use it waits
+// until one is returned or the context is done. The connection must be
+// returned using Put.
+func (p *Pool) Get(ctx context.Context) (*Conn, error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	conn, err := p.get(ctx)
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+	return conn, nil
+}
+
+// get returns a healthy idle connection or opens a new one, the caller
+// holds a slot of the semaphore
+func (p *Pool) get(ctx context.Context) (*Conn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.open++
+			p.mu.Unlock()
+			conn, err := p.NewConn()
+			if err != nil {
+				p.discard(nil)
+				return nil, err
+			}
+			return conn, nil
+		}
+		ic := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if time.Since(ic.since) < p.HealthCheckInterval {
+			return ic.conn, nil
+		}
+		_, err := ic.conn.SimpleRequestContext(ctx, "get_SID")
+		if err == nil {
+			return ic.conn, nil
+		}
+		if ctx.Err() != nil {
+			p.put(ic.conn)
+			return nil, ctx.Err()
+		}
+		ic.conn.logf("Session failed health check: %v", err)
+		p.discard(ic.conn)
+	}
+}
+
+// Put returns the connection to the pool. Connections of a closed pool are
+// closed. A transaction left open on the connection is rolled back, if that
+// fails the connection is closed instead of being reused.
+func (p *Pool) Put(conn *Conn) {
+	if tx := conn.gate.current(); tx != nil {
+		conn.logf("!! Rollback transaction left open on the returned connection")
+		if err := tx.Rollback(); err != nil {
+			conn.logf("Failed to rollback transaction: %v", err)
+			p.discard(conn)
+			<-p.sem
+			return
+		}
+	}
+	p.put(conn)
+	<-p.sem
+}
+
+// put adds the connection to the idle connections or closes it if the pool
+// is closed
+func (p *Pool) put(conn *Conn) {
+	p.mu.Lock()
+	if !p.closed {
+		p.idle = append(p.idle, idleConn{conn: conn, since: time.Now()})
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	p.discard(conn)
+}
+
+// discard closes the connection and frees its session
+func (p *Pool) discard(conn *Conn) {
+	if conn != nil {
+		_ = conn.Close() // ignore close errors, the session is discarded
+	}
+	p.mu.Lock()
+	p.open--
+	p.mu.Unlock()
+}
+
+// Do calls fn with a connection of the pool
+func (p *Pool) Do(ctx context.Context, fn func(conn *Conn) error) error {
+	conn, err := p.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.Put(conn)
+	return fn(conn)
+}
+
+// DoWrite calls fn with the connection of a write transaction (see
+// Conn.WithWriteTransaction), the session is pinned to fn until the
+// transaction ended
+func (p *Pool) DoWrite(ctx context.Context, fn func(conn *Conn) error) error {
+	return p.Do(ctx, func(conn *Conn) error {
+		return conn.WithWriteTransaction(ctx, func(tx *Tx) error {
+			return fn(tx.Conn)
+		})
+	})
+}
+
+// Stats returns the current state of the pool
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{MaxOpen: p.maxOpen, Open: p.open, Idle: len(p.idle)}
+}
+
+// Close closes all idle connections, connections that are in use are closed
+// when they are returned
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	var err error
+	for _, ic := range idle {
+		if cerr := ic.conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		p.mu.Lock()
+		p.open--
+		p.mu.Unlock()
+	}
+	return err
+}
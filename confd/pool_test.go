@@ -0,0 +1,191 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+func poolHelper(t *testing.T, srv *confdtest.Server, maxOpen int) *confd.Pool {
+	pool, err := confd.NewPool(srv.URL, maxOpen)
+	assert.NoError(t, err)
+	pool.NewConn = func() (*confd.Conn, error) { return srv.Conn(), nil }
+	return pool
+}
+
+func sid(t *testing.T, conn *confd.Conn) interface{} {
+	var sid interface{}
+	assert.NoError(t, conn.Request("get_SID", &sid))
+	return sid
+}
+
+func TestPool(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	pool := poolHelper(t, srv, 2)
+	ctx := context.Background()
+
+	c1, err := pool.Get(ctx)
+	assert.NoError(t, err)
+	c2, err := pool.Get(ctx)
+	assert.NoError(t, err)
+	sid1 := sid(t, c1)
+	assert.NotEqual(t, sid1, sid(t, c2), "every connection has its own session")
+	assert.Equal(t, confd.PoolStats{MaxOpen: 2, Open: 2}, pool.Stats())
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = pool.Get(timeout)
+	assert.Equal(t, context.DeadlineExceeded, err, "pool is capped")
+
+	pool.Put(c1)
+	assert.Equal(t, confd.PoolStats{MaxOpen: 2, Open: 2, Idle: 1}, pool.Stats())
+	c3, err := pool.Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, sid1, sid(t, c3), "idle sessions are reused")
+	pool.Put(c2)
+	pool.Put(c3)
+
+	assert.NoError(t, pool.Close())
+	assert.Equal(t, confd.PoolStats{MaxOpen: 2}, pool.Stats())
+	_, err = pool.Get(ctx)
+	assert.Equal(t, confd.ErrPoolClosed, err)
+}
+
+func TestPoolConcurrency(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	pool := poolHelper(t, srv, 3)
+	defer func() { _ = pool.Close() }()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := pool.Do(context.Background(), func(conn *confd.Conn) error {
+				assert.True(t, pool.Stats().Open <= 3)
+				_, err := conn.GetObjectClasses()
+				return err
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	stats := pool.Stats()
+	assert.Equal(t, stats.Open, stats.Idle)
+	assert.True(t, stats.Open <= 3)
+}
+
+func TestPoolDoWrite(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	srv.SetNodes(map[string]interface{}{"ntp": map[string]interface{}{"status": 0}})
+	pool := poolHelper(t, srv, 2)
+	defer func() { _ = pool.Close() }()
+	ctx := context.Background()
+
+	err := pool.DoWrite(ctx, func(conn *confd.Conn) error {
+		_, err := conn.SetNodeValue(1, "ntp", "status")
+		return err
+	})
+	assert.NoError(t, err)
+	value, _ := srv.Node("ntp", "status")
+	assert.Equal(t, float64(1), value)
+
+	failure := errors.New("failure")
+	err = pool.DoWrite(ctx, func(conn *confd.Conn) error {
+		_, err := conn.SetNodeValue(0, "ntp", "status")
+		assert.NoError(t, err)
+		return failure
+	})
+	assert.ErrorIs(t, err, failure)
+	value, _ = srv.Node("ntp", "status")
+	assert.Equal(t, float64(1), value, "transaction was rolled back")
+
+	// the write lock was released
+	err = pool.DoWrite(ctx, func(conn *confd.Conn) error { return nil })
+	assert.NoError(t, err)
+}
+
+func TestPoolPutOpenTransaction(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	srv.SetNodes(map[string]interface{}{"ntp": map[string]interface{}{"status": 0}})
+	pool := poolHelper(t, srv, 1)
+	defer func() { _ = pool.Close() }()
+	ctx := context.Background()
+
+	conn, err := pool.Get(ctx)
+	assert.NoError(t, err)
+	sid1 := sid(t, conn)
+	tx, err := conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	_, err = tx.SetNodeValue(1, "ntp", "status")
+	assert.NoError(t, err)
+	pool.Put(conn)
+	assert.Equal(t, confd.ErrTxDone, tx.Commit(), "the transaction was rolled back")
+	value, _ := srv.Node("ntp", "status")
+	assert.Equal(t, float64(0), value)
+
+	conn, err = pool.Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, sid1, sid(t, conn), "the session is reused")
+	pool.Put(conn)
+
+	// the connection is discarded if the rollback fails
+	var transport *flakyTransport
+	flaky := poolHelper(t, srv, 1)
+	defer func() { _ = flaky.Close() }()
+	flaky.NewConn = func() (*confd.Conn, error) {
+		var conn *confd.Conn
+		conn, transport = retryHelper(srv)
+		return conn, nil
+	}
+	conn, err = flaky.Get(ctx)
+	assert.NoError(t, err)
+	_, err = conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	transport.fail(1)
+	flaky.Put(conn)
+	assert.Equal(t, confd.PoolStats{MaxOpen: 1}, flaky.Stats())
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	pool := poolHelper(t, srv, 1)
+	defer func() { _ = pool.Close() }()
+	pool.HealthCheckInterval = 0
+	ctx := context.Background()
+
+	conn, err := pool.Get(ctx)
+	assert.NoError(t, err)
+	sid1 := sid(t, conn)
+	pool.Put(conn)
+
+	// break the idle session by pointing it to a closed port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	assert.NoError(t, l.Close())
+	assert.NoError(t, conn.Close())
+	conn.URL.Host = l.Addr().String()
+
+	fresh, err := pool.Get(ctx)
+	assert.NoError(t, err)
+	assert.False(t, fresh == conn, "broken session was replaced")
+	assert.NotEqual(t, sid1, sid(t, fresh))
+	pool.Put(fresh)
+	assert.Equal(t, confd.PoolStats{MaxOpen: 1, Open: 1, Idle: 1}, pool.Stats())
+}
@@ -6,6 +6,7 @@ package confd
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -37,14 +38,15 @@ func (r *request) String() string {
 	return fmt.Sprintf("[%d] %s(%s)", r.ID, r.Method, params)
 }
 
-// HTTP retruns an http request as bytes
-func (r *request) HTTP(host string) (*http.Request, error) {
+// HTTP retruns an http request as bytes, the request carries the context
+// down to the transport
+func (r *request) HTTP(ctx context.Context, host string) (*http.Request, error) {
 	var buf bytes.Buffer
 	err := json.NewEncoder(&buf).Encode(r)
 	if err != nil {
 		return nil, err
 	}
-	ru, err := http.NewRequest("POST", "/", &buf)
+	ru, err := http.NewRequestWithContext(ctx, "POST", "/", &buf)
 	if err != nil {
 		return nil, err
 	}
@@ -12,12 +12,32 @@ import (
 	"reflect"
 )
 
-// ErrEmptyResponse is likly triggered by calling a function that isn't exported
-var ErrEmptyResponse = errors.New("Empty response")
+// ErrEmptyResponse is likly triggered by calling a function that isn't
+// exported, it matches ErrProtocol
+var ErrEmptyResponse error = &protocolError{errors.New("Empty response")}
 
 // ErrReturnCode is triggered by a 0 return value
 var ErrReturnCode = errors.New("Returned 0, check errors")
 
+// protocolError marks responses that aren't valid JSON-RPC responses and
+// error responses, it matches ErrProtocol
+type protocolError struct {
+	err error
+}
+
+func (e *protocolError) Error() string {
+	return e.err.Error()
+}
+
+func (e *protocolError) Unwrap() error {
+	return e.err
+}
+
+// Is returns true for ErrProtocol
+func (e *protocolError) Is(target error) bool {
+	return target == ErrProtocol
+}
+
 // response is used for custom response handling
 // just include the type in your types to handle errors
 type response struct {
@@ -33,7 +53,7 @@ func newResponse(reader io.ReadCloser) (resp *response, err error) {
 	resp = new(response)
 	err = dec.Decode(resp)
 	if err != nil {
-		return
+		return resp, &protocolError{err}
 	}
 	return
 }
@@ -41,7 +61,7 @@ func newResponse(reader io.ReadCloser) (resp *response, err error) {
 // Decode the response into passed result or return request error
 func (r *response) Decode(result interface{}, checkReturn bool) (err error) {
 	if r.Error != nil {
-		return errors.New(*r.Error)
+		return &protocolError{errors.New(*r.Error)}
 	}
 	if r.Result == nil {
 		return ErrEmptyResponse
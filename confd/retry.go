@@ -0,0 +1,120 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy configures how calls that failed because of transport errors
+// are retried (see Conn.Retry). Only calls of functions that don't modify the
+// configuration (see Export.Write) are retried and never inside of a
+// transaction, since the lock or freeze is lost with the connection. Before a retry
+// the connection is re-established reusing the session (Options.SID).
+type RetryPolicy struct {
+	MaxAttempts int           // MaxAttempts including the first call
+	MinBackoff  time.Duration // MinBackoff before the first retry, doubled for every retry
+	MaxBackoff  time.Duration // MaxBackoff upper bound of the backoff
+	Jitter      float64       // Jitter fraction (0-1) of the backoff that is randomized
+}
+
+// DefaultRetryPolicy retries read calls twice
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  100 * time.Millisecond,
+	MaxBackoff:  2 * time.Second,
+	Jitter:      0.2,
+}
+
+// Backoff returns the time to wait before the retry, attempt is the number
+// of the failed attempt starting with 1
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := p.MinBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || backoff < p.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		backoff -= time.Duration(p.Jitter * rand.Float64() * float64(backoff))
+	}
+	return backoff
+}
+
+// transportError marks errors of the transport (connect or round trip),
+// only these errors are retried. The errors are returned to the caller, they
+// match ErrTransport and the wrapped error of the transport.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// Is returns true for ErrTransport
+func (e *transportError) Is(target error) bool {
+	return target == ErrTransport
+}
+
+// newTransportError marks the error as transport error, unless the error
+// was caused by the context
+func newTransportError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+		return err
+	}
+	return &transportError{err}
+}
+
+// unwrapTransportError returns the error of the transport
+func unwrapTransportError(err error) error {
+	if te, ok := err.(*transportError); ok {
+		return te.err
+	}
+	return err
+}
+
+// retry returns true if the failed attempt of the call should be retried,
+// it waits for the backoff before returning
+func (c *Conn) retry(ctx context.Context, method string, attempt int, err error) bool {
+	if _, ok := err.(*transportError); !ok || c.Retry == nil ||
+		attempt >= c.Retry.MaxAttempts || ctx.Err() != nil ||
+		c.tx != nil || !c.readExport(ctx, method) {
+		return false
+	}
+
+	backoff := c.Retry.Backoff(attempt)
+	c.logf("!! Retry %s in %v because of: %v", method, backoff, err)
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// readExport returns true if the function doesn't modify the configuration,
+// functions that are not exported are unknown and therefore not retried
+func (c *Conn) readExport(ctx context.Context, method string) bool {
+	if method == "get_exports" {
+		return true // the exports can't be used to look up themselves
+	}
+	exports, err := c.cachedExports(ctx)
+	if err != nil {
+		return false
+	}
+	export, ok := exports[method]
+	return ok && !bool(export.Write)
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd_test
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+var errFlaky = errors.New("connection reset by peer")
+
+// flakyTransport fails the given number of round trips
+type flakyTransport struct {
+	confd.Transport
+	mu       sync.Mutex
+	failures int
+}
+
+func (t *flakyTransport) fail(n int) {
+	t.mu.Lock()
+	t.failures = n
+	t.mu.Unlock()
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	fail := t.failures > 0
+	if fail {
+		t.failures--
+	}
+	t.mu.Unlock()
+	if fail {
+		_ = t.Transport.Close()
+		return nil, errFlaky
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+func retryHelper(srv *confdtest.Server) (*confd.Conn, *flakyTransport) {
+	conn := srv.Conn()
+	transport := &flakyTransport{Transport: conn.Transport}
+	conn.Transport = transport
+	conn.Retry = &confd.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}
+	return conn, transport
+}
+
+func TestRetry(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	conn, transport := retryHelper(srv)
+	defer func() { _ = conn.Close() }()
+
+	sid, err := conn.SimpleRequest("get_SID")
+	assert.NoError(t, err)
+
+	transport.fail(2)
+	classes, err := conn.GetObjectClasses()
+	assert.NoError(t, err)
+	assert.Empty(t, classes)
+	sid2, err := conn.SimpleRequest("get_SID")
+	assert.NoError(t, err)
+	assert.Equal(t, sid, sid2, "session is reused after reconnect")
+
+	transport.fail(3)
+	_, err = conn.GetObjectClasses()
+	assert.ErrorIs(t, err, errFlaky, "gives up after max attempts")
+	assert.ErrorIs(t, err, confd.ErrTransport)
+
+	transport.fail(1)
+	_, err = conn.SetNodeValue(1, "ntp", "status")
+	assert.ErrorIs(t, err, errFlaky, "write calls are not retried")
+
+	conn.Retry = nil
+	transport.fail(1)
+	_, err = conn.GetObjectClasses()
+	assert.ErrorIs(t, err, errFlaky)
+}
+
+func TestRetryInWriteTransaction(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	conn, transport := retryHelper(srv)
+	defer func() { _ = conn.Close() }()
+
+	tx, err := conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	transport.fail(1)
+	_, err = tx.GetObjectClasses()
+	assert.ErrorIs(t, err, errFlaky, "the lock would be lost")
+	_ = tx.Rollback()
+
+	transport.fail(1)
+	_, err = conn.GetObjectClasses()
+	assert.NoError(t, err)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := confd.RetryPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	assert.Equal(t, 100*time.Millisecond, policy.Backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.Backoff(2))
+	assert.Equal(t, 800*time.Millisecond, policy.Backoff(4))
+	assert.Equal(t, time.Second, policy.Backoff(5))
+	assert.Equal(t, time.Second, policy.Backoff(50))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		backoff := policy.Backoff(1)
+		assert.True(t, backoff > 50*time.Millisecond && backoff <= 100*time.Millisecond)
+	}
+}
@@ -4,23 +4,69 @@
 
 package confd
 
+import (
+	"context"
+	"sync"
+)
+
 // GetRights checks the rights of the currently logged in user.
 func (c *Conn) GetRights() ([]string, error) {
+	return c.GetRightsContext(context.Background())
+}
+
+// GetRightsContext is like GetRights but honours the context
+func (c *Conn) GetRightsContext(ctx context.Context) ([]string, error) {
 	var rights []string
-	err := c.Request("get_rights", &rights)
+	err := c.RequestContext(ctx, "get_rights", &rights)
 	return rights, err
 }
 
 // HasRight checks if the current user has the given right
 func (c *Conn) HasRight(right string) (bool, error) {
+	return c.HasRightContext(context.Background(), right)
+}
+
+// HasRightContext is like HasRight but honours the context
+func (c *Conn) HasRightContext(ctx context.Context, right string) (bool, error) {
 	var ok Bool
-	err := c.Request("get_rights", &ok, right)
+	err := c.RequestContext(ctx, "get_rights", &ok, right)
 	return bool(ok), err
 }
 
 // HasOneOfRights checks if the current user has one of the given rights
 func (c *Conn) HasOneOfRights(rights []string) (bool, error) {
+	return c.HasOneOfRightsContext(context.Background(), rights)
+}
+
+// HasOneOfRightsContext is like HasOneOfRights but honours the context
+func (c *Conn) HasOneOfRightsContext(ctx context.Context, rights []string) (bool, error) {
 	var ok Bool
-	err := c.Request("get_rights", &ok, rights)
+	err := c.RequestContext(ctx, "get_rights", &ok, rights)
 	return bool(ok), err
 }
+
+// rightsCache holds the rights of the user once they were requested
+type rightsCache struct {
+	rights []string
+	loaded bool
+	sync.Mutex
+}
+
+// cachedRights returns the rights of the user, they are requested once per
+// connection. The mutex isn't held during the request (see cachedExports).
+func (c *Conn) cachedRights(ctx context.Context) ([]string, error) {
+	c.rights.Lock()
+	rights, loaded := c.rights.rights, c.rights.loaded
+	c.rights.Unlock()
+	if loaded {
+		return rights, nil
+	}
+	rights, err := c.GetRightsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.rights.Lock()
+	c.rights.rights, c.rights.loaded = rights, true
+	c.rights.Unlock()
+	return rights, nil
+}
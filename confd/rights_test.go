@@ -10,7 +10,7 @@ import (
 )
 
 func TestGetRights(t *testing.T) {
-	conn := connHelper()
+	conn := connHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	rights, err := conn.GetRights()
@@ -19,6 +19,7 @@ func TestGetRights(t *testing.T) {
 }
 
 func TestAdminRights(t *testing.T) {
+	utmHelper(t)
 	conn := NewSystemConn()
 	defer func() { _ = conn.Close() }()
 
@@ -28,7 +29,7 @@ func TestAdminRights(t *testing.T) {
 }
 
 func TestHasRight(t *testing.T) {
-	conn := connHelper()
+	conn := connHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	ok, err := conn.HasRight("foo")
@@ -41,7 +42,7 @@ func TestHasRight(t *testing.T) {
 }
 
 func TestHasOneOfRights(t *testing.T) {
-	conn := connHelper()
+	conn := connHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	ok, err := conn.HasOneOfRights([]string{"foo"})
@@ -0,0 +1,230 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/internal/textutil"
+)
+
+// Delta describes the differences between two snapshots
+type Delta struct {
+	Added   []confd.AnyObject `json:"added,omitempty"`   // sorted by ref
+	Removed []confd.AnyObject `json:"removed,omitempty"` // sorted by ref
+	Changed []ObjectChange    `json:"changed,omitempty"` // sorted by ref
+	Nodes   []NodeChange      `json:"nodes,omitempty"`   // sorted by path
+}
+
+// ObjectChange describes the changes of an object that exists in both
+// snapshots
+type ObjectChange struct {
+	Ref   string       `json:"ref"`
+	Class string       `json:"class"`
+	Type  string       `json:"type"`
+	Name  string       `json:"name,omitempty"`
+	Meta  []AttrChange `json:"meta,omitempty"` // e.g. hidden, lock, nodel
+	Data  []AttrChange `json:"data,omitempty"`
+}
+
+// AttrChange describes the change of an attribute, missing attributes
+// are nil
+type AttrChange struct {
+	Name string      `json:"name"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// NodeChange describes the change of a node value, missing nodes are nil
+type NodeChange struct {
+	Path confd.NodePath `json:"path"`
+	Old  interface{}    `json:"old"`
+	New  interface{}    `json:"new"`
+}
+
+// Diff returns the changes required to get from snapshot a to snapshot b
+func Diff(a, b *Snapshot) *Delta {
+	d := new(Delta)
+	for _, obj := range a.Objects {
+		if _, ok := b.Object(obj.Ref); !ok {
+			d.Removed = append(d.Removed, obj)
+		}
+	}
+	for _, obj := range b.Objects {
+		old, ok := a.Object(obj.Ref)
+		if !ok {
+			d.Added = append(d.Added, obj)
+			continue
+		}
+		change := ObjectChange{Ref: obj.Ref, Class: obj.Class, Type: obj.Type,
+			Name: name(obj)}
+		change.Meta = diffAttrs(metaAttrs(old.ObjectMeta), metaAttrs(obj.ObjectMeta))
+		change.Data = diffAttrs(old.Data, obj.Data)
+		if len(change.Meta) > 0 || len(change.Data) > 0 {
+			d.Changed = append(d.Changed, change)
+		}
+	}
+	d.Nodes = diffNodes(nil, a.Nodes, b.Nodes)
+	return d
+}
+
+// Empty returns true if the snapshots are equal
+func (d *Delta) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 &&
+		len(d.Nodes) == 0
+}
+
+// diffAttrs compares the attributes of both maps
+func diffAttrs(a, b map[string]interface{}) []AttrChange {
+	var changes []AttrChange
+	for _, key := range textutil.UnionKeys(a, b) {
+		if !reflect.DeepEqual(a[key], b[key]) {
+			changes = append(changes, AttrChange{Name: key, Old: a[key], New: b[key]})
+		}
+	}
+	return changes
+}
+
+// diffNodes compares the node trees recursively, other values (including
+// arrays) are compared as a whole
+func diffNodes(path confd.NodePath, a, b interface{}) []NodeChange {
+	am, aIsMap := a.(map[string]interface{})
+	bm, bIsMap := b.(map[string]interface{})
+	if !aIsMap || !bIsMap {
+		if reflect.DeepEqual(a, b) {
+			return nil
+		}
+		return []NodeChange{{Path: path, Old: a, New: b}}
+	}
+	var changes []NodeChange
+	for _, key := range textutil.UnionKeys(am, bm) {
+		sub := append(append(confd.NodePath{}, path...), confd.NodeName(key))
+		changes = append(changes, diffNodes(sub, am[key], bm[key])...)
+	}
+	return changes
+}
+
+// metaAttrs returns the object meta information (without ref) as map
+func metaAttrs(meta confd.ObjectMeta) map[string]interface{} {
+	meta.Ref = ""
+	var attrs map[string]interface{}
+	data, _ := json.Marshal(meta) // can't fail for ObjectMeta
+	_ = json.Unmarshal(data, &attrs)
+	return attrs
+}
+
+// name returns the name of the object if any
+func name(obj confd.AnyObject) string {
+	str, _ := obj.Data["name"].(string)
+	return str
+}
+
+// String returns the delta in the text form
+func (d *Delta) String() string {
+	var b strings.Builder
+	_ = d.WriteText(&b) // strings.Builder never fails
+	return b.String()
+}
+
+// WriteText writes the delta in a human readable form
+func (d *Delta) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, obj := range d.Added {
+		fmt.Fprintf(&b, "+ %s %s/%s %q\n", obj.Ref, obj.Class, obj.Type, name(obj))
+	}
+	for _, obj := range d.Removed {
+		fmt.Fprintf(&b, "- %s %s/%s %q\n", obj.Ref, obj.Class, obj.Type, name(obj))
+	}
+	for _, c := range d.Changed {
+		fmt.Fprintf(&b, "~ %s %s/%s %q\n", c.Ref, c.Class, c.Type, c.Name)
+		for _, attr := range append(append([]AttrChange{}, c.Meta...), c.Data...) {
+			fmt.Fprintf(&b, "    %s: %s => %s\n", attr.Name, textutil.Format(attr.Old),
+				textutil.Format(attr.New))
+		}
+	}
+	for _, n := range d.Nodes {
+		fmt.Fprintf(&b, "~ node %s: %s => %s\n", nodePath(n.Path),
+			textutil.Format(n.Old), textutil.Format(n.New))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the delta as indented JSON document
+func (d *Delta) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(d)
+}
+
+// WriteUnified writes the delta similar to a unified diff, every object and
+// node is a hunk with one line per attribute. The names are used for the
+// file headers.
+func (d *Delta) WriteUnified(w io.Writer, oldName, newName string) error {
+	if d.Empty() {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for _, obj := range d.Added {
+		fmt.Fprintf(&b, "@@ %s %s/%s @@\n", obj.Ref, obj.Class, obj.Type)
+		writeLines(&b, "+", metaAttrs(obj.ObjectMeta), obj.Data)
+	}
+	for _, obj := range d.Removed {
+		fmt.Fprintf(&b, "@@ %s %s/%s @@\n", obj.Ref, obj.Class, obj.Type)
+		writeLines(&b, "-", metaAttrs(obj.ObjectMeta), obj.Data)
+	}
+	for _, c := range d.Changed {
+		fmt.Fprintf(&b, "@@ %s %s/%s @@\n", c.Ref, c.Class, c.Type)
+		for _, attr := range c.Meta {
+			writeChange(&b, "_"+attr.Name, attr.Old, attr.New)
+		}
+		for _, attr := range c.Data {
+			writeChange(&b, attr.Name, attr.Old, attr.New)
+		}
+	}
+	for _, n := range d.Nodes {
+		fmt.Fprintf(&b, "@@ node %s @@\n", nodePath(n.Path))
+		writeChange(&b, nodePath(n.Path), n.Old, n.New)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeLines writes all attributes with the prefix, meta attributes are
+// prefixed with an underscore
+func writeLines(b *strings.Builder, prefix string, meta, data map[string]interface{}) {
+	for _, key := range textutil.SortedKeys(meta) {
+		fmt.Fprintf(b, "%s_%s: %s\n", prefix, key, textutil.Format(meta[key]))
+	}
+	for _, key := range textutil.SortedKeys(data) {
+		fmt.Fprintf(b, "%s%s: %s\n", prefix, key, textutil.Format(data[key]))
+	}
+}
+
+// writeChange writes the removed and added line of the attribute
+func writeChange(b *strings.Builder, name string, old, new interface{}) {
+	if old != nil {
+		fmt.Fprintf(b, "-%s: %s\n", name, textutil.Format(old))
+	}
+	if new != nil {
+		fmt.Fprintf(b, "+%s: %s\n", name, textutil.Format(new))
+	}
+}
+
+// nodePath returns the path in dot notation
+func nodePath(path confd.NodePath) string {
+	parts := make([]string, len(path))
+	for i, name := range path {
+		parts[i] = string(name)
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,149 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+)
+
+func diffHelper() (*Snapshot, *Snapshot) {
+	a := &Snapshot{
+		Version: Version,
+		Objects: []confd.AnyObject{
+			object("REF_NetHostDns", "network", "host", map[string]interface{}{
+				"name": "DNS", "address": "8.8.8.8", "comment": "google"}),
+			object("REF_NetHostOld", "network", "host", map[string]interface{}{
+				"name": "Old"}),
+		},
+		Nodes: map[string]interface{}{
+			"ntp": map[string]interface{}{
+				"status":           float64(0),
+				"allowed_networks": []interface{}{"REF_NetHostDns"},
+			},
+			"timeout": float64(300),
+		},
+	}
+	b := &Snapshot{
+		Version: Version,
+		Objects: []confd.AnyObject{
+			object("REF_NetHostDns", "network", "host", map[string]interface{}{
+				"name": "DNS", "address": "9.9.9.9"}),
+			object("REF_NetHostNew", "network", "host", map[string]interface{}{
+				"name": "New"}),
+		},
+		Nodes: map[string]interface{}{
+			"ntp": map[string]interface{}{
+				"status":           float64(1),
+				"allowed_networks": []interface{}{"REF_NetHostDns"},
+			},
+			"debug": true,
+		},
+	}
+	b.Objects[0].Hidden = true
+	b.Objects[0].Lock = "global"
+	return a, b
+}
+
+func TestDiff(t *testing.T) {
+	a, b := diffHelper()
+
+	d := Diff(a, a)
+	assert.True(t, d.Empty())
+	assert.Equal(t, "", d.String())
+
+	d = Diff(a, b)
+	assert.False(t, d.Empty())
+	assert.Equal(t, []confd.AnyObject{b.Objects[1]}, d.Added)
+	assert.Equal(t, []confd.AnyObject{a.Objects[1]}, d.Removed)
+	assert.Equal(t, []ObjectChange{{
+		Ref: "REF_NetHostDns", Class: "network", Type: "host", Name: "DNS",
+		Meta: []AttrChange{
+			{Name: "hidden", Old: float64(0), New: float64(1)},
+			{Name: "lock", Old: nil, New: "global"},
+		},
+		Data: []AttrChange{
+			{Name: "address", Old: "8.8.8.8", New: "9.9.9.9"},
+			{Name: "comment", Old: "google", New: nil},
+		},
+	}}, d.Changed)
+	assert.Equal(t, []NodeChange{
+		{Path: confd.NodePath{"debug"}, Old: nil, New: true},
+		{Path: confd.NodePath{"ntp", "status"}, Old: float64(0), New: float64(1)},
+		{Path: confd.NodePath{"timeout"}, Old: float64(300), New: nil},
+	}, d.Nodes)
+}
+
+func TestDeltaText(t *testing.T) {
+	a, b := diffHelper()
+	assert.Equal(t, `+ REF_NetHostNew network/host "New"
+- REF_NetHostOld network/host "Old"
+~ REF_NetHostDns network/host "DNS"
+    hidden: 0 => 1
+    lock: null => "global"
+    address: "8.8.8.8" => "9.9.9.9"
+    comment: "google" => null
+~ node debug: null => true
+~ node ntp.status: 0 => 1
+~ node timeout: 300 => null
+`, Diff(a, b).String())
+}
+
+func TestDeltaUnified(t *testing.T) {
+	a, b := diffHelper()
+	var buf bytes.Buffer
+	assert.NoError(t, Diff(a, b).WriteUnified(&buf, "a.json", "b.json"))
+	assert.Equal(t, `--- a.json
++++ b.json
+@@ REF_NetHostNew network/host @@
++_autoname: 0
++_class: "network"
++_hidden: 0
++_type: "host"
++name: "New"
+@@ REF_NetHostOld network/host @@
+-_autoname: 0
+-_class: "network"
+-_hidden: 0
+-_type: "host"
+-name: "Old"
+@@ REF_NetHostDns network/host @@
+-_hidden: 0
++_hidden: 1
++_lock: "global"
+-address: "8.8.8.8"
++address: "9.9.9.9"
+-comment: "google"
+@@ node debug @@
++debug: true
+@@ node ntp.status @@
+-ntp.status: 0
++ntp.status: 1
+@@ node timeout @@
+-timeout: 300
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, Diff(a, a).WriteUnified(&buf, "a.json", "b.json"))
+	assert.Equal(t, "", buf.String())
+}
+
+func TestDeltaJSON(t *testing.T) {
+	a, b := diffHelper()
+	var buf bytes.Buffer
+	assert.NoError(t, Diff(a, b).WriteJSON(&buf))
+
+	var d Delta
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &d))
+	assert.Len(t, d.Added, 1)
+	assert.Len(t, d.Removed, 1)
+	assert.Len(t, d.Changed, 1)
+	assert.Len(t, d.Nodes, 3)
+	assert.Equal(t, confd.NodePath{"ntp", "status"}, d.Nodes[1].Path)
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/threez/sophos-utm9/confd"
+)
+
+// DryRunReport is the report of a dry run (see confd.Conn.WithDryRun)
+// including the delta of the modified objects and nodes
+type DryRunReport struct {
+	*confd.DryRunReport
+	Delta *Delta `json:"delta"` // Delta of the configuration made by the function
+}
+
+// DryRun calls fn inside of a write transaction that is always rolled back
+// (see confd.Conn.WithDryRun) and reports the errors and changes of the
+// configuration. Only the objects and nodes modified by fn are compared.
+func DryRun(ctx context.Context, conn *confd.Conn, fn func(tx *confd.Tx) error) (*DryRunReport, error) {
+	report, err := conn.WithDryRun(ctx, fn)
+	if err != nil {
+		return nil, err
+	}
+	return &DryRunReport{
+		DryRunReport: report,
+		Delta:        Diff(fromState(report.Before), fromState(report.After)),
+	}, nil
+}
+
+// fromState returns the partial snapshot of the dry run state
+func fromState(state confd.DryRunState) *Snapshot {
+	return &Snapshot{Version: Version, Objects: state.Objects, Nodes: state.Nodes}
+}
+
+// String returns the report in the text form
+func (r *DryRunReport) String() string {
+	var b strings.Builder
+	_ = r.WriteText(&b) // strings.Builder never fails
+	return b.String()
+}
+
+// WriteText writes the changes, the errors and a summary in a human readable
+// form
+func (r *DryRunReport) WriteText(w io.Writer) error {
+	var b strings.Builder
+	_ = r.Delta.WriteText(&b) // strings.Builder never fails
+	for _, desc := range r.Errors {
+		fmt.Fprintf(&b, "! %s\n", desc.Error())
+	}
+	for _, method := range r.Untracked {
+		fmt.Fprintf(&b, "! changes of %s are not shown\n", method)
+	}
+	if r.Err != nil {
+		fmt.Fprintf(&b, "! %v\n", r.Err)
+	}
+	verdict := "accepted"
+	if !r.Accepted() {
+		verdict = "rejected"
+	}
+	fmt.Fprintf(&b, "Dry run: %d changes, %d errors (%d fatal, %d not acknowledged), %s.\n",
+		len(r.Delta.Added)+len(r.Delta.Removed)+len(r.Delta.Changed)+len(r.Delta.Nodes),
+		len(r.Errors), len(r.Fatal), len(r.NoAck), verdict)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+)
+
+func TestDryRun(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	report, err := DryRun(context.Background(), conn, func(tx *confd.Tx) error {
+		_, err := tx.SetNodeValue(60, "timeout")
+		if err != nil {
+			return err
+		}
+		ok, err := tx.DelObject("REF_NetHostOther")
+		if err != nil || !ok {
+			return errors.New("delete failed")
+		}
+		return tx.ChangeObject("REF_NetHostDns", map[string]interface{}{"address": "9.9.9.9"})
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, report.Err)
+	assert.True(t, report.Accepted())
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, `- REF_NetHostOther network/host "Other"
+~ REF_NetHostDns network/host "DNS"
+    address: "8.8.8.8" => "9.9.9.9"
+~ node timeout: 300 => 60
+Dry run: 3 changes, 0 errors (0 fatal, 0 not acknowledged), accepted.
+`, report.String())
+
+	// only the modified objects and nodes were read, nothing was changed
+	assert.NotContains(t, srv.Calls(), "get_meta_nodes")
+	assert.Contains(t, srv.Calls(), "unlock")
+	assert.NotContains(t, srv.Calls(), "commit")
+	value, _ := srv.Node("timeout")
+	assert.Equal(t, float64(300), value)
+	_, ok := srv.Object("REF_NetHostOther")
+	assert.True(t, ok)
+}
+
+func TestDryRunRejected(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	report, err := DryRun(context.Background(), conn, func(tx *confd.Tx) error {
+		return tx.ChangeObject("REF_NetHostOther", map[string]interface{}{"name": "DNS"})
+	})
+	assert.NoError(t, err)
+	assert.Error(t, report.Err)
+	assert.False(t, report.Accepted())
+	if assert.Len(t, report.Errors, 1) {
+		assert.Equal(t, "OBJECT_NAME_EXISTS", report.Errors[0].MessageType)
+	}
+	assert.Len(t, report.Fatal, 1)
+	assert.True(t, report.Delta.Empty())
+	assert.Contains(t, report.String(), "Dry run: 0 changes, 1 errors (1 fatal, 1 not acknowledged), rejected.")
+
+	// the lock was released
+	_, err = conn.SetNodeValue(60, "timeout")
+	assert.NoError(t, err)
+}
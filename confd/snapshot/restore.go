@@ -0,0 +1,168 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/threez/sophos-utm9/confd"
+)
+
+// RestoreOptions change the behaviour of Restore
+type RestoreOptions struct {
+	// Prune deletes objects that are not part of the snapshot, objects that
+	// are protected from deletion (nodel) are kept
+	Prune bool
+}
+
+// Restore restores the snapshot in a single write transaction. Existing
+// objects are updated, missing objects are created and moved to the ref of
+// the snapshot. Objects are created after the objects they reference. If
+// anything fails, the transaction is rolled back (see
+// confd.Conn.WithWriteTransaction).
+func (s *Snapshot) Restore(ctx context.Context, conn *confd.Conn, opts RestoreOptions) error {
+	return conn.WithWriteTransaction(ctx, func(tx *confd.Tx) error {
+		// the live objects are read inside of the transaction, so that they
+		// can't change until the restore is done
+		live, err := tx.GetAllObjectsContext(ctx)
+		if err != nil {
+			return err
+		}
+		existing := make(map[string]confd.AnyObject, len(live))
+		for _, obj := range live {
+			existing[obj.Ref] = obj
+		}
+		return s.restore(ctx, tx.Conn, existing, opts)
+	})
+}
+
+func (s *Snapshot) restore(ctx context.Context, conn *confd.Conn, existing map[string]confd.AnyObject, opts RestoreOptions) error {
+	for _, obj := range s.ordered() {
+		if _, found := existing[obj.Ref]; found {
+			_, err := conn.SetObjectContext(ctx, obj, false)
+			if err = check(ctx, conn, err); err != nil {
+				return fmt.Errorf("Failed to update %s: %w", obj.Ref, err)
+			}
+			continue
+		}
+
+		ref := obj.Ref
+		obj.Ref = ""
+		newRef, err := conn.SetObjectContext(ctx, obj, false)
+		if err = check(ctx, conn, err); err != nil {
+			return fmt.Errorf("Failed to create %s: %w", ref, err)
+		}
+		if newRef != ref {
+			err = conn.MoveObjectContext(ctx, newRef, ref)
+			if err = check(ctx, conn, err); err != nil {
+				return fmt.Errorf("Failed to move %s to %s: %w", newRef, ref, err)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(s.Nodes))
+	for name := range s.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ok, err := conn.SetNodeValueContext(ctx, s.Nodes[name], confd.NodeName(name))
+		if err == nil && !ok {
+			err = confd.ErrReturnCode
+		}
+		if err = check(ctx, conn, err); err != nil {
+			return fmt.Errorf("Failed to set node %s: %w", name, err)
+		}
+	}
+
+	if opts.Prune {
+		refs := make([]string, 0, len(existing))
+		for ref, obj := range existing {
+			if _, ok := s.Object(ref); !ok && obj.Nodel == "" {
+				refs = append(refs, ref)
+			}
+		}
+		sort.Strings(refs)
+		for _, ref := range refs {
+			ok, err := conn.DelObjectContext(ctx, ref)
+			if err == nil && !ok {
+				err = confd.ErrReturnCode
+			}
+			if err = check(ctx, conn, err); err != nil {
+				return fmt.Errorf("Failed to delete %s: %w", ref, err)
+			}
+		}
+	}
+	return nil
+}
+
+// check returns the error list of the last call if err is nil or a 0
+// return value
+func check(ctx context.Context, conn *confd.Conn, err error) error {
+	if err != nil && !errors.Is(err, confd.ErrReturnCode) {
+		return err
+	}
+	errs, lerr := conn.ErrListContext(ctx)
+	if lerr != nil {
+		return lerr
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return err
+}
+
+// ordered returns the objects so that referenced objects of the snapshot
+// come first, cyclic references keep the ref order
+func (s *Snapshot) ordered() []confd.AnyObject {
+	ordered := make([]confd.AnyObject, 0, len(s.Objects))
+	visited := make(map[string]bool, len(s.Objects))
+	var visit func(obj confd.AnyObject)
+	visit = func(obj confd.AnyObject) {
+		if visited[obj.Ref] {
+			return
+		}
+		visited[obj.Ref] = true
+		for _, ref := range references(obj.Data) {
+			if dep, ok := s.Object(ref); ok {
+				visit(dep)
+			}
+		}
+		ordered = append(ordered, obj)
+	}
+	for _, obj := range s.Objects {
+		visit(obj)
+	}
+	return ordered
+}
+
+// references returns all refs used in the value in a stable order
+func references(value interface{}) []string {
+	var refs []string
+	switch tv := value.(type) {
+	case string:
+		if strings.HasPrefix(tv, "REF_") {
+			refs = append(refs, tv)
+		}
+	case []interface{}:
+		for _, item := range tv {
+			refs = append(refs, references(item)...)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(tv))
+		for key := range tv {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			refs = append(refs, references(tv[key])...)
+		}
+	}
+	return refs
+}
@@ -0,0 +1,140 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package snapshot exports the complete confd configuration (objects and
+// nodes) into a stable sorted JSON document and restores it. Snapshots are
+// meant to be human readable and diffable, e.g. to keep backups in git.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/threez/sophos-utm9/confd"
+)
+
+// Version of the snapshot format
+const Version = 1
+
+// Snapshot contains all objects and the node tree of a confd
+type Snapshot struct {
+	Version int                    `json:"version"`
+	Objects []confd.AnyObject      `json:"objects"` // sorted by ref
+	Nodes   map[string]interface{} `json:"nodes"`
+}
+
+// Take takes a snapshot of the configuration in a read transaction
+func Take(ctx context.Context, conn *confd.Conn) (*Snapshot, error) {
+	var snap *Snapshot
+	err := conn.WithReadTransaction(ctx, func(tx *confd.Tx) (err error) {
+		snap, err = take(ctx, tx.Conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// take takes the snapshot using the connection, the caller is responsible
+// for the transaction
+func take(ctx context.Context, conn *confd.Conn) (*Snapshot, error) {
+	objects, err := conn.GetAllObjectsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Ref < objects[j].Ref
+	})
+
+	nodes, err := takeNodes(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{Version: Version, Objects: objects, Nodes: nodes}, nil
+}
+
+// takeNodes reads the node tree, one request per top level node
+func takeNodes(ctx context.Context, conn *confd.Conn) (map[string]interface{}, error) {
+	meta, err := conn.GetMetaNodesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]interface{}, len(meta))
+	for name := range meta {
+		var value interface{}
+		err = conn.RequestContext(ctx, "get", &value, name)
+		if err == confd.ErrReturnCode {
+			value, err = float64(0), nil // the node value is 0
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get node %s: %w", name, err)
+		}
+		nodes[name] = value
+	}
+	return nodes, nil
+}
+
+// Object returns the object with the given ref
+func (s *Snapshot) Object(ref string) (confd.AnyObject, bool) {
+	i := sort.Search(len(s.Objects), func(i int) bool {
+		return s.Objects[i].Ref >= ref
+	})
+	if i < len(s.Objects) && s.Objects[i].Ref == ref {
+		return s.Objects[i], true
+	}
+	return confd.AnyObject{}, false
+}
+
+// Read reads a snapshot document
+func Read(r io.Reader) (*Snapshot, error) {
+	snap := new(Snapshot)
+	err := json.NewDecoder(r).Decode(snap)
+	if err != nil {
+		return nil, err
+	}
+	if snap.Version != Version {
+		return nil, fmt.Errorf("Unsupported snapshot version %d", snap.Version)
+	}
+	sort.SliceStable(snap.Objects, func(i, j int) bool {
+		return snap.Objects[i].Ref < snap.Objects[j].Ref
+	})
+	return snap, nil
+}
+
+// Write writes the snapshot as indented JSON document
+func (s *Snapshot) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(s)
+}
+
+// Load reads the snapshot from the file
+func Load(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return Read(f)
+}
+
+// Save writes the snapshot to the file
+func (s *Snapshot) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = s.Write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,231 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+func serverHelper() *confdtest.Server {
+	srv := confdtest.NewServer()
+	srv.AddObjects(
+		object("REF_NetHostDns", "network", "host", map[string]interface{}{
+			"name": "DNS", "address": "8.8.8.8"}),
+		object("REF_NetGroupAll", "network", "group", map[string]interface{}{
+			"name": "All", "members": []interface{}{"REF_NetHostDns"}}),
+		object("REF_NetHostOther", "network", "host", map[string]interface{}{
+			"name": "Other", "address": "1.1.1.1"}),
+	)
+	srv.SetNodes(map[string]interface{}{
+		"ntp": map[string]interface{}{
+			"status":           0,
+			"allowed_networks": []interface{}{"REF_NetGroupAll"},
+		},
+		"timeout": 300,
+	})
+	return srv
+}
+
+func object(ref, class, typ string, data map[string]interface{}) confd.AnyObject {
+	return confd.AnyObject{
+		ObjectMeta: confd.ObjectMeta{Ref: ref, Class: class, Type: typ},
+		Data:       data,
+	}
+}
+
+func TestTake(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	snap, err := Take(context.Background(), conn)
+	assert.NoError(t, err)
+	assert.Equal(t, Version, snap.Version)
+	assert.Len(t, snap.Objects, 3)
+	assert.Equal(t, "REF_NetGroupAll", snap.Objects[0].Ref)
+	assert.Equal(t, "REF_NetHostDns", snap.Objects[1].Ref)
+	assert.Equal(t, float64(300), snap.Nodes["timeout"])
+	assert.Equal(t, map[string]interface{}{
+		"status":           float64(0),
+		"allowed_networks": []interface{}{"REF_NetGroupAll"},
+	}, snap.Nodes["ntp"])
+
+	obj, ok := snap.Object("REF_NetHostOther")
+	assert.True(t, ok)
+	assert.Equal(t, "Other", obj.Data["name"])
+	_, ok = snap.Object("REF_Unknown")
+	assert.False(t, ok)
+}
+
+func TestWriteRead(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	snap, err := Take(context.Background(), conn)
+	assert.NoError(t, err)
+
+	var a, b bytes.Buffer
+	assert.NoError(t, snap.Write(&a))
+	assert.NoError(t, snap.Write(&b))
+	assert.Equal(t, a.String(), b.String())
+	assert.True(t, strings.HasPrefix(a.String(), "{\n  \"version\": 1,\n  \"objects\": [\n"))
+
+	read, err := Read(&a)
+	assert.NoError(t, err)
+	assert.Equal(t, snap, read)
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	assert.NoError(t, snap.Save(path))
+	loaded, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, snap, loaded)
+
+	_, err = Read(strings.NewReader(`{"version": 2}`))
+	assert.Error(t, err)
+}
+
+func TestRestore(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	ctx := context.Background()
+
+	snap, err := Take(ctx, conn)
+	assert.NoError(t, err)
+
+	// drift away from the snapshot
+	_, err = conn.SetNodeValue(false, "ntp", "allowed_networks")
+	assert.NoError(t, err)
+	_, err = conn.SetNodeValue(60, "timeout")
+	assert.NoError(t, err)
+	_, err = conn.SetNodeValue([]string{}, "ntp", "allowed_networks")
+	assert.NoError(t, err)
+	ok, err := conn.DelObject("REF_NetGroupAll")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = conn.DelObject("REF_NetHostDns")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, conn.ChangeObject("REF_NetHostOther",
+		map[string]interface{}{"address": "1.0.0.1"}))
+	_, err = conn.SetObject(object("", "network", "host", map[string]interface{}{
+		"name": "New", "address": "10.0.0.1"}), false)
+	assert.NoError(t, err)
+
+	assert.NoError(t, snap.Restore(ctx, conn, RestoreOptions{Prune: true}))
+
+	restored, err := Take(ctx, conn)
+	assert.NoError(t, err)
+	assert.Equal(t, snap, restored)
+}
+
+func TestRestoreRollback(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	ctx := context.Background()
+
+	snap, err := Take(ctx, conn)
+	assert.NoError(t, err)
+	snap.Objects[1].Data["address"] = "9.9.9.9" // REF_NetHostDns
+	snap.Objects[2].Data["name"] = "DNS"        // REF_NetHostOther
+
+	err = snap.Restore(ctx, conn, RestoreOptions{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Failed to update REF_NetHostOther")
+	assert.Contains(t, err.Error(), "OBJECT_NAME_EXISTS")
+
+	obj, ok := srv.Object("REF_NetHostDns")
+	assert.True(t, ok)
+	assert.Equal(t, "8.8.8.8", obj.Data["address"])
+}
+
+func TestOrdered(t *testing.T) {
+	snap := &Snapshot{Objects: []confd.AnyObject{
+		object("REF_A", "network", "group", map[string]interface{}{
+			"members": []interface{}{"REF_C", "REF_B"}}),
+		object("REF_B", "network", "host", map[string]interface{}{}),
+		object("REF_C", "network", "group", map[string]interface{}{
+			"members": []interface{}{"REF_B", "REF_Missing"}}),
+	}}
+	var refs []string
+	for _, obj := range snap.ordered() {
+		refs = append(refs, obj.Ref)
+	}
+	assert.Equal(t, []string{"REF_B", "REF_C", "REF_A"}, refs)
+}
+
+func TestRestoreCanceled(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	snap, err := Take(context.Background(), conn)
+	assert.NoError(t, err)
+	snap.Objects[1].Data["address"] = "9.9.9.9" // REF_NetHostDns
+
+	ctx, cancel := context.WithCancel(context.Background())
+	conn.Middleware = []confd.Middleware{
+		func(next confd.CallHandler) confd.CallHandler {
+			return func(ctx context.Context, call *confd.Call) error {
+				if call.Method == "set_object" {
+					cancel() // e.g. interrupted by the user
+				}
+				return next(ctx, call)
+			}
+		},
+	}
+	err = snap.Restore(ctx, conn, RestoreOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	calls := srv.Calls()
+	assert.Equal(t, []string{"lock", "get_objects", "err_list"}, calls[len(calls)-4:len(calls)-1],
+		"the live objects are read inside of the transaction")
+	assert.Equal(t, "unlock", calls[len(calls)-1], "rollback ignores the canceled context")
+}
+
+func TestRestorePanic(t *testing.T) {
+	srv := serverHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	snap, err := Take(context.Background(), conn)
+	assert.NoError(t, err)
+	snap.Objects[1].Data["address"] = "9.9.9.9" // REF_NetHostDns
+	conn.Middleware = []confd.Middleware{
+		func(next confd.CallHandler) confd.CallHandler {
+			return func(ctx context.Context, call *confd.Call) error {
+				if call.Method == "set_object" {
+					panic("broken restore")
+				}
+				return next(ctx, call)
+			}
+		},
+	}
+	assert.PanicsWithValue(t, "broken restore", func() {
+		_ = snap.Restore(context.Background(), conn, RestoreOptions{})
+	})
+	conn.Middleware = nil
+
+	calls := srv.Calls()
+	assert.Equal(t, "unlock", calls[len(calls)-1], "the panic rolled the transaction back")
+	_, err = Take(context.Background(), conn)
+	assert.NoError(t, err, "the gate was released")
+}
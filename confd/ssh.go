@@ -0,0 +1,260 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshScheme is the url scheme selecting the SSHTransport
+const sshScheme = "ssh+confd"
+
+// defaultSSHPort is used if the url doesn't contain a port
+const defaultSSHPort = "22"
+
+// defaultSSHUser is used if the url doesn't contain a user
+const defaultSSHUser = "root"
+
+// defaultKeyFiles are tried (relative to ~/.ssh) if no auth methods are given
+var defaultKeyFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// SSHTransport implements a RoundTripper that tunnels the confd connection
+// through ssh, by opening a direct-tcpip channel to the confd on the UTM.
+// Connections with an ssh+confd:// url use it by default. The user info of
+// the url is used for the ssh login, the confd credentials can be passed
+// using the user and password query parameters:
+//
+//	ssh+confd://root@utm/system?user=admin&password=secret
+//
+// The transport can be configured before connecting:
+//
+//	conn, _ := confd.NewConn("ssh+confd://root@utm/system")
+//	t := conn.Transport.(*confd.SSHTransport)
+//	t.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+type SSHTransport struct {
+	// Auth methods used for the login (optional). Defaults to the url
+	// password, the ssh agent (SSH_AUTH_SOCK) and the unencrypted default
+	// keys in ~/.ssh.
+	Auth []ssh.AuthMethod
+	// HostKeyCallback verifies the host key of the UTM (optional). Defaults
+	// to the KnownHostsFiles.
+	HostKeyCallback ssh.HostKeyCallback
+	// KnownHostsFiles are used to verify the host key if no HostKeyCallback
+	// is given. Defaults to ~/.ssh/known_hosts.
+	KnownHostsFiles []string
+	// RemoteAddr is the confd address dialed on the UTM, defaults to
+	// 127.0.0.1:4472
+	RemoteAddr string
+	tcpTransport
+}
+
+// NewSSHTransport creates a new ssh transport using the default settings
+func NewSSHTransport() *SSHTransport {
+	return &SSHTransport{
+		tcpTransport: tcpTransport{Timeout: defaultTimeout},
+	}
+}
+
+// Connect to the passed url
+func (t *SSHTransport) Connect(url *url.URL) error {
+	return t.ConnectContext(context.Background(), url)
+}
+
+// ConnectContext logs into the UTM using ssh and opens the channel to the
+// confd, connecting is aborted if the context is done
+func (t *SSHTransport) ConnectContext(ctx context.Context, url *url.URL) error {
+	return t.connect(ctx, func(ctx context.Context) (net.Conn, error) {
+		return t.dial(ctx, url)
+	})
+}
+
+func (t *SSHTransport) dial(ctx context.Context, url *url.URL) (net.Conn, error) {
+	config, closeAuth, err := t.clientConfig(url)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAuth()
+
+	addr := url.Host
+	if url.Port() == "" {
+		addr = net.JoinHostPort(url.Hostname(), defaultSSHPort)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the handshake is limited by the timeout and aborted as soon as the
+	// context is done
+	_ = conn.SetDeadline(time.Now().Add(t.Timeout))
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(aLongTimeAgo) })
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !stop() && err == nil {
+		_ = sshConn.Close() // ignore close errors
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close() // ignore close errors
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(sshConn, chans, reqs)
+
+	remote := t.RemoteAddr
+	if remote == "" {
+		remote = net.JoinHostPort(localhost, fmt.Sprint(defaultPort))
+	}
+	channel, err := client.DialContext(ctx, "tcp", remote)
+	if err != nil {
+		_ = client.Close() // ignore close errors
+		return nil, err
+	}
+	return &tunnelConn{Conn: channel, client: client}, nil
+}
+
+// clientConfig creates the ssh config for the login, the returned function
+// releases resources used for authentication
+func (t *SSHTransport) clientConfig(url *url.URL) (*ssh.ClientConfig, func(), error) {
+	closeAuth := func() {}
+	user := defaultSSHUser
+	if url.User != nil && url.User.Username() != "" {
+		user = url.User.Username()
+	}
+
+	auth := t.Auth
+	if auth == nil {
+		var agentConn net.Conn
+		auth, agentConn = defaultSSHAuth(url)
+		if agentConn != nil {
+			closeAuth = func() { _ = agentConn.Close() }
+		}
+	}
+
+	hostKeyCallback := t.HostKeyCallback
+	if hostKeyCallback == nil {
+		files := t.KnownHostsFiles
+		if len(files) == 0 {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				closeAuth()
+				return nil, nil, err
+			}
+			files = []string{filepath.Join(home, ".ssh", "known_hosts")}
+		}
+		var err error
+		hostKeyCallback, err = knownhosts.New(files...)
+		if err != nil {
+			closeAuth()
+			return nil, nil, err
+		}
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}, closeAuth, nil
+}
+
+// defaultSSHAuth returns the auth methods of the url, ssh agent and key
+// files. If the agent is used, the connection to it is returned as well.
+func defaultSSHAuth(url *url.URL) (auth []ssh.AuthMethod, agentConn net.Conn) {
+	if url.User != nil {
+		if password, ok := url.User.Password(); ok {
+			auth = append(auth, ssh.Password(password))
+		}
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err == nil {
+			agentConn = conn
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		var signers []ssh.Signer
+		for _, name := range defaultKeyFiles {
+			data, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+			if err != nil {
+				continue
+			}
+			signer, err := ssh.ParsePrivateKey(data)
+			if err != nil {
+				continue // e.g. encrypted keys, use the agent for those
+			}
+			signers = append(signers, signer)
+		}
+		if len(signers) > 0 {
+			auth = append(auth, ssh.PublicKeys(signers...))
+		}
+	}
+
+	return
+}
+
+// tunnelConn is the confd connection tunneled through ssh. Since ssh
+// channels don't support deadlines, the tunnel is closed once the deadline
+// is reached.
+type tunnelConn struct {
+	net.Conn
+	client *ssh.Client
+	mu     sync.Mutex
+	timer  *time.Timer
+	once   sync.Once
+}
+
+// SetDeadline closes the tunnel once the deadline is reached
+func (c *tunnelConn) SetDeadline(deadline time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if !deadline.IsZero() {
+		c.timer = time.AfterFunc(time.Until(deadline), func() { _ = c.Close() })
+	}
+	return nil
+}
+
+// SetReadDeadline see SetDeadline
+func (c *tunnelConn) SetReadDeadline(deadline time.Time) error {
+	return c.SetDeadline(deadline)
+}
+
+// SetWriteDeadline see SetDeadline
+func (c *tunnelConn) SetWriteDeadline(deadline time.Time) error {
+	return c.SetDeadline(deadline)
+}
+
+// Close the channel and the ssh connection
+func (c *tunnelConn) Close() (err error) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		c.mu.Unlock()
+		err = c.Conn.Close()
+		if cerr := c.client.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return
+}
@@ -0,0 +1,193 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshServer is a minimal in-process ssh server that forwards direct-tcpip
+// channels for 127.0.0.1:4472 to the backend
+type sshServer struct {
+	listener net.Listener
+	hostKey  ssh.PublicKey
+	backend  string
+	dialed   chan string
+}
+
+func newSSHServer(t *testing.T, backend string) *sshServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "root" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %q", c.User())
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &sshServer{
+		listener: listener,
+		hostKey:  signer.PublicKey(),
+		backend:  backend,
+		dialed:   make(chan string, 10),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *sshServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "direct-tcpip" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		var payload struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+		_ = ssh.Unmarshal(newChannel.ExtraData(), &payload)
+		s.dialed <- fmt.Sprintf("%s:%d", payload.Host, payload.Port)
+		backend, err := net.Dial("tcp", s.backend)
+		if err != nil {
+			_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go ssh.DiscardRequests(requests)
+		go func() {
+			_, _ = io.Copy(channel, backend)
+			_ = channel.Close()
+		}()
+		go func() {
+			_, _ = io.Copy(backend, channel)
+			_ = backend.Close()
+		}()
+	}
+}
+
+func (s *sshServer) URL(userinfo string) string {
+	return fmt.Sprintf("ssh+confd://%s@%s/system", userinfo, s.listener.Addr())
+}
+
+func backendHelper() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":0,"result":"1234"}`))
+	}))
+}
+
+func TestSSHTransport(t *testing.T) {
+	backend := backendHelper()
+	defer backend.Close()
+	server := newSSHServer(t, backend.Listener.Addr().String())
+	defer func() { _ = server.listener.Close() }()
+
+	conn, err := NewConn(server.URL("root:secret") + "?user=admin&password=pass")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", conn.Options.Username)
+	assert.Equal(t, "pass", conn.Options.Password)
+	assert.NotContains(t, conn.safeURL(), "secret")
+	assert.NotContains(t, conn.safeURL(), "password=pass")
+
+	tr := conn.Transport.(*SSHTransport)
+	tr.HostKeyCallback = ssh.FixedHostKey(server.hostKey)
+
+	sid, err := conn.SimpleRequest("get_SID")
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", *sid.(*interface{}))
+	assert.Equal(t, "127.0.0.1:4472", <-server.dialed)
+	assert.True(t, tr.IsConnected())
+	assert.NoError(t, conn.Close())
+	assert.False(t, tr.IsConnected())
+}
+
+func TestSSHTransportIdle(t *testing.T) {
+	backend := backendHelper()
+	defer backend.Close()
+	server := newSSHServer(t, backend.Listener.Addr().String())
+	defer func() { _ = server.listener.Close() }()
+
+	conn, err := NewConn(server.URL("root:secret"))
+	assert.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	tr := conn.Transport.(*SSHTransport)
+	tr.HostKeyCallback = ssh.FixedHostKey(server.hostKey)
+	tr.Timeout = 50 * time.Millisecond
+
+	_, err = conn.SimpleRequest("get_SID")
+	assert.NoError(t, err)
+	time.Sleep(2 * tr.Timeout) // idle longer than the timeout
+	assert.True(t, tr.IsConnected())
+	_, err = conn.SimpleRequest("get_SID")
+	assert.NoError(t, err)
+	assert.Len(t, server.dialed, 1, "the tunnel was reused")
+}
+
+func TestSSHTransportAuthFailed(t *testing.T) {
+	backend := backendHelper()
+	defer backend.Close()
+	server := newSSHServer(t, backend.Listener.Addr().String())
+	defer func() { _ = server.listener.Close() }()
+
+	tr := NewSSHTransport()
+	tr.HostKeyCallback = ssh.FixedHostKey(server.hostKey)
+	u, err := url.Parse(server.URL("root:wrong"))
+	assert.NoError(t, err)
+	assert.Error(t, tr.Connect(u))
+	assert.False(t, tr.IsConnected())
+}
+
+func TestSSHTransportHostKeyMismatch(t *testing.T) {
+	backend := backendHelper()
+	defer backend.Close()
+	server := newSSHServer(t, backend.Listener.Addr().String())
+	defer func() { _ = server.listener.Close() }()
+	other := newSSHServer(t, backend.Listener.Addr().String())
+	defer func() { _ = other.listener.Close() }()
+
+	tr := NewSSHTransport()
+	tr.HostKeyCallback = ssh.FixedHostKey(other.hostKey)
+	u, err := url.Parse(server.URL("root:secret"))
+	assert.NoError(t, err)
+	assert.Error(t, tr.Connect(u))
+	assert.False(t, tr.IsConnected())
+}
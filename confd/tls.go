@@ -0,0 +1,111 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// TLSTransport implements a tls+http RoundTripper for confd connections. It
+// is used to access the confd through a TLS terminating proxy (e.g. stunnel
+// or haproxy) on the UTM. Connections with an https:// url use it by
+// default, the transport can be configured before connecting:
+//
+//	conn, _ := confd.NewConn("https://system@utm:4473/system")
+//	t := conn.Transport.(*confd.TLSTransport)
+//	t.Config = &tls.Config{RootCAs: pool, Certificates: clientCerts}
+type TLSTransport struct {
+	// Config used for the handshake (optional). If no ServerName is set, the
+	// host of the url is used.
+	Config *tls.Config
+	// Pins are hex encoded SHA-256 fingerprints (see Fingerprint) of accepted
+	// server (leaf) certificates. If pins are given, the certificate chain is not
+	// verified using the root CAs, which allows to pin the self-signed
+	// certificate of the UTM.
+	Pins []string
+	tcpTransport
+}
+
+// NewTLSTransport creates a new tls transport using the passed config (can
+// be nil)
+func NewTLSTransport(config *tls.Config) *TLSTransport {
+	return &TLSTransport{
+		Config:       config,
+		tcpTransport: tcpTransport{Timeout: defaultTimeout},
+	}
+}
+
+// Connect to the passed url
+func (t *TLSTransport) Connect(url *url.URL) error {
+	return t.ConnectContext(context.Background(), url)
+}
+
+// ConnectContext connects to the passed url and does the TLS handshake,
+// dialing is aborted if the context is done
+func (t *TLSTransport) ConnectContext(ctx context.Context, url *url.URL) error {
+	dialer := tls.Dialer{Config: t.config(url)}
+	return t.connect(ctx, func(ctx context.Context) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", url.Host)
+	})
+}
+
+// config returns the tls config for the connection to url
+func (t *TLSTransport) config(url *url.URL) *tls.Config {
+	var config *tls.Config
+	if t.Config != nil {
+		config = t.Config.Clone()
+	} else {
+		config = new(tls.Config)
+	}
+	if config.ServerName == "" {
+		config.ServerName = url.Hostname()
+	}
+	if len(t.Pins) > 0 {
+		// the chain is verified using the pins instead
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = t.verifyPins
+	}
+	return config
+}
+
+// verifyPins checks that the leaf certificate is pinned. The other
+// certificates of the chain aren't checked, the server proved only that it
+// owns the key of the leaf.
+func (t *TLSTransport) verifyPins(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("No certificate presented")
+	}
+	fingerprint := fingerprint(rawCerts[0])
+	for _, pin := range t.Pins {
+		if normalizePin(pin) == fingerprint {
+			return nil
+		}
+	}
+	return fmt.Errorf("The presented certificate is not pinned")
+}
+
+// Fingerprint returns the hex encoded SHA-256 fingerprint of the certificate
+// as used for pinning
+func Fingerprint(cert *x509.Certificate) string {
+	return fingerprint(cert.Raw)
+}
+
+func fingerprint(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizePin allows pins in upper case and with colons (openssl format)
+func normalizePin(pin string) string {
+	return strings.ToLower(strings.Replace(pin, ":", "", -1))
+}
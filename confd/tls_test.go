@@ -0,0 +1,124 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tlsServerHelper() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":0,"result":1}`))
+	}))
+}
+
+func tlsRoundTrip(t *testing.T, tr *TLSTransport, server *httptest.Server) error {
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	err = tr.Connect(u)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tr.Close() }()
+
+	req, err := http.NewRequest("POST", "/", &bytes.Buffer{})
+	assert.NoError(t, err)
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":0,"result":1}`, string(body))
+	return nil
+}
+
+func TestTLSTransportRootCAs(t *testing.T) {
+	server := tlsServerHelper()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	tr := NewTLSTransport(&tls.Config{RootCAs: pool})
+	assert.NoError(t, tlsRoundTrip(t, tr, server))
+	assert.False(t, tr.IsConnected())
+}
+
+func TestTLSTransportUnknownAuthority(t *testing.T) {
+	server := tlsServerHelper()
+	defer server.Close()
+
+	tr := NewTLSTransport(nil)
+	assert.Error(t, tlsRoundTrip(t, tr, server))
+	assert.False(t, tr.IsConnected())
+}
+
+func TestTLSTransportPins(t *testing.T) {
+	server := tlsServerHelper()
+	defer server.Close()
+
+	pin := strings.ToUpper(Fingerprint(server.Certificate()))
+	tr := NewTLSTransport(nil)
+	tr.Pins = []string{pin}
+	assert.NoError(t, tlsRoundTrip(t, tr, server))
+
+	tr.Pins = []string{strings.Repeat("00", 32)}
+	assert.Error(t, tlsRoundTrip(t, tr, server))
+}
+
+func TestTLSTransportPinsLeafOnly(t *testing.T) {
+	pinned := tlsServerHelper()
+	pinned.Close()
+
+	// the attacker presents its own leaf followed by the pinned certificate
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "attacker"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	server := httptest.NewUnstartedServer(pinned.Config.Handler)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{leaf, pinned.Certificate().Raw},
+		PrivateKey:  key,
+	}}}
+	server.StartTLS()
+	defer server.Close()
+
+	tr := NewTLSTransport(nil)
+	tr.Pins = []string{Fingerprint(pinned.Certificate())}
+	assert.Error(t, tlsRoundTrip(t, tr, server))
+	assert.False(t, tr.IsConnected())
+}
+
+func TestNewConnTransport(t *testing.T) {
+	conn, err := NewConn("https://system@127.0.0.1:4473/system")
+	assert.NoError(t, err)
+	assert.IsType(t, &TLSTransport{}, conn.Transport)
+
+	conn, err = NewConn("http://system@127.0.0.1:4472/system")
+	assert.NoError(t, err)
+	assert.IsType(t, &tcpTransport{}, conn.Transport)
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Names of the spans started by connections
+const (
+	SpanCall        = "confd.call"        // every Request, child of the context of the call
+	SpanConnect     = "confd.connect"     // connect of the transport and session (new, get_SID)
+	SpanRoundTrip   = "confd.round_trip"  // round trip of the transport
+	SpanTransaction = "confd.transaction" // from begin until commit or rollback
+)
+
+// Attributes set on the spans
+const (
+	AttrMethod    = "confd.method"              // method of the call
+	AttrRequestID = "confd.request_id"          // id of the last request of the call
+	AttrAttempts  = "confd.attempts"            // number of attempts of the call
+	AttrSIDHash   = "confd.sid_hash"            // hash of the session id, the sid is a secret
+	AttrErrors    = "confd.errors"              // message types of the error list
+	AttrTxKind    = "confd.transaction.kind"    // TxRead or TxWrite
+	AttrTxOutcome = "confd.transaction.outcome" // TxCommit or TxRollback
+)
+
+// Tracer starts the spans of a connection (see Conn.Tracer and package
+// confdotel)
+type Tracer interface {
+	// Start starts a span with the given name as child of the span in the
+	// context, the returned context carries the new span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by the Tracer
+type Span interface {
+	// SetAttribute sets an attribute, values are strings, integers or
+	// string slices
+	SetAttribute(key string, value interface{})
+	// Context returns a copy of ctx that carries the span
+	Context(ctx context.Context) context.Context
+	// End ends the span, err is the error of the traced operation
+	End(err error)
+}
+
+// nopTracer is used if the connection has no tracer
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+// nopSpan is started by the nopTracer
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key string, value interface{})  {}
+func (nopSpan) Context(ctx context.Context) context.Context { return ctx }
+func (nopSpan) End(err error)                               {}
+
+// tracer returns the tracer of the connection
+func (c *Conn) tracer() Tracer {
+	if c.Tracer == nil {
+		return nopTracer{}
+	}
+	return c.Tracer
+}
+
+// sidHash returns a short hash of the session id, so that the sessions of
+// calls can be correlated without revealing the sid
+func sidHash(sid interface{}) string {
+	if sid == nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprint(sid)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// endCallSpan sets the attributes of the call and ends the span
+func (c *Conn) endCallSpan(span Span, call *Call, stats *callStats, err error) {
+	span.SetAttribute(AttrMethod, call.Method)
+	span.SetAttribute(AttrRequestID, int64(stats.id))
+	span.SetAttribute(AttrAttempts, int64(stats.attempts))
+	if hash := sidHash(stats.sid); hash != "" {
+		span.SetAttribute(AttrSIDHash, hash)
+	}
+	var errs ErrList
+	if errors.As(err, &errs) {
+		types := make([]string, len(errs))
+		for i, desc := range errs {
+			types[i] = desc.MessageType
+		}
+		span.SetAttribute(AttrErrors, types)
+	}
+	span.End(err)
+}
@@ -4,59 +4,359 @@
 
 package confd
 
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTxDone is returned if the transaction handle is used after the
+// transaction was committed or rolled back
+var ErrTxDone = errors.New("Transaction has already been committed or rolled back")
+
+// ErrTxBroken is returned if the transaction handle is used after the
+// connection failed during the transaction
+var ErrTxBroken = errors.New("Transaction was aborted, the connection failed")
+
+// ErrTxNested is returned if a transaction is started using the handle of an
+// open transaction
+var ErrTxNested = errors.New("Transaction can't be started inside of a transaction")
+
+// ErrTxOpen is returned if the connection that started a transaction is used
+// for calls while the transaction is open and Conn.NoTxWait is set
+var ErrTxOpen = errors.New("Transaction is open, calls need to use its handle")
+
 // Transaction abstracts the read and write transactions to the confd
 type Transaction interface {
 	// Commit current transaction
 	Commit() error
+	// CommitContext commits the current transaction honouring the context
+	CommitContext(ctx context.Context) error
 	// Rollback current Transaction
 	Rollback() error
+	// RollbackContext rolls the current transaction back honouring the context
+	RollbackContext(ctx context.Context) error
 }
 
-type writeTransaction struct{ *Conn }
-type readTransaction struct{ *Conn }
+// states of a transaction handle
+const (
+	txOpen int32 = iota
+	txEnding
+	txDone
+)
+
+// Tx is the handle of a read or write transaction. The embedded connection
+// shares the session with the connection that started the transaction, only
+// calls using the handle are executed inside of the transaction. Calls using
+// the connection that started the transaction wait until the transaction
+// ended or their context is done, or return ErrTxOpen if Conn.NoTxWait is
+// set. Calls using the handle after the transaction was committed or rolled
+// back return ErrTxDone, after the connection failed ErrTxBroken.
+type Tx struct {
+	*Conn
+	kind   string // kind of the transaction TxRead or TxWrite
+	start  time.Time
+	span   Span         // span of the transaction, parent of commit and rollback
+	state  atomic.Int32 // txOpen, txEnding or txDone
+	broken atomic.Bool  // the connection failed during the transaction
+}
 
 // BeginReadTransaction starts new read transaction
-func (c *Conn) BeginReadTransaction() (Transaction, error) {
-	mutex := c.txMu
-	mutex.Lock()
-	_, err := c.SimpleRequest("freeze")
-	if err != nil {
-		mutex.Unlock()
-		return nil, err
-	}
-	return &readTransaction{c}, nil
+func (c *Conn) BeginReadTransaction() (*Tx, error) {
+	return c.BeginReadTransactionContext(context.Background())
+}
+
+// BeginReadTransactionContext is like BeginReadTransaction but honours the
+// context, the context also limits the time waiting for other transactions
+func (c *Conn) BeginReadTransactionContext(ctx context.Context) (*Tx, error) {
+	return c.begin(ctx, TxRead, "freeze")
 }
 
 // BeginWriteTransaction starts new write transaction
-func (c *Conn) BeginWriteTransaction() (Transaction, error) {
-	mutex := c.txMu
-	mutex.Lock()
-	_, err := c.SimpleRequest("lock")
+func (c *Conn) BeginWriteTransaction() (*Tx, error) {
+	return c.BeginWriteTransactionContext(context.Background())
+}
+
+// BeginWriteTransactionContext is like BeginWriteTransaction but honours the
+// context, the context also limits the time waiting for other transactions.
+// Read only connections return ErrReadOnly.
+func (c *Conn) BeginWriteTransactionContext(ctx context.Context) (*Tx, error) {
+	if c.ReadOnly {
+		return nil, &ErrReadOnly{Method: "lock"}
+	}
+	return c.begin(ctx, TxWrite, "lock")
+}
+
+// begin waits until the open transaction and the calls outside of
+// transactions ended and starts a transaction of the kind by calling method
+func (c *Conn) begin(ctx context.Context, kind, method string) (*Tx, error) {
+	if c.tx != nil || ctx.Value(gateKey{}) == c.session {
+		return nil, ErrTxNested // the gate is held by the caller
+	}
+	ctx, span := c.tracer().Start(ctx, SpanTransaction)
+	span.SetAttribute(AttrTxKind, kind)
+	t := &Tx{kind: kind, span: span}
+	bound := *c // the copy shares the session
+	bound.tx = t
+	t.Conn = &bound
+
+	start := time.Now()
+	err := c.gate.acquireTx(ctx, t)
+	c.observer().ObserveLockWait(kind, time.Since(start))
 	if err != nil {
-		mutex.Unlock()
+		span.End(err)
 		return nil, err
 	}
-	return &writeTransaction{c}, nil
+	_, err = t.SimpleRequestContext(ctx, method)
+	if err != nil {
+		if kind == TxWrite {
+			err = newLockError(err)
+		}
+		t.state.Store(txDone)
+		c.gate.releaseTx(t)
+		span.End(err)
+		return nil, err
+	}
+	t.start = time.Now()
+	return t, nil
 }
 
-func (t *readTransaction) Rollback() (err error) {
-	return t.Commit()
+// lockError is returned if confd refused the lock of a write transaction,
+// e.g. because another session holds it. The error matches ErrLockConflict
+// and wraps the error returned by the lock call.
+type lockError struct {
+	err error
 }
 
-func (t *readTransaction) Commit() (err error) {
-	_, err = t.SimpleRequest("thaw")
-	t.txMu.Unlock()
-	return
+func (e *lockError) Error() string {
+	return e.err.Error()
 }
 
-func (t *writeTransaction) Rollback() (err error) {
-	_, err = t.SimpleRequest("unlock")
-	t.txMu.Unlock()
-	return
+func (e *lockError) Unwrap() error {
+	return e.err
+}
+
+// Is returns true for ErrLockConflict
+func (e *lockError) Is(target error) bool {
+	return target == ErrLockConflict
+}
+
+// newLockError marks the error of the lock call as lock conflict, if confd
+// refused the lock (returned 0 and maybe reported errors)
+func newLockError(err error) error {
+	var errs ErrList
+	if errors.Is(err, ErrReturnCode) || errors.As(err, &errs) {
+		return &lockError{err}
+	}
+	return err
+}
+
+// Rollback rolls the write transaction back, read transactions are ended
+func (t *Tx) Rollback() error {
+	return t.RollbackContext(context.Background())
+}
+
+// RollbackContext is like Rollback but honours the context
+func (t *Tx) RollbackContext(ctx context.Context) error {
+	if t.kind == TxRead {
+		return t.end(ctx, "thaw", TxCommit)
+	}
+	return t.end(ctx, "unlock", TxRollback)
+}
+
+// Commit commits the write transaction, read transactions are ended
+func (t *Tx) Commit() error {
+	return t.CommitContext(context.Background())
+}
+
+// CommitContext is like Commit but honours the context. Broken transactions
+// are rolled back and return ErrTxBroken. If the commit fails the transaction
+// is rolled back and *ErrTransaction is returned.
+func (t *Tx) CommitContext(ctx context.Context) error {
+	if t.kind == TxRead {
+		return t.end(ctx, "thaw", TxCommit)
+	}
+	return t.end(ctx, "commit", TxCommit)
 }
 
-func (t *writeTransaction) Commit() (err error) {
-	_, err = t.SimpleRequest("commit")
-	t.txMu.Unlock()
+// end ends the transaction by calling method. A failed commit is rolled back,
+// the error is returned as *ErrTransaction. Broken transactions are rolled
+// back on a best effort basis, in case confd kept the session. If the
+// transaction couldn't be ended, it is marked broken and keeps the gate, so
+// that the handle can be used to roll it back again.
+func (t *Tx) end(ctx context.Context, method, outcome string) (err error) {
+	if !t.state.CompareAndSwap(txOpen, txEnding) {
+		return ErrTxDone
+	}
+	broken := t.broken.Load()
+	if broken {
+		method, outcome = "unlock", TxRollback
+		if t.kind == TxRead {
+			method = "thaw"
+		}
+	}
+	ctx = t.span.Context(ctx)
+	_, err = t.SimpleRequestContext(ctx, method)
+	ended := err == nil
+	if method == "commit" && err != nil {
+		// confd keeps the lock if the commit failed
+		outcome = TxRollback
+		_, rerr := t.SimpleRequestContext(ctx, "unlock")
+		ended = rerr == nil
+		err = &ErrTransaction{Cause: err, Rollback: rerr}
+	}
+	if !ended {
+		t.broken.Store(true)
+		t.state.Store(txOpen)
+		return err
+	}
+	if broken {
+		err = ErrTxBroken
+	}
+	t.state.Store(txDone)
+	t.gate.releaseTx(t)
+	t.observer().ObserveTransaction(t.kind, outcome, time.Since(t.start), err)
+	t.span.SetAttribute(AttrTxOutcome, outcome)
+	t.span.End(err)
 	return
 }
+
+// gateKey marks contexts of calls that hold the gate of the session
+type gateKey struct{}
+
+// holdGate prevents that a transaction begins during a call outside of
+// transactions, the returned function releases the gate once the call is
+// done. Calls nested in such a call (e.g. the automatic error handling)
+// reuse the gate of the outer call. Calls of a transaction handle don't
+// take the gate but fail if the transaction ended or broke.
+func (c *Conn) holdGate(ctx context.Context) (context.Context, func(), error) {
+	if c.tx != nil {
+		switch {
+		case c.tx.state.Load() == txDone:
+			return ctx, nil, ErrTxDone
+		case c.tx.state.Load() == txOpen && c.tx.broken.Load():
+			return ctx, nil, ErrTxBroken
+		}
+		return ctx, func() {}, nil
+	}
+	if ctx.Value(gateKey{}) == c.session {
+		return ctx, func() {}, nil
+	}
+	if err := c.gate.acquireCall(ctx, c.NoTxWait); err != nil {
+		return ctx, nil, err
+	}
+	return context.WithValue(ctx, gateKey{}, c.session), c.gate.releaseCall, nil
+}
+
+// txGate is held by the open transaction of a session or shared by the calls
+// outside of transactions. Waiting transactions take precedence over new
+// calls.
+type txGate struct {
+	mu      sync.Mutex
+	calls   int           // calls outside of transactions in progress
+	waiting int           // transactions waiting for the gate
+	tx      *Tx           // transaction holding the gate
+	changed chan struct{} // closed once the state changed
+}
+
+// acquireCall waits until no transaction holds or waits for the gate or the
+// context is done. If noWait is set it fails if a transaction holds the gate.
+func (g *txGate) acquireCall(ctx context.Context, noWait bool) error {
+	g.mu.Lock()
+	for g.tx != nil || g.waiting > 0 {
+		if g.tx != nil && noWait {
+			g.mu.Unlock()
+			return ErrTxOpen
+		}
+		if err := g.wait(ctx); err != nil {
+			return err
+		}
+	}
+	g.calls++
+	g.mu.Unlock()
+	return nil
+}
+
+// releaseCall releases the gate acquired using acquireCall
+func (g *txGate) releaseCall() {
+	g.mu.Lock()
+	g.calls--
+	if g.calls == 0 {
+		g.notify()
+	}
+	g.mu.Unlock()
+}
+
+// acquireTx waits until the gate is free or the context is done, the
+// transaction holds the gate until releaseTx
+func (g *txGate) acquireTx(ctx context.Context, t *Tx) error {
+	g.mu.Lock()
+	g.waiting++
+	for g.tx != nil || g.calls > 0 {
+		if err := g.wait(ctx); err != nil {
+			g.mu.Lock()
+			g.waiting--
+			g.notify() // calls might be waiting for this transaction
+			g.mu.Unlock()
+			return err
+		}
+	}
+	g.waiting--
+	g.tx = t
+	g.mu.Unlock()
+	return nil
+}
+
+// releaseTx releases the gate held by the transaction
+func (g *txGate) releaseTx(t *Tx) {
+	g.mu.Lock()
+	if g.tx == t {
+		g.tx = nil
+		g.notify()
+	}
+	g.mu.Unlock()
+}
+
+// current returns the transaction holding the gate, nil if there is none
+func (g *txGate) current() *Tx {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.tx
+}
+
+// abort marks the transaction holding the gate as broken, the gate stays
+// held until the transaction is ended using its handle
+func (g *txGate) abort() {
+	g.mu.Lock()
+	if g.tx != nil {
+		g.tx.broken.Store(true)
+	}
+	g.mu.Unlock()
+}
+
+// wait waits for a state change, the mutex is held on entry and on
+// success, on error it is released
+func (g *txGate) wait(ctx context.Context) error {
+	if g.changed == nil {
+		g.changed = make(chan struct{})
+	}
+	changed := g.changed
+	g.mu.Unlock()
+	select {
+	case <-changed:
+		g.mu.Lock()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// notify wakes up all waiting callers, the mutex is held
+func (g *txGate) notify() {
+	if g.changed != nil {
+		close(g.changed)
+		g.changed = nil
+	}
+}
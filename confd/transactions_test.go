@@ -10,13 +10,15 @@ import (
 )
 
 func TestReadTransactions(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	rtx, err := conn.BeginReadTransaction()
-	assert.NoError(t, err)
+	if !assert.NoError(t, err) {
+		return
+	}
 
-	obj, err := conn.GetAnyObject("REF_AnonymousUser")
+	obj, err := rtx.GetAnyObject("REF_AnonymousUser")
 	assert.NoError(t, err)
 	assert.Equal(t, "aaa", obj.Class)
 	assert.Equal(t, "Anonymous user", obj.Data["comment"])
@@ -25,39 +27,45 @@ func TestReadTransactions(t *testing.T) {
 }
 
 func TestReadRollbackTransactions(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	rtx, err := conn.BeginReadTransaction()
-	assert.NoError(t, err)
+	if !assert.NoError(t, err) {
+		return
+	}
 	err = rtx.Rollback()
 	assert.NoError(t, err)
 }
 
 func TestWriteTransactions(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	wtx, err := conn.BeginWriteTransaction()
-	assert.NoError(t, err)
+	if !assert.NoError(t, err) {
+		return
+	}
 
 	err = wtx.Commit()
 	assert.NoError(t, err)
 }
 
 func TestWriteRollbackTransactions(t *testing.T) {
-	conn := systemConnHelper()
+	conn := systemConnHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	wtx, err := conn.BeginWriteTransaction()
-	assert.NoError(t, err)
+	if !assert.NoError(t, err) {
+		return
+	}
 
 	err = wtx.Rollback()
 	assert.NoError(t, err)
 }
 
 func TestWriteTransactionError(t *testing.T) {
-	conn := connHelper()
+	conn := connHelper(t)
 	defer func() { _ = conn.Close() }()
 
 	_, err := conn.BeginWriteTransaction()
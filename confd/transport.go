@@ -6,6 +6,8 @@ package confd
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -15,8 +17,12 @@ import (
 	"time"
 )
 
+// aLongTimeAgo is used as deadline to abort blocking reads and writes
+var aLongTimeAgo = time.Unix(1, 0)
+
 // Transport interface is used by connections to transport data to and from
-// the confd
+// the confd. The context of the request passed to RoundTrip has to be
+// honoured by the transport.
 type Transport interface {
 	Connect(url *url.URL) error
 	IsConnected() bool
@@ -24,27 +30,65 @@ type Transport interface {
 	http.RoundTripper
 }
 
+// ContextConnector is implemented by transports that can abort connecting
+// if the context is done
+type ContextConnector interface {
+	ConnectContext(ctx context.Context, url *url.URL) error
+}
+
+// connectTransport connects the transport using the context if supported
+func connectTransport(ctx context.Context, t Transport, url *url.URL) error {
+	if cc, ok := t.(ContextConnector); ok {
+		return cc.ConnectContext(ctx, url)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return t.Connect(url)
+}
+
 // TCPTransport implements a tcp+http RoundTripper for confd connections
 type tcpTransport struct {
 	Timeout     time.Duration // Timeout specifies the conn read/write timeout
 	LastRequest time.Time     // LastRequest last time a request was done
-	conn        *net.TCPConn
+	conn        net.Conn
 	mu          sync.RWMutex
 }
 
+// dialFunc establishes the stream used by the transport
+type dialFunc func(ctx context.Context) (net.Conn, error)
+
 // Connect to the passed url
 func (t *tcpTransport) Connect(url *url.URL) error {
+	return t.ConnectContext(context.Background(), url)
+}
+
+// ConnectContext connects to the passed url, dialing is aborted if the
+// context is done
+func (t *tcpTransport) ConnectContext(ctx context.Context, url *url.URL) error {
+	return t.connect(ctx, func(ctx context.Context) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "tcp", url.Host)
+	})
+}
+
+// connect uses the dial function to establish the connection
+func (t *tcpTransport) connect(ctx context.Context, dial dialFunc) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	conn, err := net.Dial("tcp", url.Host)
+	conn, err := dial(ctx)
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		return err
 	}
-	t.conn = conn.(*net.TCPConn)
+	t.conn = conn
 	return nil
 }
 
-// RoundTrip executes a request/response round trip
+// RoundTrip executes a request/response round trip. The round trip is
+// aborted if the context of the request is done before the timeout.
 func (t *tcpTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -53,28 +97,55 @@ func (t *tcpTransport) RoundTrip(req *http.Request) (resp *http.Response, err er
 		return nil, fmt.Errorf("Called confd.tcpTransport.RoundTrip " +
 			"without being connected!")
 	}
-	err = t.conn.SetDeadline(time.Now().Add(t.Timeout))
+	ctx := req.Context()
+	if err = ctx.Err(); err != nil {
+		return nil, err // nothing was send yet, connection stays usable
+	}
+	conn := t.conn
+	var stop func() bool
+	var body []byte
+
+	err = conn.SetDeadline(time.Now().Add(t.Timeout))
 	if err != nil {
 		goto err
 	}
+	// abort pending reads and writes as soon as the context is done
+	stop = context.AfterFunc(ctx, func() { _ = conn.SetDeadline(aLongTimeAgo) })
+	defer stop()
 
-	err = req.Write(t.conn)
+	err = req.Write(conn)
 	if err != nil {
 		goto err
 	}
 
 	// read response
-	resp, err = http.ReadResponse(bufio.NewReader(t.conn), nil)
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
 	t.LastRequest = time.Now()
 	if err != nil {
 		goto err
 	}
+	// the body is read while the deadline applies, afterwards the deadline
+	// is reset so that idle connections are not closed
+	body, err = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		goto err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		goto err
+	}
 
 	return
 err:
+	// report the reason of the abort instead of the deadline error
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
 	// close the connection on transport errors, so that we require a reconnect
 	_ = t.close() // ignore errors
-	return
+	return nil, err
 }
 
 // IsConnected returns
@@ -6,6 +6,7 @@ package confd
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -120,3 +121,63 @@ func TestTransportError(t *testing.T) {
 
 	assert.NoError(t, server.Close())
 }
+
+func TestTransportContextCancel(t *testing.T) {
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+
+	tcp := tcpTransport{Timeout: time.Second * 10}
+
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	err = tcp.ConnectContext(context.Background(), u)
+	assert.True(t, tcp.IsConnected())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+
+	buf := &bytes.Buffer{}
+	started := time.Now()
+	req, err := http.NewRequestWithContext(ctx, "POST", "/", buf)
+	assert.NoError(t, err)
+	resp, err := tcp.RoundTrip(req)
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, resp)
+	took := time.Since(started)
+
+	assert.False(t, tcp.IsConnected())
+
+	assert.True(t, took < time.Millisecond*200, "must be canceled before 200ms")
+	done <- true
+	server.Close()
+}
+
+func TestTransportContextDeadline(t *testing.T) {
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+
+	tcp := tcpTransport{Timeout: time.Second * 10}
+
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	assert.NoError(t, tcp.Connect(u))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	buf := &bytes.Buffer{}
+	started := time.Now()
+	req, err := http.NewRequestWithContext(ctx, "POST", "/", buf)
+	assert.NoError(t, err)
+	_, err = tcp.RoundTrip(req)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(started) < time.Millisecond*200,
+		"must timeout before 200ms")
+	done <- true
+	server.Close()
+}
@@ -0,0 +1,239 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+func TestTxDone(t *testing.T) {
+	srv := withTxHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	tx, err := conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, confd.ErrTxDone, tx.Commit())
+	assert.Equal(t, confd.ErrTxDone, tx.Rollback())
+	_, err = tx.SetNodeValue(1, "ntp", "status")
+	assert.Equal(t, confd.ErrTxDone, err)
+	value, _ := srv.Node("ntp", "status")
+	assert.Equal(t, float64(0), value)
+
+	rtx, err := conn.BeginReadTransaction()
+	assert.NoError(t, err)
+	assert.NoError(t, rtx.Rollback())
+	_, err = rtx.GetObjectClasses()
+	assert.Equal(t, confd.ErrTxDone, err)
+
+	_, err = conn.GetObjectClasses()
+	assert.NoError(t, err, "the connection can still be used")
+}
+
+func TestTxNested(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	tx, err := conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	_, err = tx.BeginWriteTransaction()
+	assert.Equal(t, confd.ErrTxNested, err)
+	_, err = tx.BeginReadTransaction()
+	assert.Equal(t, confd.ErrTxNested, err)
+	assert.NoError(t, tx.Commit())
+}
+
+func TestTxCallsOutsideWait(t *testing.T) {
+	srv := withTxHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	tx, err := conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	_, err = tx.SetNodeValue(1, "ntp", "status")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = conn.SetNodeValueContext(ctx, 2, "ntp", "status")
+	assert.Equal(t, context.DeadlineExceeded, err, "calls wait for the transaction")
+	_, err = conn.BeginWriteTransactionContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err, "transactions wait")
+
+	conn.NoTxWait = true
+	_, err = conn.GetNodeValue("ntp", "status")
+	assert.Equal(t, confd.ErrTxOpen, err)
+	conn.NoTxWait = false
+
+	done := make(chan error)
+	go func() {
+		_, err := conn.GetNodeValue("ntp", "status")
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("call didn't wait for the transaction")
+	case <-time.After(10 * time.Millisecond):
+	}
+	assert.NoError(t, tx.Rollback())
+	assert.NoError(t, <-done, "the call continues after the rollback")
+	value, _ := srv.Node("ntp", "status")
+	assert.Equal(t, float64(0), value)
+	sets := 0
+	for _, call := range srv.Calls() {
+		if call == "set" {
+			sets++
+		}
+	}
+	assert.Equal(t, 1, sets, "canceled calls were not sent")
+}
+
+func TestTxCommitFailed(t *testing.T) {
+	srv := withTxHelper()
+	defer srv.Close()
+	conn, transport := retryHelper(srv)
+	defer func() { _ = conn.Close() }()
+
+	tx, err := conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	_, err = tx.SetNodeValue(1, "ntp", "status")
+	assert.NoError(t, err)
+	transport.fail(1)
+	err = tx.Commit()
+	var terr *confd.ErrTransaction
+	if assert.ErrorAs(t, err, &terr) {
+		assert.ErrorIs(t, terr.Cause, errFlaky)
+		assert.NoError(t, terr.Rollback)
+	}
+	assert.Equal(t, "unlock", srv.Calls()[len(srv.Calls())-1])
+	assert.Equal(t, confd.ErrTxDone, tx.Rollback())
+	value, _ := srv.Node("ntp", "status")
+	assert.Equal(t, float64(0), value, "the transaction was rolled back")
+
+	tx, err = conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	transport.fail(2)
+	err = tx.Commit()
+	if assert.ErrorAs(t, err, &terr) {
+		assert.ErrorIs(t, terr.Cause, errFlaky)
+		assert.ErrorIs(t, terr.Rollback, errFlaky)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = conn.GetObjectClassesContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err, "the locked transaction holds the gate")
+	assert.Equal(t, confd.ErrTxBroken, tx.Rollback())
+	_, err = conn.GetObjectClasses()
+	assert.NoError(t, err)
+}
+
+// blockingTransport blocks the next round trip until it is released
+type blockingTransport struct {
+	confd.Transport
+	mu      sync.Mutex
+	started chan struct{}
+	release chan struct{}
+}
+
+func (t *blockingTransport) block() {
+	t.mu.Lock()
+	t.started, t.release = make(chan struct{}), make(chan struct{})
+	t.mu.Unlock()
+}
+
+func (t *blockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	started, release := t.started, t.release
+	t.started, t.release = nil, nil
+	t.mu.Unlock()
+	if release != nil {
+		close(started)
+		<-release
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+func TestTxWaitsForCalls(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	transport := &blockingTransport{Transport: conn.Transport}
+	conn.Transport = transport
+	assert.NoError(t, conn.Connect())
+
+	// the call is blocked while it requests the exports for the check
+	conn.ReadOnly = true
+	transport.block()
+	started, release := transport.started, transport.release
+	done := make(chan error)
+	go func() {
+		_, err := conn.GetObjectClasses()
+		done <- err
+	}()
+	<-started
+
+	began := make(chan *confd.Tx)
+	go func() {
+		tx, err := conn.BeginReadTransaction()
+		assert.NoError(t, err)
+		began <- tx
+	}()
+	select {
+	case <-began:
+		t.Fatal("transaction began during a call")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	assert.NoError(t, <-done)
+	tx := <-began
+	assert.NoError(t, tx.Commit())
+
+	calls := srv.Calls()
+	assert.Equal(t, []string{"get_exports", "get_object_classes", "freeze", "thaw"},
+		calls[len(calls)-4:], "the call wasn't executed inside of the transaction")
+}
+
+func TestTxBroken(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	conn, transport := retryHelper(srv)
+	defer func() { _ = conn.Close() }()
+
+	tx, err := conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	transport.fail(1)
+	_, err = tx.GetObjectClasses()
+	assert.ErrorIs(t, err, errFlaky)
+	_, err = tx.GetObjectClasses()
+	assert.Equal(t, confd.ErrTxBroken, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = conn.GetObjectClassesContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err, "the broken transaction holds the gate")
+
+	assert.Equal(t, confd.ErrTxBroken, tx.Commit())
+	assert.NotContains(t, srv.Calls(), "commit")
+	assert.Contains(t, srv.Calls(), "unlock")
+	_, err = conn.GetObjectClasses()
+	assert.NoError(t, err)
+	tx, err = conn.BeginWriteTransaction()
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+}
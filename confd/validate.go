@@ -0,0 +1,368 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/threez/sophos-utm9/internal/textutil"
+)
+
+// Validation rules reported in violations
+const (
+	RuleType     = "type"      // class and type of the object are unknown
+	RuleUnknown  = "unknown"   // attribute is not defined for the type
+	RuleRequired = "required"  // attribute without default is missing
+	RuleISA      = "isa"       // value doesn't match the _isa shape
+	RuleRegex    = "regex"     // value doesn't match _regex
+	RuleLimits   = "limits"    // value is outside of the _limits range
+	RuleValues   = "values"    // value is not one of the _values
+	RuleClass    = "class"     // referenced object has the wrong _class
+	RuleTypes    = "types"     // referenced object is not one of the _types
+	RuleNotTypes = "not_types" // referenced object is one of the _not_types
+	RuleRef      = "ref"       // referenced object can't be resolved
+)
+
+// Violation describes a single attribute that doesn't match its constraint
+type Violation struct {
+	Attribute string // name of the attribute, e.g. members[2] or ports.http
+	Rule      string // one of the Rule* constants
+	Message   string
+}
+
+func (v Violation) Error() string {
+	if v.Attribute == "" {
+		return v.Message
+	}
+	return v.Attribute + ": " + v.Message
+}
+
+// ValidationError is returned if an object violates the constraints
+// of the meta information
+type ValidationError struct {
+	Ref        string
+	Class      string
+	Type       string
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	errStr := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		errStr[i] = violation.Error()
+	}
+	return fmt.Sprintf("Invalid %s/%s object: %s", e.Class, e.Type,
+		strings.Join(errStr, " and "))
+}
+
+// Is returns true for ErrValidation
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// ByAttribute returns the violations grouped by the top level attribute
+func (e *ValidationError) ByAttribute() map[string][]Violation {
+	attrs := make(map[string][]Violation)
+	for _, violation := range e.Violations {
+		name := violation.Attribute
+		if i := strings.IndexAny(name, "[."); i >= 0 {
+			name = name[:i]
+		}
+		attrs[name] = append(attrs[name], violation)
+	}
+	return attrs
+}
+
+// RefResolver returns class and type of the referenced object
+type RefResolver func(ctx context.Context, ref string) (class, typ string, err error)
+
+// Validator checks objects against the meta information (see GetMetaObjects)
+// before they are send to confd. String limits are checked against the
+// length of the string. Regular expressions that are not supported by the
+// regexp package are ignored.
+type Validator struct {
+	Meta ObjectMetaTree
+	// Resolve is used to check the class and types of REF attributes, if
+	// nil references are not checked, e.g.: v.Resolve = conn.ResolveRefContext
+	Resolve RefResolver
+	regexps struct {
+		cache map[string]*regexp.Regexp // nil if regexp is not supported
+		sync.Mutex
+	}
+}
+
+// NewValidator creates a validator for the given meta information
+func NewValidator(meta ObjectMetaTree) *Validator {
+	return &Validator{Meta: meta}
+}
+
+// Validate validates the object, obj can be anything that marshals into a
+// confd object (e.g. AnyObject). Returns a *ValidationError if the object
+// violates the constraints.
+func (v *Validator) Validate(obj interface{}) error {
+	return v.ValidateContext(context.Background(), obj)
+}
+
+// ValidateContext is like Validate but honours the context
+func (v *Validator) ValidateContext(ctx context.Context, obj interface{}) error {
+	var object AnyObject
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, &object)
+	if err != nil {
+		return err
+	}
+
+	verr := &ValidationError{Ref: object.Ref, Class: object.Class, Type: object.Type}
+	attrs, ok := v.Meta[object.Class][object.Type]
+	if !ok {
+		verr.Violations = append(verr.Violations, Violation{
+			Rule:    RuleType,
+			Message: fmt.Sprintf("unknown class %q and type %q", object.Class, object.Type),
+		})
+		return verr
+	}
+
+	for _, name := range sortedAttributes(attrs, object.Data) {
+		constraint, defined := attrs[name]
+		value, present := object.Data[name]
+		switch {
+		case strings.HasPrefix(name, "_") || constraint.NameTemplate != "":
+			continue
+		case !defined:
+			verr.add(name, RuleUnknown, "attribute is not defined for %s/%s",
+				object.Class, object.Type)
+		case !present || value == nil:
+			if constraint.Default == nil {
+				verr.add(name, RuleRequired, "attribute is required")
+			}
+		default:
+			err = v.check(ctx, verr, name, AttrConstraint(constraint), value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(verr.Violations) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// check validates the value against the constraint, only resolver errors
+// are returned
+func (v *Validator) check(ctx context.Context, verr *ValidationError, name string, c AttrConstraint, value interface{}) error {
+	switch strings.ToUpper(c.ISA) {
+	case "ARRAY":
+		list, ok := value.([]interface{})
+		if !ok {
+			verr.add(name, RuleISA, "expected an array, got %T", value)
+			return nil
+		}
+		element := c
+		element.ISA = c.Type
+		for i, item := range list {
+			err := v.check(ctx, verr, fmt.Sprintf("%s[%d]", name, i), element, item)
+			if err != nil {
+				return err
+			}
+		}
+	case "HASH":
+		hash, ok := value.(map[string]interface{})
+		if !ok {
+			verr.add(name, RuleISA, "expected a hash, got %T", value)
+			return nil
+		}
+		values := valueConstraint(c.Values)
+		for _, key := range textutil.SortedKeys(hash) {
+			if c.Keys != nil {
+				err := v.check(ctx, verr, name+"."+key, *c.Keys, key)
+				if err != nil {
+					return err
+				}
+			}
+			if values != nil {
+				err := v.check(ctx, verr, name+"."+key, *values, hash[key])
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case "BOOL", "BOOLEAN":
+		switch tv := value.(type) {
+		case bool:
+		case float64:
+			if tv != 0 && tv != 1 {
+				verr.add(name, RuleISA, "expected a bool, got %v", tv)
+			}
+		default:
+			verr.add(name, RuleISA, "expected a bool, got %T", value)
+		}
+	case "INT", "INTEGER":
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			verr.add(name, RuleISA, "expected an integer, got %v", value)
+			return nil
+		}
+		v.checkLimits(verr, name, c, number)
+		v.checkValues(verr, name, c, value)
+	case "REF":
+		ref, ok := value.(string)
+		if !ok {
+			verr.add(name, RuleISA, "expected a reference, got %T", value)
+			return nil
+		}
+		return v.checkRef(ctx, verr, name, c, ref)
+	default:
+		str, ok := value.(string)
+		if !ok {
+			verr.add(name, RuleISA, "expected a string, got %T", value)
+			return nil
+		}
+		if re := v.regexp(c.Regex); re != nil && !re.MatchString(str) {
+			verr.add(name, RuleRegex, "%q doesn't match %s", str, c.Regex)
+		}
+		v.checkLimits(verr, name, c, float64(len(str)))
+		v.checkValues(verr, name, c, value)
+	}
+	return nil
+}
+
+// checkLimits checks that the number is in the range of the _limits. Empty
+// or missing limits are open ends.
+func (v *Validator) checkLimits(verr *ValidationError, name string, c AttrConstraint, number float64) {
+	if len(c.Limits) > 0 && c.Limits[0] != "" {
+		min, err := strconv.ParseFloat(c.Limits[0], 64)
+		if err == nil && number < min {
+			verr.add(name, RuleLimits, "%v is below the limit of %v", number, min)
+		}
+	}
+	if len(c.Limits) > 1 && c.Limits[1] != "" {
+		max, err := strconv.ParseFloat(c.Limits[1], 64)
+		if err == nil && number > max {
+			verr.add(name, RuleLimits, "%v is above the limit of %v", number, max)
+		}
+	}
+}
+
+// checkValues checks that the value is one of the _values enumeration
+func (v *Validator) checkValues(verr *ValidationError, name string, c AttrConstraint, value interface{}) {
+	values, ok := c.Values.([]interface{})
+	if !ok || len(values) == 0 {
+		return
+	}
+	for _, allowed := range values {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return
+		}
+	}
+	verr.add(name, RuleValues, "%v is not one of %v", value, values)
+}
+
+// checkRef checks the class and types of the referenced object, empty
+// references are not checked
+func (v *Validator) checkRef(ctx context.Context, verr *ValidationError, name string, c AttrConstraint, ref string) error {
+	if ref == "" || v.Resolve == nil {
+		return nil
+	}
+	class, typ, err := v.Resolve(ctx, ref)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		verr.add(name, RuleRef, "%s can't be resolved: %v", ref, err)
+		return nil
+	}
+	if c.Class != "" && class != c.Class {
+		verr.add(name, RuleClass, "%s is of class %s, expected %s", ref, class, c.Class)
+	}
+	if len(c.Types) > 0 && !containsString(c.Types, typ) {
+		verr.add(name, RuleTypes, "%s is of type %s, expected one of %s", ref,
+			typ, strings.Join(c.Types, ", "))
+	}
+	if containsString(c.NotTypes, typ) {
+		verr.add(name, RuleNotTypes, "%s must not be of type %s", ref, typ)
+	}
+	return nil
+}
+
+// regexp returns the compiled regular expression or nil if the expression
+// is empty or not supported
+func (v *Validator) regexp(expr string) *regexp.Regexp {
+	if expr == "" {
+		return nil
+	}
+	v.regexps.Lock()
+	defer v.regexps.Unlock()
+	if v.regexps.cache == nil {
+		v.regexps.cache = make(map[string]*regexp.Regexp)
+	}
+	re, ok := v.regexps.cache[expr]
+	if !ok {
+		re, _ = regexp.Compile(expr) // nil if not supported
+		v.regexps.cache[expr] = re
+	}
+	return re
+}
+
+// add adds a violation to the error
+func (e *ValidationError) add(name, rule, format string, args ...interface{}) {
+	e.Violations = append(e.Violations, Violation{
+		Attribute: name,
+		Rule:      rule,
+		Message:   fmt.Sprintf(format, args...),
+	})
+}
+
+// valueConstraint returns the constraint of hash values or nil
+func valueConstraint(values interface{}) *AttrConstraint {
+	if _, ok := values.(map[string]interface{}); !ok {
+		return nil
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil
+	}
+	var c AttrConstraint
+	if json.Unmarshal(data, &c) != nil || c.ISA == "" {
+		return nil
+	}
+	return &c
+}
+
+// sortedAttributes returns the names of the defined and given attributes
+func sortedAttributes(attrs AttributeDefinition, data map[string]interface{}) []string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	for name := range data {
+		if _, ok := attrs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func containsString(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+const validationMeta = `{
+	"network": {
+		"host": {
+			"name": {"_isa": "STRING", "_regex": "^\\S", "_limits": ["1", "10"]},
+			"comment": {"_isa": "STRING", "_default": ""},
+			"resolved": {"_isa": "BOOL", "_default": 0},
+			"interface": {"_isa": "REF", "_class": "interface", "_types": ["ethernet", "vlan"], "_default": ""},
+			"_name": "Host [%name%]"
+		},
+		"group": {
+			"name": {"_isa": "STRING"},
+			"members": {"_isa": "ARRAY", "_type": "REF", "_class": "network", "_not_types": ["group"], "_default": []}
+		}
+	},
+	"packetfilter": {
+		"rule": {
+			"name": {"_isa": "STRING"},
+			"action": {"_isa": "STRING", "_values": ["accept", "drop", "reject"], "_default": "drop"},
+			"ttl": {"_isa": "INT", "_limits": ["0", "255"], "_default": 64},
+			"options": {"_isa": "HASH", "_keys": {"_isa": "STRING", "_regex": "^[a-z]+$"}, "_values": {"_isa": "INT"}, "_default": {}}
+		}
+	}
+}`
+
+var validationObjects = map[string][2]string{
+	"REF_ItfEth0":  {"interface", "ethernet"},
+	"REF_ItfPpp0":  {"interface", "ppp"},
+	"REF_NetHost":  {"network", "host"},
+	"REF_NetGroup": {"network", "group"},
+}
+
+func validatorHelper(t *testing.T) *confd.Validator {
+	var meta confd.ObjectMetaTree
+	assert.NoError(t, json.Unmarshal([]byte(validationMeta), &meta))
+	v := confd.NewValidator(meta)
+	v.Resolve = func(ctx context.Context, ref string) (string, string, error) {
+		obj, ok := validationObjects[ref]
+		if !ok {
+			return "", "", fmt.Errorf("Object %s doesn't exist", ref)
+		}
+		return obj[0], obj[1], nil
+	}
+	return v
+}
+
+func object(class, typ string, data map[string]interface{}) confd.AnyObject {
+	return confd.AnyObject{
+		ObjectMeta: confd.ObjectMeta{Class: class, Type: typ},
+		Data:       data,
+	}
+}
+
+func violations(t *testing.T, err error) map[string][]string {
+	var verr *confd.ValidationError
+	if !assert.True(t, errors.As(err, &verr), "expected a validation error, got %v", err) {
+		return nil
+	}
+	rules := make(map[string][]string)
+	for _, violation := range verr.Violations {
+		rules[violation.Attribute] = append(rules[violation.Attribute], violation.Rule)
+	}
+	return rules
+}
+
+func TestValidateValidObject(t *testing.T) {
+	v := validatorHelper(t)
+
+	assert.NoError(t, v.Validate(object("network", "host", map[string]interface{}{
+		"name":      "Google",
+		"resolved":  true,
+		"interface": "REF_ItfEth0",
+	})))
+	assert.NoError(t, v.Validate(object("network", "group", map[string]interface{}{
+		"name":    "Group",
+		"members": []string{"REF_NetHost"},
+	})))
+	assert.NoError(t, v.Validate(object("packetfilter", "rule", map[string]interface{}{
+		"name":    "Rule",
+		"action":  "accept",
+		"ttl":     255,
+		"options": map[string]int{"log": 1},
+	})))
+}
+
+func TestValidateViolations(t *testing.T) {
+	v := validatorHelper(t)
+
+	err := v.Validate(object("network", "unknown", nil))
+	assert.Equal(t, map[string][]string{"": {confd.RuleType}}, violations(t, err))
+
+	err = v.Validate(object("network", "host", map[string]interface{}{
+		"name":      " a very long name",
+		"resolved":  "yes",
+		"interface": "REF_ItfPpp0",
+		"address":   "8.8.8.8",
+	}))
+	assert.Equal(t, map[string][]string{
+		"name":      {confd.RuleRegex, confd.RuleLimits},
+		"resolved":  {confd.RuleISA},
+		"interface": {confd.RuleTypes},
+		"address":   {confd.RuleUnknown},
+	}, violations(t, err))
+
+	err = v.Validate(object("network", "group", map[string]interface{}{
+		"members": []string{"REF_NetGroup", "REF_ItfEth0", "REF_Missing"},
+	}))
+	assert.Equal(t, map[string][]string{
+		"name":       {confd.RuleRequired},
+		"members[0]": {confd.RuleNotTypes},
+		"members[1]": {confd.RuleClass},
+		"members[2]": {confd.RuleRef},
+	}, violations(t, err))
+
+	err = v.Validate(object("packetfilter", "rule", map[string]interface{}{
+		"name":    42,
+		"action":  "allow",
+		"ttl":     1.5,
+		"options": map[string]interface{}{"Log": 1, "seq": "1"},
+	}))
+	assert.Equal(t, map[string][]string{
+		"name":        {confd.RuleISA},
+		"action":      {confd.RuleValues},
+		"ttl":         {confd.RuleISA},
+		"options.Log": {confd.RuleRegex},
+		"options.seq": {confd.RuleISA},
+	}, violations(t, err))
+
+	var verr *confd.ValidationError
+	assert.True(t, errors.As(err, &verr))
+	assert.Len(t, verr.ByAttribute()["options"], 2)
+	assert.True(t, strings.HasPrefix(err.Error(), "Invalid packetfilter/rule object: action: "))
+}
+
+func TestValidateWithoutResolver(t *testing.T) {
+	v := validatorHelper(t)
+	v.Resolve = nil
+
+	assert.NoError(t, v.Validate(object("network", "host", map[string]interface{}{
+		"name":      "Host",
+		"interface": "REF_Missing",
+	})))
+}
+
+func TestConnValidator(t *testing.T) {
+	srv := confdtest.NewServer()
+	defer srv.Close()
+	itf := object("interface", "ethernet", map[string]interface{}{"name": "eth0"})
+	itf.Ref = "REF_ItfEth0"
+	host := object("network", "host", map[string]interface{}{"name": "Host"})
+	host.Ref = "REF_NetHost"
+	srv.AddObjects(itf, host)
+
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	conn.Validator = validatorHelper(t)
+	conn.Validator.Resolve = conn.ResolveRefContext
+
+	class, typ, err := conn.ResolveRef(itf.Ref)
+	assert.NoError(t, err)
+	assert.Equal(t, "interface", class)
+	assert.Equal(t, "ethernet", typ)
+
+	_, err = conn.SetObject(object("network", "host", map[string]interface{}{
+		"name":      "Other",
+		"interface": host.Ref,
+	}), false)
+	assert.ErrorIs(t, err, confd.ErrValidation)
+	assert.Equal(t, map[string][]string{
+		"interface": {confd.RuleClass, confd.RuleTypes},
+	}, violations(t, err))
+
+	err = conn.ChangeObject(host.Ref, map[string]interface{}{"interface": itf.Ref})
+	assert.NoError(t, err)
+	err = conn.ChangeObject(host.Ref, map[string]interface{}{"name": ""})
+	assert.Equal(t, map[string][]string{
+		"name": {confd.RuleRegex, confd.RuleLimits},
+	}, violations(t, err))
+
+	obj, ok := srv.Object(host.Ref)
+	assert.True(t, ok)
+	assert.Equal(t, "Host", obj.Data["name"])
+	assert.Equal(t, itf.Ref, obj.Data["interface"])
+}
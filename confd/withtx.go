@@ -0,0 +1,92 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrTransaction is returned by WithWriteTransaction and WithReadTransaction
+// if the transaction was rolled back or the commit failed
+type ErrTransaction struct {
+	Cause    error   // Cause error of the function or the commit
+	Errors   ErrList // Errors collected by the confd during the transaction
+	Rollback error   // Rollback error if the rollback failed
+}
+
+func (e *ErrTransaction) Error() string {
+	str := fmt.Sprintf("Transaction failed: %v", e.Cause)
+	var errs ErrList
+	if len(e.Errors) > 0 && !errors.As(e.Cause, &errs) {
+		str += fmt.Sprintf(" (%v)", e.Errors)
+	}
+	if e.Rollback != nil {
+		str += fmt.Sprintf(", rollback failed: %v", e.Rollback)
+	}
+	return str
+}
+
+func (e *ErrTransaction) Unwrap() error {
+	return e.Cause
+}
+
+// WithWriteTransaction calls fn inside of a write transaction, fn has to use
+// the handle for its calls. The transaction is committed if fn returns nil,
+// otherwise and if fn panics it is rolled back, even if the context is done.
+// Errors are returned as *ErrTransaction including the errors collected by
+// the confd, panics are passed on after the rollback. A failed commit is
+// rolled back as well. If the rollback fails the connection stays bound to
+// the broken transaction and should be closed.
+func (c *Conn) WithWriteTransaction(ctx context.Context, fn func(tx *Tx) error) error {
+	tx, err := c.BeginWriteTransactionContext(ctx)
+	if err != nil {
+		return err
+	}
+	return c.withTransaction(ctx, tx, fn)
+}
+
+// WithReadTransaction is like WithWriteTransaction but uses a read
+// transaction
+func (c *Conn) WithReadTransaction(ctx context.Context, fn func(tx *Tx) error) error {
+	tx, err := c.BeginReadTransactionContext(ctx)
+	if err != nil {
+		return err
+	}
+	return c.withTransaction(ctx, tx, fn)
+}
+
+// withTransaction calls fn and ends the transaction
+func (c *Conn) withTransaction(ctx context.Context, tx *Tx, fn func(tx *Tx) error) (err error) {
+	endCtx := context.WithoutCancel(ctx)
+	done := false
+	defer func() {
+		if !done {
+			c.logf("!! Rollback transaction because of a panic")
+			_ = tx.RollbackContext(endCtx) // the panic is more important
+		}
+	}()
+	fnErr := fn(tx)
+	done = true
+
+	if fnErr != nil {
+		terr := &ErrTransaction{Cause: fnErr}
+		terr.Errors, _ = tx.ErrListContext(endCtx) // best effort
+		terr.Rollback = tx.RollbackContext(endCtx)
+		return terr
+	}
+	err = tx.CommitContext(endCtx)
+	if err == nil {
+		return nil
+	}
+	// a failed commit is unlocked by the handle, which reports both errors
+	terr, ok := err.(*ErrTransaction)
+	if !ok {
+		terr = &ErrTransaction{Cause: err}
+	}
+	errors.As(terr.Cause, &terr.Errors)
+	return terr
+}
@@ -0,0 +1,138 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package confd_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threez/sophos-utm9/confd"
+	"github.com/threez/sophos-utm9/confd/confdtest"
+)
+
+func withTxHelper() *confdtest.Server {
+	srv := confdtest.NewServer()
+	srv.SetNodes(map[string]interface{}{"ntp": map[string]interface{}{"status": 0}})
+	return srv
+}
+
+func TestWithWriteTransaction(t *testing.T) {
+	srv := withTxHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+	ctx := context.Background()
+
+	err := conn.WithWriteTransaction(ctx, func(tx *confd.Tx) error {
+		_, err := tx.SetNodeValue(1, "ntp", "status")
+		return err
+	})
+	assert.NoError(t, err)
+	value, _ := srv.Node("ntp", "status")
+	assert.Equal(t, float64(1), value)
+
+	failure := errors.New("failure")
+	err = conn.WithWriteTransaction(ctx, func(tx *confd.Tx) error {
+		_, err := tx.SetNodeValue(0, "ntp", "status")
+		assert.NoError(t, err)
+		ok, err := tx.SetNodeValue(1, "foo", "bar")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		return failure
+	})
+	var terr *confd.ErrTransaction
+	assert.True(t, errors.As(err, &terr))
+	assert.ErrorIs(t, err, failure)
+	assert.NoError(t, terr.Rollback)
+	if assert.Len(t, terr.Errors, 1) {
+		assert.Equal(t, "NODE_UNKNOWN", terr.Errors[0].MessageType)
+	}
+	assert.Equal(t, "Transaction failed: failure (FATAL [NODE_UNKNOWN] "+
+		"The node 'foo/bar' is unknown.)", err.Error())
+	value, _ = srv.Node("ntp", "status")
+	assert.Equal(t, float64(1), value, "transaction was rolled back")
+
+	assert.Panics(t, func() {
+		_ = conn.WithWriteTransaction(ctx, func(tx *confd.Tx) error {
+			_, err := tx.SetNodeValue(0, "ntp", "status")
+			assert.NoError(t, err)
+			panic("boom")
+		})
+	})
+	value, _ = srv.Node("ntp", "status")
+	assert.Equal(t, float64(1), value, "transaction was rolled back")
+
+	// the lock was released every time
+	other := srv.Conn()
+	defer func() { _ = other.Close() }()
+	assert.NoError(t, other.WithWriteTransaction(ctx, func(tx *confd.Tx) error {
+		return nil
+	}))
+}
+
+func TestWithTransactionCanceled(t *testing.T) {
+	srv := withTxHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := conn.WithWriteTransaction(ctx, func(tx *confd.Tx) error {
+		cancel()
+		_, err := tx.SetNodeValueContext(ctx, 1, "ntp", "status")
+		return err
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Contains(t, srv.Calls(), "unlock", "rollback ignores the canceled context")
+
+	assert.NoError(t, conn.WithWriteTransaction(context.Background(),
+		func(tx *confd.Tx) error { return nil }))
+}
+
+func TestWithTransactionCommitFailed(t *testing.T) {
+	srv := withTxHelper()
+	defer srv.Close()
+	conn, transport := retryHelper(srv)
+	defer func() { _ = conn.Close() }()
+
+	err := conn.WithWriteTransaction(context.Background(), func(tx *confd.Tx) error {
+		_, err := tx.SetNodeValue(1, "ntp", "status")
+		transport.fail(1) // the commit
+		return err
+	})
+	var terr *confd.ErrTransaction
+	if assert.ErrorAs(t, err, &terr) {
+		assert.ErrorIs(t, terr.Cause, errFlaky)
+		assert.False(t, errors.As(terr.Cause, new(*confd.ErrTransaction)), "not wrapped twice")
+		assert.NoError(t, terr.Rollback)
+	}
+	calls := srv.Calls()
+	assert.Equal(t, "unlock", calls[len(calls)-1], "the failed commit was unlocked")
+	value, _ := srv.Node("ntp", "status")
+	assert.Equal(t, float64(0), value)
+
+	assert.NoError(t, conn.WithWriteTransaction(context.Background(),
+		func(tx *confd.Tx) error { return nil }))
+}
+
+func TestWithReadTransaction(t *testing.T) {
+	srv := withTxHelper()
+	defer srv.Close()
+	conn := srv.Conn()
+	defer func() { _ = conn.Close() }()
+
+	var names []confd.NodeName
+	err := conn.WithReadTransaction(context.Background(), func(tx *confd.Tx) error {
+		var err error
+		names, err = tx.GetNodes("ntp")
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []confd.NodeName{"status"}, names)
+	assert.Contains(t, srv.Calls(), "freeze")
+	assert.Contains(t, srv.Calls(), "thaw")
+}
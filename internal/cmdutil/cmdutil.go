@@ -0,0 +1,33 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cmdutil contains the helpers shared by the commands
+package cmdutil
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/threez/sophos-utm9/confd"
+)
+
+// URLFlag defines the -url flag, the CONFD_URL environment variable is the
+// default
+func URLFlag(flags *flag.FlagSet) *string {
+	return flags.String("url", os.Getenv("CONFD_URL"), "url of the confd")
+}
+
+// Connect returns the connection for the url (see confd.NewConn) or the
+// local system connection if the url is empty
+func Connect(url string) (*confd.Conn, error) {
+	if url == "" {
+		return confd.NewSystemConn(), nil
+	}
+	conn, err := confd.NewConn(url)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid confd url: %v", err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,35 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmdutil
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLFlag(t *testing.T) {
+	t.Setenv("CONFD_URL", "http://env:4472/system")
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	url := URLFlag(flags)
+	assert.Equal(t, "http://env:4472/system", *url)
+	assert.NoError(t, flags.Parse([]string{"-url", "http://flag:4472/system"}))
+	assert.Equal(t, "http://flag:4472/system", *url)
+}
+
+func TestConnect(t *testing.T) {
+	conn, err := Connect("")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:4472", conn.URL.Host)
+
+	conn, err = Connect("http://utm:4472/system")
+	assert.NoError(t, err)
+	assert.Equal(t, "utm:4472", conn.URL.Host)
+
+	_, err = Connect("http://utm:port/")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid confd url")
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package textutil contains the helpers shared to produce stable text, e.g.
+// plans, diffs and generated code
+package textutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Format returns the value as compact JSON, values that can't be encoded are
+// formatted using fmt
+func Format(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// SortedKeys returns the keys of the map sorted
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// UnionKeys returns the keys of both maps sorted
+func UnionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,27 @@
+// Copyright 2016 Vincent Landgraf. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package textutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, `{"a":[1,"b"]}`, Format(map[string]interface{}{"a": []interface{}{1, "b"}}))
+	assert.Equal(t, `"REF_Host"`, Format("REF_Host"))
+	assert.Equal(t, "null", Format(nil))
+	assert.Contains(t, Format(func() {}), "0x", "not encodable")
+}
+
+func TestKeys(t *testing.T) {
+	a := map[string]int{"b": 1, "a": 2}
+	b := map[string]int{"c": 3, "a": 4}
+	assert.Equal(t, []string{"a", "b"}, SortedKeys(a))
+	assert.Equal(t, []string{}, SortedKeys(map[string]bool(nil)))
+	assert.Equal(t, []string{"a", "b", "c"}, UnionKeys(a, b))
+	assert.Equal(t, []string{"a", "c"}, UnionKeys(nil, b))
+}